@@ -9,6 +9,7 @@ import (
 
 	"github.com/isotoma/db-operator/pkg/apis"
 	"github.com/isotoma/db-operator/pkg/controller"
+	"github.com/isotoma/db-operator/pkg/metrics"
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	"github.com/operator-framework/operator-sdk/pkg/leader"
 	"github.com/operator-framework/operator-sdk/pkg/ready"
@@ -16,10 +17,14 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 	"sigs.k8s.io/controller-runtime/pkg/runtime/signals"
 )
 
+// metricsPort is the port metrics are served on, as exposed by the deployment
+const metricsPort = 60000
+
 var log = logf.Log.WithName("cmd")
 
 func printVersion() {
@@ -64,7 +69,10 @@ func main() {
 	defer r.Unset()
 
 	// Create a new Cmd to provide shared dependencies and start components
-	mgr, err := manager.New(cfg, manager.Options{Namespace: namespace})
+	mgr, err := manager.New(cfg, manager.Options{
+		Namespace:          namespace,
+		MetricsBindAddress: fmt.Sprintf(":%d", metricsPort),
+	})
 	if err != nil {
 		log.Error(err, "")
 		os.Exit(1)
@@ -84,6 +92,9 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Export the state of the resources from the manager's cache
+	crmetrics.Registry.MustRegister(&metrics.Collector{Client: mgr.GetClient()})
+
 	log.Info("Starting the Cmd.")
 
 	// Start the Cmd
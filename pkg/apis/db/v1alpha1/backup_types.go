@@ -10,8 +10,54 @@ const (
 	Starting  BackupPhase = "Starting"
 	BackingUp BackupPhase = "BackingUp"
 	Completed BackupPhase = "Completed"
+	// Failed backups have a driver job that failed. They are not retried:
+	// another backup is taken instead
+	Failed BackupPhase = "Failed"
+	// Pruning backups have expired under their retention policy, and are
+	// deleted once the stored backup has been removed
+	Pruning BackupPhase = "Pruning"
 )
 
+// BeforeDeleteLabel marks the backups taken before a database is deleted
+const BeforeDeleteLabel = "db.isotoma.com/before-delete"
+
+// VerificationLabel marks the scratch databases that backups are restored
+// into to verify them, with the name of the backup. They are not backed up
+// before they are deleted
+const VerificationLabel = "db.isotoma.com/verifies"
+
+// BackupVerified is the condition recording whether the backup could be
+// restored, and passed the driver's sanity check
+const BackupVerified ConditionType = "Verified"
+
+// Verification restores a completed backup into a scratch database, which
+// is dropped afterwards
+type Verification struct {
+	// Template is a Database whose provider, connection and credentials
+	// the scratch database is created with. Defaults to the database that
+	// was backed up
+	Template string `json:"template,omitempty"`
+}
+
+// RetentionPolicy decides which completed backups are kept. If any of the
+// Keep rules are given, a backup is kept only while one of them keeps it.
+// Backups older than MaxAge are pruned whatever the other rules say
+type RetentionPolicy struct {
+	// KeepLast keeps the most recent backups
+	KeepLast *int32 `json:"keepLast,omitempty"`
+	// KeepDaily, KeepWeekly and KeepMonthly keep the most recent backup of
+	// each of that many days, weeks or months, counting only those periods
+	// that have a backup
+	KeepDaily   *int32 `json:"keepDaily,omitempty"`
+	KeepWeekly  *int32 `json:"keepWeekly,omitempty"`
+	KeepMonthly *int32 `json:"keepMonthly,omitempty"`
+	// MaxAge prunes backups older than this
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+	// PruneBeforeDelete applies the policy to the backups taken before a
+	// database was deleted, which are otherwise kept
+	PruneBeforeDelete bool `json:"pruneBeforeDelete,omitempty"`
+}
+
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
@@ -19,11 +65,47 @@ const (
 type BackupSpec struct {
 	Database string `json:"database"`
 	Serial   string `json:"serial"`
+	// Verify the backup once it has completed. It is not verified by default
+	Verify *Verification `json:"verify,omitempty"`
 }
 
 // BackupStatus defines the observed state of Backup
 type BackupStatus struct {
 	Phase BackupPhase `json:"phase"`
+	// Destination is where the backup is written to
+	Destination string `json:"destination,omitempty"`
+	// BackupTo is the destination configuration the backup was written
+	// with, so that it can be pruned after the database has been changed
+	// or deleted
+	BackupTo *BackupTo `json:"backupTo,omitempty"`
+	// BackupToNamespace is where the credentials of the destination are
+	// read, which is the namespace of the instance if it was taken from it
+	BackupToNamespace string `json:"backupToNamespace,omitempty"`
+	// Instance the database was on, whose namespace the driver jobs of the
+	// backup run in, so that they are found after the database is deleted
+	Instance *InstanceRef `json:"instance,omitempty"`
+	// Compression and Encryption record how the backup was encoded, so
+	// that restores can reverse them
+	Compression Compression `json:"compression,omitempty"`
+	Encryption  string      `json:"encryption,omitempty"`
+	// Size in bytes of the backup as stored
+	Size int64 `json:"size,omitempty"`
+	// Checksum of the backup as stored, such as sha256:<hex digest>
+	Checksum       string       `json:"checksum,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Driver that made the backup, and its version. Drivers are registered
+	// under the name of their provider, so this also names the provider
+	// that prunes the backup once its database has been deleted
+	Driver        string `json:"driver,omitempty"`
+	DriverVersion string `json:"driverVersion,omitempty"`
+	// ServerVersion of the database server that was backed up
+	ServerVersion string `json:"serverVersion,omitempty"`
+	// Progress of the backup while BackingUp
+	Progress *Progress `json:"progress,omitempty"`
+	// Conditions are Ready, Progressing, Degraded and, if the backup is
+	// verified, Verified
+	Conditions []Condition `json:"conditions,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
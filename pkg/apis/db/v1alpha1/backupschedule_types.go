@@ -0,0 +1,72 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConcurrencyPolicy describes how a scheduled backup is treated when the
+// previous backup of the database has not yet completed
+type ConcurrencyPolicy string
+
+const (
+	// ForbidConcurrent skips the new backup
+	ForbidConcurrent ConcurrencyPolicy = "Forbid"
+	// ReplaceConcurrent deletes the previous backup and starts a new one
+	ReplaceConcurrent ConcurrencyPolicy = "Replace"
+)
+
+// BackupScheduleLabel is set on the backups created by a schedule to the
+// name of the schedule
+const BackupScheduleLabel = "db.isotoma.com/backup-schedule"
+
+// BackupScheduleSpec defines the desired state of BackupSchedule
+type BackupScheduleSpec struct {
+	// Database to back up
+	Database string `json:"database,omitempty"`
+	// Selector of the databases to back up, as an alternative to Database
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// Schedule of backups in cron format
+	Schedule string `json:"schedule"`
+	// TimeZone the schedule is in, such as Europe/London. Defaults to UTC
+	TimeZone string `json:"timeZone,omitempty"`
+	// ConcurrencyPolicy defaults to Forbid
+	ConcurrencyPolicy ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+	// StartingDeadlineSeconds is how late a backup may start after its
+	// scheduled time. Later backups are skipped. Defaults to no deadline
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+	// Retention applies to the backups made by this schedule
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+	// Verify each backup made by this schedule
+	Verify *Verification `json:"verify,omitempty"`
+}
+
+// BackupScheduleStatus defines the observed state of BackupSchedule
+type BackupScheduleStatus struct {
+	// LastScheduleTime is when backups were last scheduled
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BackupSchedule is the Schema for the backupschedules API
+// +k8s:openapi-gen=true
+type BackupSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BackupScheduleSpec   `json:"spec,omitempty"`
+	Status BackupScheduleStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BackupScheduleList contains a list of BackupSchedule
+type BackupScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BackupSchedule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BackupSchedule{}, &BackupScheduleList{})
+}
@@ -0,0 +1,46 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConditionType is the aspect of a resource a condition describes
+type ConditionType string
+
+// ConditionStatus is whether a condition holds
+type ConditionStatus string
+
+const (
+	ConditionTrue    ConditionStatus = "True"
+	ConditionFalse   ConditionStatus = "False"
+	ConditionUnknown ConditionStatus = "Unknown"
+)
+
+// Condition describes one aspect of the state of a resource, following the
+// Kubernetes API conventions
+type Condition struct {
+	Type   ConditionType   `json:"type"`
+	Status ConditionStatus `json:"status"`
+	// ObservedGeneration is the generation of the resource the condition
+	// was set for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastTransitionTime is when the status last changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a CamelCase reason for the status
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable explanation of the status
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	// ConditionReady is True when the resource can be used
+	ConditionReady ConditionType = "Ready"
+	// ConditionProgressing is True while an operation is under way
+	ConditionProgressing ConditionType = "Progressing"
+	// ConditionDegraded is True when an operation has failed, or the
+	// resource is not as it should be
+	ConditionDegraded ConditionType = "Degraded"
+	// ConditionBackupHealthy is True when the latest backup of a database
+	// completed, and passed verification if it was verified
+	ConditionBackupHealthy ConditionType = "BackupHealthy"
+)
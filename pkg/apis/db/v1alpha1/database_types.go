@@ -18,12 +18,28 @@ const (
 	BackupBeforeDeleteRequested  DatabasePhase = "BackupBeforeDeleteRequested"
 	BackupBeforeDeleteInProgress DatabasePhase = "BackupBeforeDeleteInProgress"
 	BackupBeforeDeleteCompleted  DatabasePhase = "BackupBeforeDeleteCompleted"
+	RotationRequested            DatabasePhase = "RotationRequested"
+	RotationInProgress           DatabasePhase = "RotationInProgress"
+	Populating                   DatabasePhase = "Populating"
 )
 
+// RotateAnnotation requests that the password of the database user is
+// rotated as soon as possible. It is removed once the rotation has begun
+const RotateAnnotation = "db.isotoma.com/rotate"
+
+// AllowNamespacesAnnotation is set on a secret, a database or an instance,
+// to list the namespaces, separated by commas, whose databases may
+// reference, clone or be on it. "*" allows any namespace. Those in the
+// database's own namespace are always allowed
+const AllowNamespacesAnnotation = "db.isotoma.com/allow-namespaces"
+
 // SecretKeyRef references to a kubernetes secret key
 type SecretKeyRef struct {
 	Name string `json:"name"`
 	Key  string `json:"key"`
+	// Namespace of the secret, if not that of the database. The secret
+	// must allow this with the AllowNamespacesAnnotation
+	Namespace string `json:"namespace,omitempty"`
 }
 
 // AwsSecretRef references a secret in AWS Secrets Manager
@@ -32,10 +48,30 @@ type AwsSecretRef struct {
 	Key string `json:"key"`
 }
 
+// VaultSecretRef references a key of a secret in a HashiCorp Vault KV
+// secrets engine, read after logging in with the Kubernetes auth method
+type VaultSecretRef struct {
+	// Address of the Vault server. Defaults to VAULT_ADDR in the driver
+	// job, and must be it or one of its VAULT_ALLOWED_ADDRS
+	Address string `json:"address,omitempty"`
+	// Mount of the KV secrets engine. Defaults to secret
+	Mount string `json:"mount,omitempty"`
+	// Path of the secret within the mount
+	Path string `json:"path"`
+	Key  string `json:"key"`
+	// KVVersion of the secrets engine, 1 or 2. Defaults to 2
+	KVVersion int `json:"kvVersion,omitempty"`
+	// Role to log in as with the driver job's service account
+	Role string `json:"role"`
+	// AuthPath is the mount of the Kubernetes auth method. Defaults to kubernetes
+	AuthPath string `json:"authPath,omitempty"`
+}
+
 // ValueFrom supports retrieving a credential from elsewhere
 type ValueFrom struct {
-	SecretKeyRef    SecretKeyRef `json:"secretKeyRef"`
-	AwsSecretKeyRef AwsSecretRef `json:"awsSecretKeyRef"`
+	SecretKeyRef    SecretKeyRef   `json:"secretKeyRef"`
+	AwsSecretKeyRef AwsSecretRef   `json:"awsSecretKeyRef"`
+	VaultSecretRef  VaultSecretRef `json:"vaultSecretRef"`
 }
 
 // Credential supports either a literal value, or retrieving from elsewhere
@@ -50,16 +86,107 @@ type Credentials struct {
 	Password Credential `json:"password"`
 }
 
-// S3Backup provides destination storage for S3 backups
+// S3Backup provides destination storage for S3 backups, or for S3
+// compatible stores such as MinIO or Ceph if an Endpoint is given
 type S3Backup struct {
 	Region string `json:"region"`
 	Bucket string `json:"bucket"`
 	Prefix string `json:"prefix"`
+	// Endpoint is the URL of an S3 compatible store
+	Endpoint string `json:"endpoint,omitempty"`
+	// ForcePathStyle addresses the bucket in the path of requests, rather
+	// than the host name, as most S3 compatible stores require
+	ForcePathStyle bool      `json:"forcePathStyle,omitempty"`
+	TLS            TLSConfig `json:"tls,omitempty"`
+}
+
+// TLSConfig controls how the certificate of a store is verified
+type TLSConfig struct {
+	// CABundle is PEM encoded certificates to trust, as well as the
+	// system's own
+	CABundle string `json:"caBundle,omitempty"`
+	// InsecureSkipVerify disables verification entirely
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// GCSBackup provides destination storage in Google Cloud Storage
+type GCSBackup struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix,omitempty"`
+	// Endpoint overrides the storage API, such as for an emulator
+	Endpoint string `json:"endpoint,omitempty"`
+	// ServiceAccountKey is the JSON key of a service account. Without it
+	// the driver job's default Google credentials are used
+	ServiceAccountKey *Credential `json:"serviceAccountKey,omitempty"`
 }
 
-// BackupTo may support other destinations than S3
+// AzureBackup provides destination storage in Azure Blob Storage
+type AzureBackup struct {
+	Account   string `json:"account"`
+	Container string `json:"container"`
+	Prefix    string `json:"prefix,omitempty"`
+	// Endpoint overrides the blob service URL, which defaults to
+	// https://<account>.blob.core.windows.net
+	Endpoint string `json:"endpoint,omitempty"`
+	// SASToken is a shared access signature granting read, write and
+	// delete access to the container
+	SASToken Credential `json:"sasToken"`
+	// BlockSizeMiB is the size of the blocks backups are uploaded in, from
+	// 1 to 100. A blob has at most 50000 blocks, so the default of 4
+	// limits backups to about 195GiB. Each block is held in memory while
+	// it is uploaded
+	BlockSizeMiB int `json:"blockSizeMiB,omitempty"`
+}
+
+// PVCBackup provides destination storage on a PersistentVolumeClaim, which
+// is mounted into the driver jobs
+type PVCBackup struct {
+	ClaimName string `json:"claimName"`
+	// SubPath within the claim to store backups under. It is a template,
+	// which may use {{.Namespace}} and {{.Database}}
+	SubPath string `json:"subPath,omitempty"`
+}
+
+// Compression of backup streams
+type Compression string
+
+const (
+	GzipCompression Compression = "gzip"
+	ZstdCompression Compression = "zstd"
+)
+
+// AgeEncryption records that a backup was encrypted with age
+const AgeEncryption = "age"
+
+// Encryption encrypts backups with age (https://age-encryption.org),
+// either to the public keys of Recipients or with a Passphrase
+type Encryption struct {
+	// Recipients are age public keys, such as age1ql3z7hjy54pw3hyww5...
+	Recipients []string `json:"recipients,omitempty"`
+	// Identities are the age secret keys, one per line, that backups are
+	// decrypted with when they are restored into this database
+	Identities *Credential `json:"identities,omitempty"`
+	// Passphrase encrypts and decrypts backups, instead of keys
+	Passphrase *Credential `json:"passphrase,omitempty"`
+}
+
+// BackupTo is where backups of the database are stored. Only one
+// destination should be given
 type BackupTo struct {
-	S3 S3Backup `json:"s3"`
+	S3    S3Backup    `json:"s3"`
+	GCS   GCSBackup   `json:"gcs,omitempty"`
+	Azure AzureBackup `json:"azure,omitempty"`
+	PVC   PVCBackup   `json:"pvc,omitempty"`
+	// Compression of backups. They are not compressed by default
+	Compression Compression `json:"compression,omitempty"`
+	// Encryption of backups, after compression. They are not encrypted by
+	// default
+	Encryption *Encryption `json:"encryption,omitempty"`
+}
+
+// Configured returns true if a destination has been given
+func (b BackupTo) Configured() bool {
+	return b.S3.Bucket != "" || b.GCS.Bucket != "" || b.Azure.Container != "" || b.PVC.ClaimName != ""
 }
 
 // AwsCredentials are literal AWS credentials used for backups and secrets
@@ -69,19 +196,107 @@ type AwsCredentials struct {
 	SecretAccessKey string `json:"secretAccessKey"`
 }
 
+// PasswordPolicy controls how the password of the database user is generated
+type PasswordPolicy struct {
+	// Length of the password. Defaults to 32
+	Length int `json:"length,omitempty"`
+	// Charset the password is drawn from. Defaults to letters and digits
+	Charset string `json:"charset,omitempty"`
+}
+
+// Rotation controls when the password of the database user is changed.
+// Either an interval or a cron schedule may be given
+type Rotation struct {
+	// Interval between rotations, such as 2160h for 90 days
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// Schedule of rotations in cron format, such as "0 3 1 * *"
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// Progress of a long running driver operation. It is cleared when the
+// phase of the resource changes
+type Progress struct {
+	// Bytes processed so far. For backups and restores this is the bytes
+	// of the stored backup written or read
+	Bytes int64 `json:"bytes,omitempty"`
+	// Total bytes expected, if known
+	Total int64 `json:"total,omitempty"`
+	// Percent complete, if known
+	Percent int32 `json:"percent,omitempty"`
+	// Stage is a description of what the driver is doing
+	Stage      string      `json:"stage,omitempty"`
+	UpdateTime metav1.Time `json:"updateTime"`
+}
+
+// DatabaseRef references a database, in another namespace if given
+type DatabaseRef struct {
+	Name string `json:"name"`
+	// Namespace of the database, if not that of the database referencing
+	// it. The database must allow this with the AllowNamespacesAnnotation
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// InstanceRef references a database instance, in another namespace if
+// given
+type InstanceRef struct {
+	Name string `json:"name"`
+	// Namespace of the instance, if not that of the database. The instance
+	// must allow this with the AllowNamespacesAnnotation
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// DatabaseSource is what a database is populated from when it is created.
+// Only one source should be given
+type DatabaseSource struct {
+	// Database to clone, which must use the same provider
+	Database *DatabaseRef `json:"database,omitempty"`
+	// Backup to restore, in the namespace of the database
+	Backup string `json:"backup,omitempty"`
+}
+
 // DatabaseSpec defines the desired state of Database
 type DatabaseSpec struct {
-	Provider       string            `json:"provider"`
+	// Instance the database is on. The provider, connection details and
+	// credentials are then those of the instance, as are the backup
+	// destination and retention unless the database gives its own
+	Instance       *InstanceRef      `json:"instance,omitempty"`
+	Provider       string            `json:"provider,omitempty"`
 	Name           string            `json:"name"`
-	Connect        map[string]string `json:"connect"`
-	Credentials    Credentials       `json:"credentials"`
+	Connect        map[string]string `json:"connect,omitempty"`
+	Credentials    Credentials       `json:"credentials,omitempty"`
 	BackupTo       BackupTo          `json:"backupTo,omitempty"`
 	AwsCredentials AwsCredentials    `json:"awsCredentials,omitempty"`
+	PasswordPolicy PasswordPolicy    `json:"passwordPolicy,omitempty"`
+	Rotation       Rotation          `json:"rotation,omitempty"`
+	// Retention applies to all backups of the database, except those made
+	// by a BackupSchedule with its own retention policy
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+	// Source the database is populated from when it is created. It is
+	// created empty by default
+	Source *DatabaseSource `json:"source,omitempty"`
 }
 
 // DatabaseStatus defines the observed state of Database
 type DatabaseStatus struct {
 	Phase DatabasePhase `json:"phase"`
+	// LastRotated is when the password of the database user was last changed
+	LastRotated *metav1.Time `json:"lastRotated,omitempty"`
+	// Progress of the operation the driver is performing
+	Progress *Progress `json:"progress,omitempty"`
+	// PopulatedFrom is the source the database was populated from, such as
+	// backup/mydb-x7k2q or database/namespace/mydb
+	PopulatedFrom string `json:"populatedFrom,omitempty"`
+	// Conditions are Ready, Progressing, Degraded and BackupHealthy
+	Conditions []Condition `json:"conditions,omitempty"`
+	// FailedJobs is how many times in a row the driver job of the current
+	// phase has failed. The job is retried with a backoff that grows with it
+	FailedJobs int32 `json:"failedJobs,omitempty"`
+}
+
+// Populated returns true once the database has been populated from its
+// source, or if it has none
+func (d *Database) Populated() bool {
+	return d.Spec.Source == nil || d.Status.PopulatedFrom != ""
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -0,0 +1,58 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DatabaseInstanceSpec defines the desired state of DatabaseInstance
+type DatabaseInstanceSpec struct {
+	// Provider of the driver for the server, in the namespace of the
+	// instance. The driver jobs of databases on the instance run there
+	Provider string            `json:"provider"`
+	Connect  map[string]string `json:"connect"`
+	// Credentials of the master user, read in the namespace of the instance
+	Credentials    Credentials    `json:"credentials"`
+	AwsCredentials AwsCredentials `json:"awsCredentials,omitempty"`
+	// BackupTo is where databases on the instance are backed up to, unless
+	// they give their own destination
+	BackupTo BackupTo `json:"backupTo,omitempty"`
+	// Retention applies to databases on the instance without their own
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+}
+
+// DatabaseInstanceStatus defines the observed state of DatabaseInstance
+type DatabaseInstanceStatus struct {
+	// Conditions are Ready, once the server has been reached, and Degraded
+	Conditions []Condition `json:"conditions,omitempty"`
+	// ServerVersion is the version of the server, as reported by the driver
+	ServerVersion string `json:"serverVersion,omitempty"`
+	// Databases is the number of databases on the instance
+	Databases int `json:"databases"`
+	// CheckedTime is when the driver last tried to reach the server
+	CheckedTime *metav1.Time `json:"checkedTime,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// DatabaseInstance is the Schema for the databaseinstances API
+// +k8s:openapi-gen=true
+type DatabaseInstance struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DatabaseInstanceSpec   `json:"spec,omitempty"`
+	Status DatabaseInstanceStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// DatabaseInstanceList contains a list of DatabaseInstance
+type DatabaseInstanceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DatabaseInstance `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DatabaseInstance{}, &DatabaseInstanceList{})
+}
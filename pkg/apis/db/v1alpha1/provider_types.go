@@ -15,12 +15,46 @@ type ProviderSpec struct {
 	Image   string   `json:"image,omitempty"`
 	Command []string `json:"command,omitempty"`
 	Args    []string `json:"args,omitempty"`
+	// ServiceAccountName is the service account driver jobs run as. It
+	// must be able to read and update the db-operator resources
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// Probe runs a job with the image to find out which drivers it
+	// registers, and what they are capable of
+	Probe bool `json:"probe,omitempty"`
+}
+
+// ProviderProbed is the condition of a provider recording the result of its
+// probe job
+const ProviderProbed ConditionType = "Probed"
+
+// DriverInfo describes a driver registered by the image of a provider
+type DriverInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// Capabilities are the operations the driver implements, such as
+	// create, backup or clone
+	Capabilities []string `json:"capabilities,omitempty"`
 }
 
 // ProviderStatus defines the observed state of Provider
 type ProviderStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
+	// Conditions are Ready, Degraded and, if the provider is probed, Probed
+	Conditions []Condition `json:"conditions,omitempty"`
+	// Drivers are those the probe job found registered by the image
+	Drivers []DriverInfo `json:"drivers,omitempty"`
+	// ProbedGeneration is the generation of the provider the drivers were
+	// probed for
+	ProbedGeneration int64 `json:"probedGeneration,omitempty"`
+}
+
+// Driver returns the probed driver with the given name, or nil
+func (s *ProviderStatus) Driver(name string) *DriverInfo {
+	for i := range s.Drivers {
+		if s.Drivers[i].Name == name {
+			return &s.Drivers[i]
+		}
+	}
+	return nil
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
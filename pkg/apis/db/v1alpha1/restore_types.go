@@ -0,0 +1,59 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type RestorePhase string
+
+const (
+	RestoreStarting  RestorePhase = "Starting"
+	Restoring        RestorePhase = "Restoring"
+	RestoreCompleted RestorePhase = "Completed"
+	// RestoreFailed restores have a driver job that failed. They are not
+	// retried: another restore is created instead
+	RestoreFailed RestorePhase = "Failed"
+)
+
+// RestoreSpec defines the desired state of Restore
+type RestoreSpec struct {
+	// Backup to restore from
+	Backup string `json:"backup"`
+	// Database to restore into
+	Database string `json:"database"`
+	// DropAndRecreate drops the database and creates it again, empty,
+	// before restoring into it
+	DropAndRecreate bool `json:"dropAndRecreate,omitempty"`
+}
+
+// RestoreStatus defines the observed state of Restore
+type RestoreStatus struct {
+	Phase RestorePhase `json:"phase"`
+	// Progress of the restore while Restoring
+	Progress *Progress `json:"progress,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Restore is the Schema for the restores API
+// +k8s:openapi-gen=true
+type Restore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RestoreSpec   `json:"spec,omitempty"`
+	Status RestoreStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RestoreList contains a list of Restore
+type RestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Restore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Restore{}, &RestoreList{})
+}
@@ -4,14 +4,16 @@ import (
 	"context"
 
 	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -21,11 +23,6 @@ import (
 
 var log = logf.Log.WithName("controller_backup")
 
-/**
-* USER ACTION REQUIRED: This is a scaffold file intended for the user to modify with their own Controller
-* business logic.  Delete these comments after modifying this file.*
- */
-
 // Add creates a new Backup Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
@@ -34,7 +31,11 @@ func Add(mgr manager.Manager) error {
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileBackup{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+	return &ReconcileBackup{
+		client:   mgr.GetClient(),
+		scheme:   mgr.GetScheme(),
+		recorder: mgr.GetRecorder("backup-controller"),
+	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -51,9 +52,8 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
-	// TODO(user): Modify this to be the types you create that are owned by the primary resource
-	// Watch for changes to secondary resource Pods and requeue the owner Backup
-	err = c.Watch(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestForOwner{
+	// Watch for changes to the driver Jobs and requeue the owner Backup
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
 		OwnerType:    &dbv1alpha1.Backup{},
 	})
@@ -61,6 +61,25 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for changes to the driver Jobs run in the namespace of an
+	// instance, which the Backup cannot own
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, util.EnqueueJobOwner("Backup"))
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the scratch Databases and Restores used to
+	// verify backups
+	for _, t := range []runtime.Object{&dbv1alpha1.Database{}, &dbv1alpha1.Restore{}} {
+		err = c.Watch(&source.Kind{Type: t}, &handler.EnqueueRequestForOwner{
+			IsController: true,
+			OwnerType:    &dbv1alpha1.Backup{},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -70,14 +89,115 @@ var _ reconcile.Reconciler = &ReconcileBackup{}
 type ReconcileBackup struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
-	client client.Client
-	scheme *runtime.Scheme
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+}
+
+// UpdatePhase updates the phase of the backup to the one requested
+func (r *ReconcileBackup) UpdatePhase(instance *dbv1alpha1.Backup, phase dbv1alpha1.BackupPhase) error {
+	previous := instance.Status.Phase
+	instance.Status.Phase = phase
+	if err := r.client.Status().Update(context.TODO(), instance); err != nil {
+		return err
+	}
+	if previous != phase {
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, string(phase), "Backup is %s", phase)
+	}
+	return nil
+}
+
+// jobName returns the name of the job that performs op on the backup
+func jobName(instance *dbv1alpha1.Backup, op util.Operation) string {
+	return instance.Name + "-" + string(op)
+}
+
+// getJob returns the job launched to perform op on the backup, or nil if
+// there is no such job
+func (r *ReconcileBackup) getJob(instance *dbv1alpha1.Backup, op util.Operation) (*batchv1.Job, error) {
+	job := &batchv1.Job{}
+	key := util.DriverJobKey(instance.Status.Instance, instance.Namespace, jobName(instance, op))
+	err := r.client.Get(context.TODO(), key, job)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// launchJob creates a driver job to perform op on the backup, using the
+// provider of the database that was backed up, unless one already exists.
+// Verification is performed against the scratch database instead
+func (r *ReconcileBackup) launchJob(instance *dbv1alpha1.Backup, op util.Operation) error {
+	found, err := r.getJob(instance, op)
+	if err != nil || found != nil {
+		return err
+	}
+	name := instance.Spec.Database
+	if op == util.VerifyOperation {
+		name = scratchName(instance)
+	}
+	database := &dbv1alpha1.Database{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{
+		Namespace: instance.Namespace,
+		Name:      name,
+	}, database)
+	switch {
+	case errors.IsNotFound(err) && op == util.PruneOperation:
+		// Backups are pruned after their database has been deleted, by
+		// the provider that made them
+		database = &dbv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+			Spec:       dbv1alpha1.DatabaseSpec{Provider: instance.Status.Driver},
+		}
+	case err != nil:
+		return err
+	default:
+		if database, err = util.ResolveDatabase(r.client, database); err != nil {
+			return err
+		}
+		// The instance is recorded before any job is launched, so that
+		// the jobs are found in its namespace
+		if ref := util.InstanceOf(database); ref != nil && instance.Status.Instance == nil {
+			instance.Status.Instance = ref
+			if err := r.client.Status().Update(context.TODO(), instance); err != nil {
+				return err
+			}
+		}
+	}
+	// The provider of a database on an instance in another namespace is
+	// taken from there, as its jobs are given the master credentials
+	provider, err := util.GetProvider(r.client, util.JobNamespace(instance.Status.Instance, instance.Namespace), database.Spec.Provider)
+	if err != nil {
+		r.recorder.Event(instance, corev1.EventTypeWarning, util.ProviderMissingReason, err.Error())
+		return err
+	}
+	job := util.DriverJob(provider, jobName(instance, op), instance.Namespace, op, database.Name,
+		corev1.EnvVar{Name: "DB_OPERATOR_BACKUP", Value: instance.Name})
+	// Once a backup is under way its destination is recorded, which must be
+	// used in case the database has since been changed
+	claim := database.Spec.BackupTo.PVC.ClaimName
+	if instance.Status.Destination != "" {
+		claim = util.BackupClaim(instance.Status.Destination)
+	}
+	if claim != "" {
+		util.MountClaim(job, claim)
+	}
+	if err := util.PlaceDriverJob(r.client, r.scheme, job, instance, "Backup", instance.Status.Instance); err != nil {
+		return err
+	}
+	log.Info("Creating driver job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+	if err := r.client.Create(context.TODO(), job); err != nil {
+		return err
+	}
+	r.recorder.Eventf(instance, corev1.EventTypeNormal, util.JobLaunchedReason, "Launched %s job %s", op, job.Name)
+	return nil
 }
 
 // Reconcile reads that state of the cluster for a Backup object and makes changes based on the state read
 // and what is in the Backup.Spec
-// TODO(user): Modify this Reconcile function to implement your Controller logic.  This example creates
-// a Pod as an example
 // Note:
 // The Controller will requeue the Request to be processed again if the returned error is non-nil or
 // Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
@@ -99,54 +219,56 @@ func (r *ReconcileBackup) Reconcile(request reconcile.Request) (reconcile.Result
 		return reconcile.Result{}, err
 	}
 
-	// Define a new Pod object
-	pod := newPodForCR(instance)
-
-	// Set Backup instance as the owner and controller
-	if err := controllerutil.SetControllerReference(instance, pod, r.scheme); err != nil {
-		return reconcile.Result{}, err
+	// The conditions are brought up to date whatever happened, so that an
+	// error is reflected in them
+	result, err := r.reconcile(instance)
+	if condErr := r.reconcileConditions(request.NamespacedName); condErr != nil && err == nil {
+		return result, condErr
 	}
+	return result, err
+}
 
-	// Check if this Pod already exists
-	found := &corev1.Pod{}
-	err = r.client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, found)
-	if err != nil && errors.IsNotFound(err) {
-		reqLogger.Info("Creating a new Pod", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
-		err = r.client.Create(context.TODO(), pod)
+// reconcile moves the backup through its phases
+func (r *ReconcileBackup) reconcile(instance *dbv1alpha1.Backup) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+	switch instance.Status.Phase {
+	case "":
+		if err := r.launchJob(instance, util.BackupOperation); err != nil {
+			return reconcile.Result{}, err
+		}
+		if err := r.UpdatePhase(instance, dbv1alpha1.Starting); err != nil {
+			return reconcile.Result{}, err
+		}
+	case dbv1alpha1.Starting, dbv1alpha1.BackingUp:
+		// The driver reports BackingUp and Completed itself, but we follow
+		// the job too in case it completed without doing so
+		job, err := r.getJob(instance, util.BackupOperation)
 		if err != nil {
 			return reconcile.Result{}, err
 		}
-
-		// Pod created successfully - don't requeue
-		return reconcile.Result{}, nil
-	} else if err != nil {
-		return reconcile.Result{}, err
+		switch {
+		case job == nil:
+			return reconcile.Result{}, r.launchJob(instance, util.BackupOperation)
+		case util.JobSucceeded(job):
+			return reconcile.Result{}, r.UpdatePhase(instance, dbv1alpha1.Completed)
+		case util.JobFailed(job):
+			reqLogger.Info("Backup job failed", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			r.recorder.Eventf(instance, corev1.EventTypeWarning, util.JobFailedReason, "Job %s failed", job.Name)
+			return reconcile.Result{}, r.UpdatePhase(instance, dbv1alpha1.Failed)
+		}
+	case dbv1alpha1.Failed:
+		// Failed backups are pruned under the retention policy too, as
+		// part of the backup may have been stored
+		return r.reconcileRetention(instance)
+	case dbv1alpha1.Completed:
+		// The backup is verified if requested, and kept until it is
+		// deleted or its retention policy prunes it
+		if err := r.reconcileVerification(instance); err != nil {
+			return reconcile.Result{}, err
+		}
+		return r.reconcileRetention(instance)
+	case dbv1alpha1.Pruning:
+		return r.prune(instance)
 	}
-
-	// Pod already exists - don't requeue
-	reqLogger.Info("Skip reconcile: Pod already exists", "Pod.Namespace", found.Namespace, "Pod.Name", found.Name)
 	return reconcile.Result{}, nil
 }
-
-// newPodForCR returns a busybox pod with the same name/namespace as the cr
-func newPodForCR(cr *dbv1alpha1.Backup) *corev1.Pod {
-	labels := map[string]string{
-		"app": cr.Name,
-	}
-	return &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      cr.Name + "-pod",
-			Namespace: cr.Namespace,
-			Labels:    labels,
-		},
-		Spec: corev1.PodSpec{
-			Containers: []corev1.Container{
-				{
-					Name:    "busybox",
-					Image:   "busybox",
-					Command: []string{"sleep", "3600"},
-				},
-			},
-		},
-	}
-}
@@ -0,0 +1,214 @@
+package backup
+
+import (
+	"context"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func fakeReconciler(objs []runtime.Object) *ReconcileBackup {
+	s := scheme.Scheme
+	s.AddKnownTypes(dbv1alpha1.SchemeGroupVersion,
+		&dbv1alpha1.Database{}, &dbv1alpha1.Backup{}, &dbv1alpha1.BackupList{},
+		&dbv1alpha1.BackupSchedule{}, &dbv1alpha1.BackupScheduleList{},
+		&dbv1alpha1.Restore{}, &dbv1alpha1.RestoreList{},
+		&dbv1alpha1.Provider{}, &dbv1alpha1.ProviderList{},
+		&dbv1alpha1.DatabaseInstance{})
+	cl := fake.NewFakeClient(objs...)
+	return &ReconcileBackup{client: cl, scheme: s, recorder: &record.FakeRecorder{}}
+}
+
+func testObjects() []runtime.Object {
+	return []runtime.Object{
+		&dbv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Name: "testdb", Namespace: "testns"},
+			Spec:       dbv1alpha1.DatabaseSpec{Provider: "postgresql"},
+		},
+		&dbv1alpha1.Provider{
+			ObjectMeta: metav1.ObjectMeta{Name: "postgresql-provider", Namespace: "testns"},
+			Spec: dbv1alpha1.ProviderSpec{
+				Name:  "postgresql",
+				Image: "isotoma/db-operator-postgresql",
+			},
+		},
+		&dbv1alpha1.Backup{
+			ObjectMeta: metav1.ObjectMeta{Name: "testbackup", Namespace: "testns"},
+			Spec:       dbv1alpha1.BackupSpec{Database: "testdb"},
+		},
+	}
+}
+
+func reconcileBackup(t *testing.T, r *ReconcileBackup) *dbv1alpha1.Backup {
+	key := types.NamespacedName{Namespace: "testns", Name: "testbackup"}
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	backup := &dbv1alpha1.Backup{}
+	if err := r.client.Get(context.TODO(), key, backup); err != nil {
+		t.Fatalf("Unable to get backup: %s", err)
+	}
+	return backup
+}
+
+func TestReconcileLaunchesJob(t *testing.T) {
+	r := fakeReconciler(testObjects())
+	backup := reconcileBackup(t, r)
+	if backup.Status.Phase != dbv1alpha1.Starting {
+		t.Errorf("Backup not moved to Starting")
+	}
+	job, err := r.getJob(backup, util.BackupOperation)
+	if err != nil || job == nil {
+		t.Fatalf("No backup job launched")
+	}
+	env := map[string]string{}
+	for _, e := range job.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["DB_OPERATOR_BACKUP"] != "testbackup" || env["DB_OPERATOR_DATABASE"] != "testdb" {
+		t.Errorf("Backup job environment incorrect: %v", env)
+	}
+	if env["DB_OPERATOR_OPERATION"] != "backup" {
+		t.Errorf("Backup job operation incorrect: %s", env["DB_OPERATOR_OPERATION"])
+	}
+}
+
+func TestReconcileMountsClaim(t *testing.T) {
+	objs := testObjects()
+	objs[0].(*dbv1alpha1.Database).Spec.BackupTo.PVC.ClaimName = "backups"
+	r := fakeReconciler(objs)
+	backup := reconcileBackup(t, r)
+	job, err := r.getJob(backup, util.BackupOperation)
+	if err != nil || job == nil {
+		t.Fatalf("No backup job launched")
+	}
+	volumes := job.Spec.Template.Spec.Volumes
+	if len(volumes) != 1 || volumes[0].PersistentVolumeClaim.ClaimName != "backups" {
+		t.Errorf("Backup claim not mounted: %v", volumes)
+	}
+}
+
+func TestReconcileOnInstance(t *testing.T) {
+	objs := testObjects()
+	objs[0].(*dbv1alpha1.Database).Spec = dbv1alpha1.DatabaseSpec{
+		Instance: &dbv1alpha1.InstanceRef{Name: "testinstance", Namespace: "dbas"},
+	}
+	r := fakeReconciler(append(objs,
+		&dbv1alpha1.DatabaseInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "testinstance",
+				Namespace:   "dbas",
+				Annotations: map[string]string{dbv1alpha1.AllowNamespacesAnnotation: "testns"},
+			},
+			Spec: dbv1alpha1.DatabaseInstanceSpec{Provider: "postgresql"},
+		},
+		&dbv1alpha1.Provider{
+			ObjectMeta: metav1.ObjectMeta{Name: "postgresql-provider", Namespace: "dbas"},
+			Spec:       dbv1alpha1.ProviderSpec{Name: "postgresql", Image: "isotoma/db-operator-postgresql:instance"},
+		},
+	))
+	backup := reconcileBackup(t, r)
+	if backup.Status.Instance == nil || backup.Status.Instance.Namespace != "dbas" {
+		t.Fatalf("Instance not recorded on the backup: %v", backup.Status.Instance)
+	}
+	job, err := r.getJob(backup, util.BackupOperation)
+	if err != nil || job == nil {
+		t.Fatalf("No backup job launched")
+	}
+	if job.Namespace != "dbas" || job.Name != "testns-testbackup-backup" {
+		t.Errorf("Job %s/%s not run in the namespace of the instance", job.Namespace, job.Name)
+	}
+	if job.Spec.Template.Spec.Containers[0].Image != "isotoma/db-operator-postgresql:instance" {
+		t.Errorf("Job does not use the provider of the instance's namespace")
+	}
+}
+
+func TestReconcileCompletesWithJob(t *testing.T) {
+	r := fakeReconciler(testObjects())
+	reconcileBackup(t, r)
+	backup := reconcileBackup(t, r)
+	if backup.Status.Phase != dbv1alpha1.Starting {
+		t.Errorf("Backup moved on before the job completed")
+	}
+	job, _ := r.getJob(backup, util.BackupOperation)
+	job.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+	}
+	if err := r.client.Update(context.TODO(), job); err != nil {
+		t.Fatalf("Unable to update job: %s", err)
+	}
+	backup = reconcileBackup(t, r)
+	if backup.Status.Phase != dbv1alpha1.Completed {
+		t.Errorf("Backup not completed after the job completed")
+	}
+}
+
+func TestReconcileFailsWithJob(t *testing.T) {
+	r := fakeReconciler(testObjects())
+	reconcileBackup(t, r)
+	backup := reconcileBackup(t, r)
+	job, _ := r.getJob(backup, util.BackupOperation)
+	job.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+	}
+	if err := r.client.Update(context.TODO(), job); err != nil {
+		t.Fatalf("Unable to update job: %s", err)
+	}
+	backup = reconcileBackup(t, r)
+	if backup.Status.Phase != dbv1alpha1.Failed {
+		t.Errorf("Backup not failed after the job failed: %s", backup.Status.Phase)
+	}
+	degraded := util.FindCondition(backup.Status.Conditions, dbv1alpha1.ConditionDegraded)
+	if degraded == nil || degraded.Status != dbv1alpha1.ConditionTrue || degraded.Reason != util.JobFailedReason {
+		t.Errorf("Failed backup not Degraded: %v", degraded)
+	}
+	progressing := util.FindCondition(backup.Status.Conditions, dbv1alpha1.ConditionProgressing)
+	if progressing == nil || progressing.Status != dbv1alpha1.ConditionFalse {
+		t.Errorf("Failed backup still Progressing: %v", progressing)
+	}
+	// Nothing is relaunched once the backup has failed
+	backup = reconcileBackup(t, r)
+	if backup.Status.Phase != dbv1alpha1.Failed {
+		t.Errorf("Failed backup moved on: %s", backup.Status.Phase)
+	}
+}
+
+func TestReconcileMissingDatabase(t *testing.T) {
+	r := fakeReconciler(testObjects()[2:])
+	key := types.NamespacedName{Namespace: "testns", Name: "testbackup"}
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err == nil {
+		t.Errorf("Reconcile did not fail for a missing database")
+	}
+}
+
+func TestReconcileCompletedEvent(t *testing.T) {
+	objs := testObjects()
+	backup := objs[2].(*dbv1alpha1.Backup)
+	backup.Status.Phase = dbv1alpha1.Completed
+	backup.Status.Size = 42
+	backup.Status.Destination = "s3://bucket/testdb/testbackup"
+	r := fakeReconciler(objs)
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+	reconcileBackup(t, r)
+	reconcileBackup(t, r)
+	close(recorder.Events)
+	var found []string
+	for e := range recorder.Events {
+		found = append(found, e)
+	}
+	expected := "Normal BackupCompleted Backup of testdb completed, 42 bytes stored at s3://bucket/testdb/testbackup"
+	if len(found) != 1 || found[0] != expected {
+		t.Errorf("Expected one completion event, got %v", found)
+	}
+}
@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// phaseOperations maps the phases in which a driver job is run, or has
+// failed, to its operation
+var phaseOperations = map[dbv1alpha1.BackupPhase]util.Operation{
+	dbv1alpha1.Starting:  util.BackupOperation,
+	dbv1alpha1.BackingUp: util.BackupOperation,
+	dbv1alpha1.Failed:    util.BackupOperation,
+	dbv1alpha1.Pruning:   util.PruneOperation,
+}
+
+// reconcileConditions brings the conditions of the backup up to date with
+// its phase and the job run in its phase. As for databases, a Degraded
+// condition set by the driver is left for it to clear
+func (r *ReconcileBackup) reconcileConditions(key types.NamespacedName) error {
+	instance := &dbv1alpha1.Backup{}
+	if err := r.client.Get(context.TODO(), key, instance); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	ready, progressing := util.BackupPhaseConditions(instance)
+	var degraded *dbv1alpha1.Condition
+	if op, ok := phaseOperations[instance.Status.Phase]; ok {
+		job, err := r.getJob(instance, op)
+		if err != nil {
+			return err
+		}
+		if job != nil && util.JobFailed(job) {
+			c := util.Degraded(instance.Generation, util.JobFailedReason, fmt.Errorf("Job %s failed", job.Name))
+			degraded = &c
+		}
+	}
+	if degraded == nil {
+		existing := util.FindCondition(instance.Status.Conditions, dbv1alpha1.ConditionDegraded)
+		if existing == nil || existing.Reason == util.JobFailedReason {
+			c := util.Degraded(instance.Generation, "", nil)
+			degraded = &c
+		}
+	}
+	previous := util.FindCondition(instance.Status.Conditions, dbv1alpha1.ConditionReady)
+	if ready.Status == dbv1alpha1.ConditionTrue && (previous == nil || previous.Status != dbv1alpha1.ConditionTrue) {
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, "BackupCompleted", "Backup of %s completed, %d bytes stored at %s",
+			instance.Spec.Database, instance.Status.Size, instance.Status.Destination)
+	}
+	changed := util.SetCondition(&instance.Status.Conditions, ready)
+	changed = util.SetCondition(&instance.Status.Conditions, progressing) || changed
+	if degraded != nil {
+		changed = util.SetCondition(&instance.Status.Conditions, *degraded) || changed
+	}
+	if !changed {
+		return nil
+	}
+	return r.client.Status().Update(context.TODO(), instance)
+}
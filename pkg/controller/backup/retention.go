@@ -0,0 +1,221 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// period returns a key identifying the period t falls in, so that backups
+// in the same period share a key
+type period func(t time.Time) string
+
+func day(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+func week(t time.Time) string {
+	year, week := t.UTC().ISOWeek()
+	return fmt.Sprintf("%d-%d", year, week)
+}
+
+func month(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// keepPeriods marks the most recent backup of each of the n most recent
+// periods that have a backup as kept. The backups are newest first
+func keepPeriods(backups []dbv1alpha1.Backup, n *int32, key period, keep map[string]bool) {
+	if n == nil {
+		return
+	}
+	last := ""
+	var count int32
+	for _, backup := range backups {
+		if count >= *n {
+			return
+		}
+		if k := key(backup.CreationTimestamp.Time); k != last {
+			keep[backup.Name] = true
+			last = k
+			count++
+		}
+	}
+}
+
+// expired returns the backups that the policy no longer keeps. Failed
+// backups are never kept by the rules, but are only pruned once a later
+// backup has completed, or by age, so that the failure is not forgotten
+func expired(policy *dbv1alpha1.RetentionPolicy, backups []dbv1alpha1.Backup, now time.Time) []dbv1alpha1.Backup {
+	candidates := []dbv1alpha1.Backup{}
+	for _, backup := range backups {
+		finished := backup.Status.Phase == dbv1alpha1.Completed || backup.Status.Phase == dbv1alpha1.Failed
+		if !finished || backup.DeletionTimestamp != nil {
+			continue
+		}
+		if backup.Labels[dbv1alpha1.BeforeDeleteLabel] == "true" && !policy.PruneBeforeDelete {
+			continue
+		}
+		candidates = append(candidates, backup)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		ti, tj := candidates[i].CreationTimestamp.Time, candidates[j].CreationTimestamp.Time
+		if ti.Equal(tj) {
+			return candidates[i].Name > candidates[j].Name
+		}
+		return ti.After(tj)
+	})
+
+	completed := []dbv1alpha1.Backup{}
+	for _, backup := range candidates {
+		if backup.Status.Phase == dbv1alpha1.Completed {
+			completed = append(completed, backup)
+		}
+	}
+
+	rules := policy.KeepLast != nil || policy.KeepDaily != nil || policy.KeepWeekly != nil || policy.KeepMonthly != nil
+	keep := map[string]bool{}
+	if policy.KeepLast != nil {
+		for i := 0; i < len(completed) && i < int(*policy.KeepLast); i++ {
+			keep[completed[i].Name] = true
+		}
+	}
+	keepPeriods(completed, policy.KeepDaily, day, keep)
+	keepPeriods(completed, policy.KeepWeekly, week, keep)
+	keepPeriods(completed, policy.KeepMonthly, month, keep)
+
+	result := []dbv1alpha1.Backup{}
+	superseded := false
+	for _, backup := range candidates {
+		tooOld := policy.MaxAge != nil && now.Sub(backup.CreationTimestamp.Time) > policy.MaxAge.Duration
+		switch {
+		case tooOld:
+			result = append(result, backup)
+		case backup.Status.Phase == dbv1alpha1.Failed:
+			if superseded {
+				result = append(result, backup)
+			}
+		case rules && !keep[backup.Name]:
+			result = append(result, backup)
+		}
+		if backup.Status.Phase == dbv1alpha1.Completed {
+			superseded = true
+		}
+	}
+	return result
+}
+
+// retentionPolicy returns the retention policy that applies to the backup,
+// and a function reporting whether another backup is subject to the same
+// policy. The policy is nil if the backup is kept until it is deleted
+func (r *ReconcileBackup) retentionPolicy(instance *dbv1alpha1.Backup) (*dbv1alpha1.RetentionPolicy, func(*dbv1alpha1.Backup) bool, error) {
+	if name, ok := instance.Labels[dbv1alpha1.BackupScheduleLabel]; ok {
+		schedule := &dbv1alpha1.BackupSchedule{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: name}, schedule)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, nil, err
+		}
+		if err == nil && schedule.Spec.Retention != nil {
+			return schedule.Spec.Retention, func(b *dbv1alpha1.Backup) bool {
+				return b.Labels[dbv1alpha1.BackupScheduleLabel] == name
+			}, nil
+		}
+	}
+	database := &dbv1alpha1.Database{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: instance.Spec.Database}, database)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	// Databases on an instance may take its retention policy
+	if database, err = util.ResolveDatabase(r.client, database); err != nil {
+		return nil, nil, err
+	}
+	if database.Spec.Retention == nil {
+		return nil, nil, nil
+	}
+	// Backups made by schedules with their own policy are left to it
+	schedules := &dbv1alpha1.BackupScheduleList{}
+	if err := r.client.List(context.TODO(), &client.ListOptions{Namespace: instance.Namespace}, schedules); err != nil {
+		return nil, nil, err
+	}
+	governed := map[string]bool{}
+	for _, schedule := range schedules.Items {
+		governed[schedule.Name] = schedule.Spec.Retention != nil
+	}
+	return database.Spec.Retention, func(b *dbv1alpha1.Backup) bool {
+		return b.Spec.Database == instance.Spec.Database && !governed[b.Labels[dbv1alpha1.BackupScheduleLabel]]
+	}, nil
+}
+
+// reconcileRetention moves the backups that have expired under the
+// retention policy of the backup to Pruning, and requeues the backup for
+// when it will expire by age
+func (r *ReconcileBackup) reconcileRetention(instance *dbv1alpha1.Backup) (reconcile.Result, error) {
+	policy, related, err := r.retentionPolicy(instance)
+	if err != nil || policy == nil {
+		return reconcile.Result{}, err
+	}
+	list := &dbv1alpha1.BackupList{}
+	if err := r.client.List(context.TODO(), &client.ListOptions{Namespace: instance.Namespace}, list); err != nil {
+		return reconcile.Result{}, err
+	}
+	backups := []dbv1alpha1.Backup{}
+	for i := range list.Items {
+		if related(&list.Items[i]) {
+			backups = append(backups, list.Items[i])
+		}
+	}
+	now := time.Now()
+	pruned := expired(policy, backups, now)
+	for i := range pruned {
+		log.Info("Pruning expired backup", "Backup.Namespace", pruned[i].Namespace, "Backup.Name", pruned[i].Name)
+		if err := r.UpdatePhase(&pruned[i], dbv1alpha1.Pruning); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	if policy.MaxAge != nil {
+		if wait := instance.CreationTimestamp.Add(policy.MaxAge.Duration).Sub(now); wait > 0 {
+			return reconcile.Result{RequeueAfter: wait}, nil
+		}
+	}
+	return reconcile.Result{}, nil
+}
+
+// prune removes the stored backup, then deletes the backup resource
+func (r *ReconcileBackup) prune(instance *dbv1alpha1.Backup) (reconcile.Result, error) {
+	if instance.Status.Destination != "" {
+		job, err := r.getJob(instance, util.PruneOperation)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		switch {
+		case job == nil:
+			return reconcile.Result{}, r.launchJob(instance, util.PruneOperation)
+		case util.JobFailed(job):
+			log.Info("Prune job failed", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			r.recorder.Eventf(instance, corev1.EventTypeWarning, util.JobFailedReason, "Job %s failed", job.Name)
+			return reconcile.Result{}, nil
+		case !util.JobSucceeded(job):
+			return reconcile.Result{}, nil
+		}
+	}
+	log.Info("Deleting pruned backup", "Backup.Namespace", instance.Namespace, "Backup.Name", instance.Name)
+	err := r.client.Delete(context.TODO(), instance, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !errors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, nil
+}
@@ -0,0 +1,210 @@
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var now = time.Date(2019, 3, 31, 12, 0, 0, 0, time.UTC)
+
+func int32p(i int32) *int32 {
+	return &i
+}
+
+func completedBackup(name string, age time.Duration) dbv1alpha1.Backup {
+	return dbv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "testns",
+			CreationTimestamp: metav1.NewTime(now.Add(-age)),
+		},
+		Spec:   dbv1alpha1.BackupSpec{Database: "testdb"},
+		Status: dbv1alpha1.BackupStatus{Phase: dbv1alpha1.Completed},
+	}
+}
+
+func names(backups []dbv1alpha1.Backup) map[string]bool {
+	result := map[string]bool{}
+	for _, backup := range backups {
+		result[backup.Name] = true
+	}
+	return result
+}
+
+func TestExpiredKeepLast(t *testing.T) {
+	backups := []dbv1alpha1.Backup{
+		completedBackup("a", 3*time.Hour),
+		completedBackup("b", 1*time.Hour),
+		completedBackup("c", 2*time.Hour),
+	}
+	result := names(expired(&dbv1alpha1.RetentionPolicy{KeepLast: int32p(2)}, backups, now))
+	if len(result) != 1 || !result["a"] {
+		t.Errorf("Expected only the oldest backup to expire, got %v", result)
+	}
+}
+
+func TestExpiredKeepDaily(t *testing.T) {
+	backups := []dbv1alpha1.Backup{
+		completedBackup("today-late", 1*time.Hour),
+		completedBackup("today-early", 6*time.Hour),
+		completedBackup("yesterday", 30*time.Hour),
+		completedBackup("last-week", 7*24*time.Hour),
+	}
+	policy := &dbv1alpha1.RetentionPolicy{KeepDaily: int32p(2)}
+	result := names(expired(policy, backups, now))
+	if len(result) != 2 || !result["today-early"] || !result["last-week"] {
+		t.Errorf("Expected today-early and last-week to expire, got %v", result)
+	}
+}
+
+func TestExpiredRulesCombine(t *testing.T) {
+	backups := []dbv1alpha1.Backup{
+		completedBackup("today", 1*time.Hour),
+		completedBackup("last-month", 35*24*time.Hour),
+		completedBackup("two-months", 65*24*time.Hour),
+	}
+	policy := &dbv1alpha1.RetentionPolicy{KeepLast: int32p(1), KeepMonthly: int32p(2)}
+	result := names(expired(policy, backups, now))
+	if len(result) != 1 || !result["two-months"] {
+		t.Errorf("Expected two-months to expire, got %v", result)
+	}
+}
+
+func TestExpiredMaxAge(t *testing.T) {
+	backups := []dbv1alpha1.Backup{
+		completedBackup("new", 1*time.Hour),
+		completedBackup("old", 48*time.Hour),
+	}
+	policy := &dbv1alpha1.RetentionPolicy{
+		KeepLast: int32p(5),
+		MaxAge:   &metav1.Duration{Duration: 24 * time.Hour},
+	}
+	result := names(expired(policy, backups, now))
+	if len(result) != 1 || !result["old"] {
+		t.Errorf("Expected old to expire, got %v", result)
+	}
+}
+
+func TestExpiredSkipsIncompleteAndBeforeDelete(t *testing.T) {
+	running := completedBackup("running", 48*time.Hour)
+	running.Status.Phase = dbv1alpha1.BackingUp
+	beforeDelete := completedBackup("before-delete", 48*time.Hour)
+	beforeDelete.Labels = map[string]string{dbv1alpha1.BeforeDeleteLabel: "true"}
+	backups := []dbv1alpha1.Backup{running, beforeDelete}
+	policy := &dbv1alpha1.RetentionPolicy{MaxAge: &metav1.Duration{Duration: 24 * time.Hour}}
+	if result := expired(policy, backups, now); len(result) != 0 {
+		t.Errorf("Expected nothing to expire, got %v", names(result))
+	}
+	policy.PruneBeforeDelete = true
+	result := names(expired(policy, backups, now))
+	if len(result) != 1 || !result["before-delete"] {
+		t.Errorf("Expected before-delete to expire, got %v", result)
+	}
+}
+
+func TestExpiredFailed(t *testing.T) {
+	failed := func(name string, age time.Duration) dbv1alpha1.Backup {
+		backup := completedBackup(name, age)
+		backup.Status.Phase = dbv1alpha1.Failed
+		return backup
+	}
+	backups := []dbv1alpha1.Backup{
+		failed("latest-failed", 1*time.Hour),
+		completedBackup("completed", 2*time.Hour),
+		failed("earlier-failed", 3*time.Hour),
+		completedBackup("earliest", 4*time.Hour),
+	}
+	// Failed backups take no place of a completed one kept by the rules
+	policy := &dbv1alpha1.RetentionPolicy{KeepLast: int32p(2)}
+	result := names(expired(policy, backups, now))
+	if len(result) != 1 || !result["earlier-failed"] {
+		t.Errorf("Expected only earlier-failed to expire, got %v", result)
+	}
+	policy = &dbv1alpha1.RetentionPolicy{MaxAge: &metav1.Duration{Duration: 30 * time.Minute}}
+	result = names(expired(policy, backups, now))
+	if len(result) != 4 {
+		t.Errorf("Expected every backup to expire by age, got %v", result)
+	}
+}
+
+func TestReconcileRetention(t *testing.T) {
+	// The backups are created relative to the real clock, as the controller
+	// uses it to decide expiry
+	current := time.Now()
+	old := completedBackup("old", 0)
+	old.CreationTimestamp = metav1.NewTime(current.Add(-48 * time.Hour))
+	old.Status.Destination = "s3://bucket/testdb/old"
+	latest := completedBackup("latest", 0)
+	latest.CreationTimestamp = metav1.NewTime(current.Add(-time.Hour))
+	objs := testObjects()
+	objs[0].(*dbv1alpha1.Database).Spec.Retention = &dbv1alpha1.RetentionPolicy{
+		MaxAge: &metav1.Duration{Duration: 24 * time.Hour},
+	}
+	r := fakeReconciler(append(objs[:2], &old, &latest))
+
+	key := types.NamespacedName{Namespace: "testns", Name: "latest"}
+	result, err := r.Reconcile(reconcile.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if result.RequeueAfter <= 22*time.Hour || result.RequeueAfter > 23*time.Hour {
+		t.Errorf("Expected requeue for when latest expires, got %s", result.RequeueAfter)
+	}
+	pruned := &dbv1alpha1.Backup{}
+	oldKey := types.NamespacedName{Namespace: "testns", Name: "old"}
+	if err := r.client.Get(context.TODO(), oldKey, pruned); err != nil {
+		t.Fatalf("Unable to get backup: %s", err)
+	}
+	if pruned.Status.Phase != dbv1alpha1.Pruning {
+		t.Fatalf("Expired backup not moved to Pruning")
+	}
+
+	// Pruning launches a job to remove the stored backup
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: oldKey}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	job, err := r.getJob(pruned, util.PruneOperation)
+	if err != nil || job == nil {
+		t.Fatalf("No prune job launched")
+	}
+}
+
+func TestReconcilePruneWithoutDestination(t *testing.T) {
+	backup := completedBackup("old", 48*time.Hour)
+	backup.Status.Phase = dbv1alpha1.Pruning
+	r := fakeReconciler([]runtime.Object{&backup})
+	key := types.NamespacedName{Namespace: "testns", Name: "old"}
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if err := r.client.Get(context.TODO(), key, &dbv1alpha1.Backup{}); err == nil {
+		t.Errorf("Pruned backup not deleted")
+	}
+}
+
+func TestReconcilePruneDatabaseDeleted(t *testing.T) {
+	backup := completedBackup("old", 48*time.Hour)
+	backup.Status.Phase = dbv1alpha1.Pruning
+	backup.Status.Destination = "s3://bucket/testdb/old"
+	backup.Status.Driver = "postgresql"
+	r := fakeReconciler(append(testObjects()[1:2], &backup))
+	key := types.NamespacedName{Namespace: "testns", Name: "old"}
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	job, err := r.getJob(&backup, util.PruneOperation)
+	if err != nil || job == nil {
+		t.Fatalf("No prune job launched")
+	}
+	if image := job.Spec.Template.Spec.Containers[0].Image; image != "isotoma/db-operator-postgresql" {
+		t.Errorf("Prune job launched with the wrong provider's image %s", image)
+	}
+}
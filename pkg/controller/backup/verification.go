@@ -0,0 +1,228 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// scratchName returns the name of the scratch database and restore used to
+// verify the backup, shortened if the backup's name is long
+func scratchName(instance *dbv1alpha1.Backup) string {
+	return util.ShortName(instance.Name + "-verify")
+}
+
+// get fetches the named object owned by the backup, returning false if it
+// does not exist
+func (r *ReconcileBackup) get(instance *dbv1alpha1.Backup, name string, obj runtime.Object) (bool, error) {
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: name}, obj)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// setVerified records the result of verifying the backup
+func (r *ReconcileBackup) setVerified(instance *dbv1alpha1.Backup, status dbv1alpha1.ConditionStatus, reason, message string) error {
+	changed := util.SetCondition(&instance.Status.Conditions, dbv1alpha1.Condition{
+		Type:               dbv1alpha1.BackupVerified,
+		Status:             status,
+		ObservedGeneration: instance.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+	if !changed {
+		return nil
+	}
+	log.Info("Backup verification", "Backup.Name", instance.Name, "Status", status, "Reason", reason)
+	switch status {
+	case dbv1alpha1.ConditionTrue:
+		r.recorder.Event(instance, corev1.EventTypeNormal, "Verified", message)
+	case dbv1alpha1.ConditionFalse:
+		r.recorder.Event(instance, corev1.EventTypeWarning, reason, message)
+	}
+	return r.client.Status().Update(context.TODO(), instance)
+}
+
+// createScratchDatabase creates the database the backup is restored into,
+// from the template database. It has a name of its own on the server
+func (r *ReconcileBackup) createScratchDatabase(instance *dbv1alpha1.Backup) error {
+	template := &dbv1alpha1.Database{}
+	name := instance.Spec.Verify.Template
+	if name == "" {
+		name = instance.Spec.Database
+	}
+	if _, err := r.get(instance, name, template); err != nil {
+		return err
+	}
+	if template.Name == "" {
+		return fmt.Errorf("Verification template database %s not found", name)
+	}
+	database := &dbv1alpha1.Database{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      scratchName(instance),
+			Namespace: instance.Namespace,
+			Labels: map[string]string{
+				dbv1alpha1.VerificationLabel: instance.Name,
+			},
+		},
+		Spec: dbv1alpha1.DatabaseSpec{
+			Instance:       template.Spec.Instance,
+			Provider:       template.Spec.Provider,
+			Name:           strings.Replace(scratchName(instance), "-", "_", -1),
+			Connect:        template.Spec.Connect,
+			Credentials:    template.Spec.Credentials,
+			AwsCredentials: template.Spec.AwsCredentials,
+			// The destination provides the credentials to read the backup
+			BackupTo:       template.Spec.BackupTo,
+			PasswordPolicy: template.Spec.PasswordPolicy,
+		},
+	}
+	if err := controllerutil.SetControllerReference(instance, database, r.scheme); err != nil {
+		return err
+	}
+	log.Info("Creating scratch database", "Database.Name", database.Name)
+	return r.client.Create(context.TODO(), database)
+}
+
+// createScratchRestore restores the backup into the scratch database
+func (r *ReconcileBackup) createScratchRestore(instance *dbv1alpha1.Backup) error {
+	restore := &dbv1alpha1.Restore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      scratchName(instance),
+			Namespace: instance.Namespace,
+		},
+		Spec: dbv1alpha1.RestoreSpec{
+			Backup:   instance.Name,
+			Database: scratchName(instance),
+		},
+	}
+	if err := controllerutil.SetControllerReference(instance, restore, r.scheme); err != nil {
+		return err
+	}
+	log.Info("Creating scratch restore", "Restore.Name", restore.Name)
+	return r.client.Create(context.TODO(), restore)
+}
+
+// removeScratch deletes the scratch restore and database. The database is
+// dropped as it is deleted
+func (r *ReconcileBackup) removeScratch(instance *dbv1alpha1.Backup) error {
+	for _, obj := range []runtime.Object{&dbv1alpha1.Restore{}, &dbv1alpha1.Database{}} {
+		found, err := r.get(instance, scratchName(instance), obj)
+		if err != nil {
+			return err
+		}
+		if !found || obj.(metav1.Object).GetDeletionTimestamp() != nil {
+			continue
+		}
+		log.Info("Removing scratch resource", "Name", scratchName(instance))
+		err = r.client.Delete(context.TODO(), obj, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileVerification verifies a completed backup, if requested, by
+// creating a scratch database, restoring the backup into it, and then
+// running the driver's sanity check against it. Each step is requeued by
+// the change in status of the resource it creates
+func (r *ReconcileBackup) reconcileVerification(instance *dbv1alpha1.Backup) error {
+	if instance.Spec.Verify == nil {
+		return nil
+	}
+	verified := util.FindCondition(instance.Status.Conditions, dbv1alpha1.BackupVerified)
+	if verified == nil {
+		return r.setVerified(instance, dbv1alpha1.ConditionUnknown, "Verifying", "Creating scratch database")
+	}
+	if verified.Status != dbv1alpha1.ConditionUnknown {
+		return r.removeScratch(instance)
+	}
+
+	database := &dbv1alpha1.Database{}
+	found, err := r.get(instance, scratchName(instance), database)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return r.createScratchDatabase(instance)
+	}
+	if database.Status.Phase != dbv1alpha1.Created {
+		// The database's create job is retried after it fails, so a
+		// failure is seen either in the job or in the database's count
+		job := &batchv1.Job{}
+		key := util.DriverJobKey(database.Spec.Instance, database.Namespace, database.Name+"-"+string(util.CreateOperation))
+		if err := r.client.Get(context.TODO(), key, job); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if util.JobFailed(job) || database.Status.FailedJobs > 0 {
+			if err := r.setVerified(instance, dbv1alpha1.ConditionFalse, util.JobFailedReason, "The scratch database could not be created"); err != nil {
+				return err
+			}
+			return r.removeScratch(instance)
+		}
+		return nil
+	}
+
+	restore := &dbv1alpha1.Restore{}
+	found, err = r.get(instance, scratchName(instance), restore)
+	if err != nil {
+		return err
+	}
+	if !found {
+		if err := r.setVerified(instance, dbv1alpha1.ConditionUnknown, "Verifying", "Restoring into scratch database"); err != nil {
+			return err
+		}
+		return r.createScratchRestore(instance)
+	}
+	if restore.Status.Phase != dbv1alpha1.RestoreCompleted {
+		// The restore's job runs alongside those of the backup, as the
+		// scratch database is on the same instance
+		job := &batchv1.Job{}
+		key := util.DriverJobKey(instance.Status.Instance, instance.Namespace, restore.Name+"-"+string(util.RestoreOperation))
+		if err := r.client.Get(context.TODO(), key, job); err != nil {
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		if util.JobFailed(job) {
+			return r.setVerified(instance, dbv1alpha1.ConditionFalse, "RestoreFailed", "The backup could not be restored")
+		}
+		return nil
+	}
+
+	// The driver's check records its own result. If it succeeded without
+	// doing so the restore alone is the verification
+	job, err := r.getJob(instance, util.VerifyOperation)
+	if err != nil {
+		return err
+	}
+	switch {
+	case job == nil:
+		if err := r.setVerified(instance, dbv1alpha1.ConditionUnknown, "Verifying", "Checking scratch database"); err != nil {
+			return err
+		}
+		return r.launchJob(instance, util.VerifyOperation)
+	case util.JobSucceeded(job):
+		return r.setVerified(instance, dbv1alpha1.ConditionTrue, "Restored", "The backup was restored into a scratch database")
+	case util.JobFailed(job):
+		return r.setVerified(instance, dbv1alpha1.ConditionFalse, "CheckFailed", "The sanity check of the scratch database could not be run")
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func verifyObjects() []runtime.Object {
+	objs := testObjects()
+	backup := objs[2].(*dbv1alpha1.Backup)
+	backup.Spec.Verify = &dbv1alpha1.Verification{}
+	backup.Status.Phase = dbv1alpha1.Completed
+	return objs
+}
+
+func verified(t *testing.T, backup *dbv1alpha1.Backup) dbv1alpha1.ConditionStatus {
+	c := util.FindCondition(backup.Status.Conditions, dbv1alpha1.BackupVerified)
+	if c == nil {
+		t.Fatalf("Verified condition not set")
+	}
+	return c.Status
+}
+
+func getScratch(t *testing.T, r *ReconcileBackup, obj runtime.Object) bool {
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "testns", Name: "testbackup-verify"}, obj)
+	return err == nil
+}
+
+func finishJob(t *testing.T, r *ReconcileBackup, name string, condition batchv1.JobConditionType) {
+	job := &batchv1.Job{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "testns", Name: name}, job); err != nil {
+		t.Fatalf("Unable to get job %s: %s", name, err)
+	}
+	job.Status.Conditions = []batchv1.JobCondition{{Type: condition, Status: corev1.ConditionTrue}}
+	if err := r.client.Status().Update(context.TODO(), job); err != nil {
+		t.Fatalf("Unable to update job: %s", err)
+	}
+}
+
+// restored takes the verification as far as the scratch database having
+// been restored into
+func restored(t *testing.T, r *ReconcileBackup) {
+	if backup := reconcileBackup(t, r); verified(t, backup) != dbv1alpha1.ConditionUnknown {
+		t.Fatalf("Verification not started")
+	}
+	reconcileBackup(t, r)
+	database := &dbv1alpha1.Database{}
+	if !getScratch(t, r, database) {
+		t.Fatalf("Scratch database not created")
+	}
+	if database.Labels[dbv1alpha1.VerificationLabel] != "testbackup" || database.Spec.Name != "testbackup_verify" {
+		t.Errorf("Scratch database incorrect: %v %s", database.Labels, database.Spec.Name)
+	}
+	if database.Spec.Provider != "postgresql" {
+		t.Errorf("Scratch database not copied from template, provider %s", database.Spec.Provider)
+	}
+	database.Status.Phase = dbv1alpha1.Created
+	r.client.Status().Update(context.TODO(), database)
+
+	reconcileBackup(t, r)
+	restore := &dbv1alpha1.Restore{}
+	if !getScratch(t, r, restore) {
+		t.Fatalf("Scratch restore not created")
+	}
+	if restore.Spec.Backup != "testbackup" || restore.Spec.Database != "testbackup-verify" {
+		t.Errorf("Scratch restore incorrect: %v", restore.Spec)
+	}
+	restore.Status.Phase = dbv1alpha1.RestoreCompleted
+	r.client.Status().Update(context.TODO(), restore)
+}
+
+func TestVerification(t *testing.T) {
+	r := fakeReconciler(verifyObjects())
+	restored(t, r)
+
+	backup := reconcileBackup(t, r)
+	job, err := r.getJob(backup, util.VerifyOperation)
+	if err != nil || job == nil {
+		t.Fatalf("No verify job launched")
+	}
+	env := map[string]string{}
+	for _, e := range job.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["DB_OPERATOR_DATABASE"] != "testbackup-verify" || env["DB_OPERATOR_OPERATION"] != "verify" {
+		t.Errorf("Verify job environment incorrect: %v", env)
+	}
+	finishJob(t, r, "testbackup-verify", batchv1.JobComplete)
+
+	if backup := reconcileBackup(t, r); verified(t, backup) != dbv1alpha1.ConditionTrue {
+		t.Errorf("Backup not verified")
+	}
+	reconcileBackup(t, r)
+	if getScratch(t, r, &dbv1alpha1.Database{}) || getScratch(t, r, &dbv1alpha1.Restore{}) {
+		t.Errorf("Scratch resources not removed")
+	}
+}
+
+func TestVerificationRestoreFailed(t *testing.T) {
+	r := fakeReconciler(verifyObjects())
+	restored(t, r)
+	restore := &dbv1alpha1.Restore{}
+	getScratch(t, r, restore)
+	restore.Status.Phase = dbv1alpha1.Restoring
+	r.client.Status().Update(context.TODO(), restore)
+	job := &batchv1.Job{}
+	job.Name = "testbackup-verify-restore"
+	job.Namespace = "testns"
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	if err := r.client.Create(context.TODO(), job); err != nil {
+		t.Fatalf("Unable to create job: %s", err)
+	}
+
+	backup := reconcileBackup(t, r)
+	c := util.FindCondition(backup.Status.Conditions, dbv1alpha1.BackupVerified)
+	if c == nil || c.Status != dbv1alpha1.ConditionFalse || c.Reason != "RestoreFailed" {
+		t.Errorf("Failed restore not recorded: %v", c)
+	}
+}
+
+func TestVerificationCreateFailed(t *testing.T) {
+	r := fakeReconciler(verifyObjects())
+	reconcileBackup(t, r)
+	reconcileBackup(t, r)
+	if !getScratch(t, r, &dbv1alpha1.Database{}) {
+		t.Fatalf("Scratch database not created")
+	}
+	job := &batchv1.Job{}
+	job.Name = "testbackup-verify-create"
+	job.Namespace = "testns"
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	if err := r.client.Create(context.TODO(), job); err != nil {
+		t.Fatalf("Unable to create job: %s", err)
+	}
+
+	backup := reconcileBackup(t, r)
+	c := util.FindCondition(backup.Status.Conditions, dbv1alpha1.BackupVerified)
+	if c == nil || c.Status != dbv1alpha1.ConditionFalse || c.Reason != util.JobFailedReason {
+		t.Errorf("Failed scratch database not recorded: %v", c)
+	}
+	if getScratch(t, r, &dbv1alpha1.Database{}) {
+		t.Errorf("Scratch database not removed")
+	}
+}
+
+func TestVerificationNotRequested(t *testing.T) {
+	objs := testObjects()
+	objs[2].(*dbv1alpha1.Backup).Status.Phase = dbv1alpha1.Completed
+	r := fakeReconciler(objs)
+	backup := reconcileBackup(t, r)
+	if util.FindCondition(backup.Status.Conditions, dbv1alpha1.BackupVerified) != nil || getScratch(t, r, &dbv1alpha1.Database{}) {
+		t.Errorf("Backup verified without being requested")
+	}
+}
+
+func TestVerificationLongName(t *testing.T) {
+	r := fakeReconciler(verifyObjects())
+	backup := &dbv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{Name: "testdb-" + strings.Repeat("nightly", 8), Namespace: "testns"},
+		Spec:       dbv1alpha1.BackupSpec{Database: "testdb", Verify: &dbv1alpha1.Verification{}},
+	}
+	if err := r.createScratchDatabase(backup); err != nil {
+		t.Fatalf("Unable to create scratch database: %s", err)
+	}
+	database := &dbv1alpha1.Database{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "testns", Name: scratchName(backup)}, database); err != nil {
+		t.Fatalf("Scratch database not created: %s", err)
+	}
+	if len(database.Name) > util.MaxNameLength || len(database.Spec.Name) > util.MaxNameLength {
+		t.Errorf("Scratch database name too long: %s %s", database.Name, database.Spec.Name)
+	}
+	// The jobs of a database on an instance in another namespace are
+	// prefixed with its namespace
+	ref := &dbv1alpha1.InstanceRef{Name: "main", Namespace: "dbas"}
+	key := util.DriverJobKey(ref, "testns", database.Name+"-"+string(util.RestoreOperation))
+	if len(key.Name) > util.MaxNameLength {
+		t.Errorf("Scratch restore job name too long: %s", key.Name)
+	}
+}
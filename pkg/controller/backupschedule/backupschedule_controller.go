@@ -0,0 +1,291 @@
+package backupschedule
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/robfig/cron"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_backupschedule")
+
+// Add creates a new BackupSchedule Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileBackupSchedule{
+		client:   mgr.GetClient(),
+		scheme:   mgr.GetScheme(),
+		recorder: mgr.GetRecorder("backupschedule-controller"),
+		now:      time.Now,
+	}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller
+	c, err := controller.New("backupschedule-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource BackupSchedule. Otherwise we
+	// requeue ourselves for the next scheduled time
+	err = c.Watch(&source.Kind{Type: &dbv1alpha1.BackupSchedule{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileBackupSchedule{}
+
+// ReconcileBackupSchedule reconciles a BackupSchedule object
+type ReconcileBackupSchedule struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+	now      func() time.Time
+}
+
+// Reasons for the events recorded on a schedule
+const (
+	InvalidScheduleReason = "InvalidSchedule"
+	MissedScheduleReason  = "MissedSchedule"
+)
+
+// maxMissed is how many missed times lastMissed walks before giving up, as
+// for CronJobs
+const maxMissed = 100
+
+// lastMissed returns the latest time the schedule was due after last and
+// up to now, or the zero time if it has not been due since last. It
+// returns an error if the schedule was due more than maxMissed times
+func lastMissed(schedule cron.Schedule, last, now time.Time) (time.Time, error) {
+	var missed time.Time
+	count := 0
+	for t := schedule.Next(last); !t.After(now); t = schedule.Next(t) {
+		count++
+		if count > maxMissed {
+			return time.Time{}, fmt.Errorf("Too many missed start times (> %d)", maxMissed)
+		}
+		missed = t
+	}
+	return missed, nil
+}
+
+// setLastScheduleTime records the time in the status of the schedule
+func (r *ReconcileBackupSchedule) setLastScheduleTime(instance *dbv1alpha1.BackupSchedule, scheduled time.Time) error {
+	lastScheduleTime := metav1.NewTime(scheduled)
+	instance.Status.LastScheduleTime = &lastScheduleTime
+	return r.client.Status().Update(context.TODO(), instance)
+}
+
+// databases returns the databases the schedule backs up
+func (r *ReconcileBackupSchedule) databases(instance *dbv1alpha1.BackupSchedule) ([]dbv1alpha1.Database, error) {
+	if instance.Spec.Selector == nil {
+		database := dbv1alpha1.Database{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{
+			Namespace: instance.Namespace,
+			Name:      instance.Spec.Database,
+		}, &database)
+		if err != nil {
+			return nil, err
+		}
+		return []dbv1alpha1.Database{database}, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(instance.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	list := &dbv1alpha1.DatabaseList{}
+	opts := &client.ListOptions{Namespace: instance.Namespace, LabelSelector: selector}
+	if err := r.client.List(context.TODO(), opts, list); err != nil {
+		return nil, err
+	}
+	databases := []dbv1alpha1.Database{}
+	for _, database := range list.Items {
+		// The selector is checked here as well as by the server, so that
+		// we are not relying on every client to apply it
+		if selector.Matches(labels.Set(database.Labels)) {
+			databases = append(databases, database)
+		}
+	}
+	return databases, nil
+}
+
+// activeBackups returns the backups of the database created by the
+// schedule that have not yet finished. Failed backups have finished, so
+// they do not hold up the backups scheduled after them
+func (r *ReconcileBackupSchedule) activeBackups(instance *dbv1alpha1.BackupSchedule, database string) ([]dbv1alpha1.Backup, error) {
+	list := &dbv1alpha1.BackupList{}
+	opts := (&client.ListOptions{}).InNamespace(instance.Namespace).MatchingLabels(map[string]string{
+		dbv1alpha1.BackupScheduleLabel: instance.Name,
+		"app":                          database,
+	})
+	if err := r.client.List(context.TODO(), opts, list); err != nil {
+		return nil, err
+	}
+	active := []dbv1alpha1.Backup{}
+	for _, backup := range list.Items {
+		if backup.Labels[dbv1alpha1.BackupScheduleLabel] != instance.Name || backup.Spec.Database != database {
+			continue
+		}
+		switch backup.Status.Phase {
+		case dbv1alpha1.Completed, dbv1alpha1.Failed, dbv1alpha1.Pruning:
+		default:
+			active = append(active, backup)
+		}
+	}
+	return active, nil
+}
+
+// backup creates the backup of the database scheduled for the time,
+// following the concurrency policy of the schedule
+func (r *ReconcileBackupSchedule) backup(instance *dbv1alpha1.BackupSchedule, database *dbv1alpha1.Database, scheduled time.Time) error {
+	active, err := r.activeBackups(instance, database.Name)
+	if err != nil {
+		return err
+	}
+	if len(active) > 0 {
+		if instance.Spec.ConcurrencyPolicy != dbv1alpha1.ReplaceConcurrent {
+			log.Info("Skipping backup, previous backup still active", "Database.Name", database.Name)
+			return nil
+		}
+		for i := range active {
+			log.Info("Replacing active backup", "Backup.Name", active[i].Name)
+			if err := r.client.Delete(context.TODO(), &active[i]); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	// The name is derived from the scheduled time, so a backup is only
+	// created once for each time even if we are interrupted
+	backup := &dbv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s-%d", instance.Name, database.Name, scheduled.Unix()),
+			Namespace: instance.Namespace,
+			Labels: map[string]string{
+				"app":                          database.Name,
+				dbv1alpha1.BackupScheduleLabel: instance.Name,
+			},
+		},
+		Spec: dbv1alpha1.BackupSpec{
+			Database: database.Name,
+			Serial:   scheduled.Format(time.RFC3339),
+			Verify:   instance.Spec.Verify,
+		},
+	}
+	log.Info("Creating scheduled backup", "Backup.Name", backup.Name)
+	if err := r.client.Create(context.TODO(), backup); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// Reconcile creates Backups of the scheduled databases when they are due, and
+// requeues itself for the next scheduled time
+// Note:
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcileBackupSchedule) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Reconciling BackupSchedule")
+
+	// Fetch the BackupSchedule instance
+	instance := &dbv1alpha1.BackupSchedule{}
+	err := r.client.Get(context.TODO(), request.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	// An invalid schedule will not fix itself, so is not requeued
+	location, err := time.LoadLocation(instance.Spec.TimeZone)
+	if err != nil {
+		reqLogger.Error(err, "Invalid time zone")
+		r.recorder.Eventf(instance, corev1.EventTypeWarning, InvalidScheduleReason, "Invalid time zone %q: %s", instance.Spec.TimeZone, err)
+		return reconcile.Result{}, nil
+	}
+	schedule, err := cron.ParseStandard(instance.Spec.Schedule)
+	if err != nil {
+		reqLogger.Error(err, "Invalid schedule")
+		r.recorder.Eventf(instance, corev1.EventTypeWarning, InvalidScheduleReason, "Invalid schedule %q: %s", instance.Spec.Schedule, err)
+		return reconcile.Result{}, nil
+	}
+
+	now := r.now().In(location)
+	last := instance.CreationTimestamp.Time
+	if instance.Status.LastScheduleTime != nil {
+		last = instance.Status.LastScheduleTime.Time
+	}
+	next := reconcile.Result{RequeueAfter: schedule.Next(now).Sub(now)}
+
+	scheduled, err := lastMissed(schedule, last.In(location), now)
+	if err != nil {
+		// Rather than walking every missed time, skip them all and carry on
+		// from now
+		reqLogger.Info("Skipping missed backups", "Error", err.Error())
+		r.recorder.Eventf(instance, corev1.EventTypeWarning, MissedScheduleReason, "%s, skipping to %s", err, now.Format(time.RFC3339))
+		if err := r.setLastScheduleTime(instance, now); err != nil {
+			return reconcile.Result{}, err
+		}
+		return next, nil
+	}
+	if scheduled.IsZero() {
+		return next, nil
+	}
+	// The skipped time is recorded, so that it is not tried again
+	if deadline := instance.Spec.StartingDeadlineSeconds; deadline != nil && now.Sub(scheduled) > time.Duration(*deadline)*time.Second {
+		reqLogger.Info("Missed starting deadline", "Scheduled", scheduled)
+		r.recorder.Eventf(instance, corev1.EventTypeWarning, MissedScheduleReason, "Missed starting deadline for backup scheduled at %s", scheduled.Format(time.RFC3339))
+		if err := r.setLastScheduleTime(instance, scheduled); err != nil {
+			return reconcile.Result{}, err
+		}
+		return next, nil
+	}
+
+	databases, err := r.databases(instance)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	for i := range databases {
+		if databases[i].Status.Phase != dbv1alpha1.Created {
+			reqLogger.Info("Skipping backup, database not Created", "Database.Name", databases[i].Name)
+			continue
+		}
+		if err := r.backup(instance, &databases[i], scheduled); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	if err := r.setLastScheduleTime(instance, scheduled); err != nil {
+		return reconcile.Result{}, err
+	}
+	return next, nil
+}
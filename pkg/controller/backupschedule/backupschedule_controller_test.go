@@ -0,0 +1,286 @@
+package backupschedule
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var created = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func fakeReconciler(now time.Time, objs ...runtime.Object) *ReconcileBackupSchedule {
+	s := scheme.Scheme
+	s.AddKnownTypes(dbv1alpha1.SchemeGroupVersion,
+		&dbv1alpha1.Database{}, &dbv1alpha1.DatabaseList{},
+		&dbv1alpha1.Backup{}, &dbv1alpha1.BackupList{},
+		&dbv1alpha1.BackupSchedule{})
+	cl := fake.NewFakeClient(objs...)
+	return &ReconcileBackupSchedule{
+		client:   cl,
+		scheme:   s,
+		recorder: record.NewFakeRecorder(10),
+		now:      func() time.Time { return now },
+	}
+}
+
+func testDatabase(name string, labels map[string]string) *dbv1alpha1.Database {
+	return &dbv1alpha1.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "testns", Labels: labels},
+		Status:     dbv1alpha1.DatabaseStatus{Phase: dbv1alpha1.Created},
+	}
+}
+
+func testSchedule(spec dbv1alpha1.BackupScheduleSpec) *dbv1alpha1.BackupSchedule {
+	if spec.Schedule == "" {
+		spec.Schedule = "0 2 * * *"
+	}
+	return &dbv1alpha1.BackupSchedule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "nightly",
+			Namespace:         "testns",
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: spec,
+	}
+}
+
+var key = types.NamespacedName{Namespace: "testns", Name: "nightly"}
+
+func backups(t *testing.T, r *ReconcileBackupSchedule) []dbv1alpha1.Backup {
+	list := &dbv1alpha1.BackupList{}
+	if err := r.client.List(context.TODO(), &client.ListOptions{Namespace: "testns"}, list); err != nil {
+		t.Fatalf("Unable to list backups: %s", err)
+	}
+	return list.Items
+}
+
+func TestReconcileNotDue(t *testing.T) {
+	now := created.Add(time.Hour)
+	r := fakeReconciler(now, testDatabase("testdb", nil), testSchedule(dbv1alpha1.BackupScheduleSpec{Database: "testdb"}))
+	result, err := r.Reconcile(reconcile.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if result.RequeueAfter != time.Hour {
+		t.Errorf("Expected requeue after an hour, got %s", result.RequeueAfter)
+	}
+	if len(backups(t, r)) != 0 {
+		t.Errorf("Backup created before it was due")
+	}
+}
+
+func TestReconcileCreatesBackup(t *testing.T) {
+	now := created.Add(3 * time.Hour)
+	r := fakeReconciler(now, testDatabase("testdb", nil), testSchedule(dbv1alpha1.BackupScheduleSpec{Database: "testdb"}))
+	result, err := r.Reconcile(reconcile.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if result.RequeueAfter != 23*time.Hour {
+		t.Errorf("Expected requeue after 23 hours, got %s", result.RequeueAfter)
+	}
+	items := backups(t, r)
+	if len(items) != 1 {
+		t.Fatalf("Expected one backup, got %d", len(items))
+	}
+	if items[0].Spec.Database != "testdb" || items[0].Labels[dbv1alpha1.BackupScheduleLabel] != "nightly" {
+		t.Errorf("Backup incorrect: %v", items[0])
+	}
+	schedule := &dbv1alpha1.BackupSchedule{}
+	if err := r.client.Get(context.TODO(), key, schedule); err != nil {
+		t.Fatalf("Unable to get schedule: %s", err)
+	}
+	if schedule.Status.LastScheduleTime == nil || !schedule.Status.LastScheduleTime.Time.Equal(created.Add(2*time.Hour)) {
+		t.Errorf("LastScheduleTime incorrect: %v", schedule.Status.LastScheduleTime)
+	}
+
+	// A second reconcile in the same period does nothing
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if len(backups(t, r)) != 1 {
+		t.Errorf("Backup created twice for the same time")
+	}
+}
+
+func TestReconcileTimeZone(t *testing.T) {
+	// 02:00 in New York is 07:00 UTC
+	now := created.Add(3 * time.Hour)
+	r := fakeReconciler(now, testDatabase("testdb", nil), testSchedule(dbv1alpha1.BackupScheduleSpec{
+		Database: "testdb",
+		TimeZone: "America/New_York",
+	}))
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if len(backups(t, r)) != 0 {
+		t.Errorf("Backup created before it was due in the schedule's time zone")
+	}
+}
+
+func TestReconcileStartingDeadline(t *testing.T) {
+	now := created.Add(3 * time.Hour)
+	deadline := int64(600)
+	r := fakeReconciler(now, testDatabase("testdb", nil), testSchedule(dbv1alpha1.BackupScheduleSpec{
+		Database:                "testdb",
+		StartingDeadlineSeconds: &deadline,
+	}))
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if len(backups(t, r)) != 0 {
+		t.Errorf("Backup created after its starting deadline")
+	}
+	schedule := &dbv1alpha1.BackupSchedule{}
+	if err := r.client.Get(context.TODO(), key, schedule); err != nil {
+		t.Fatalf("Unable to get schedule: %s", err)
+	}
+	if schedule.Status.LastScheduleTime == nil || !schedule.Status.LastScheduleTime.Time.Equal(created.Add(2*time.Hour)) {
+		t.Errorf("Skipped time not recorded: %v", schedule.Status.LastScheduleTime)
+	}
+	expectEvent(t, r, "Warning MissedSchedule Missed starting deadline for backup scheduled at 2019-01-01T02:00:00Z")
+}
+
+func expectEvent(t *testing.T, r *ReconcileBackupSchedule, expected string) {
+	select {
+	case event := <-r.recorder.(*record.FakeRecorder).Events:
+		if event != expected {
+			t.Errorf("Expected event %q, got %q", expected, event)
+		}
+	default:
+		t.Errorf("Expected event %q, got none", expected)
+	}
+}
+
+func TestReconcileTooManyMissed(t *testing.T) {
+	// Every minute for a day is far more than maxMissed
+	now := created.Add(24 * time.Hour)
+	r := fakeReconciler(now, testDatabase("testdb", nil), testSchedule(dbv1alpha1.BackupScheduleSpec{
+		Database: "testdb",
+		Schedule: "* * * * *",
+	}))
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if len(backups(t, r)) != 0 {
+		t.Errorf("Backup created for missed times")
+	}
+	schedule := &dbv1alpha1.BackupSchedule{}
+	if err := r.client.Get(context.TODO(), key, schedule); err != nil {
+		t.Fatalf("Unable to get schedule: %s", err)
+	}
+	if schedule.Status.LastScheduleTime == nil || !schedule.Status.LastScheduleTime.Time.Equal(now) {
+		t.Errorf("Missed times not skipped: %v", schedule.Status.LastScheduleTime)
+	}
+	expectEvent(t, r, "Warning MissedSchedule Too many missed start times (> 100), skipping to 2019-01-02T00:00:00Z")
+}
+
+func TestReconcileInvalidSchedule(t *testing.T) {
+	r := fakeReconciler(created, testSchedule(dbv1alpha1.BackupScheduleSpec{
+		Database: "testdb",
+		TimeZone: "Nowhere/Special",
+	}))
+	result, err := r.Reconcile(reconcile.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if result.Requeue || result.RequeueAfter != 0 {
+		t.Errorf("Invalid schedule requeued")
+	}
+	select {
+	case event := <-r.recorder.(*record.FakeRecorder).Events:
+		if !strings.HasPrefix(event, "Warning InvalidSchedule Invalid time zone \"Nowhere/Special\"") {
+			t.Errorf("Unexpected event %q", event)
+		}
+	default:
+		t.Errorf("Invalid time zone not recorded")
+	}
+}
+
+func TestReconcileSelector(t *testing.T) {
+	now := created.Add(3 * time.Hour)
+	r := fakeReconciler(now,
+		testDatabase("db1", map[string]string{"tier": "prod"}),
+		testDatabase("db2", map[string]string{"tier": "prod"}),
+		testDatabase("db3", map[string]string{"tier": "dev"}),
+		testSchedule(dbv1alpha1.BackupScheduleSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "prod"}},
+		}))
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	databases := map[string]bool{}
+	for _, backup := range backups(t, r) {
+		databases[backup.Spec.Database] = true
+	}
+	if len(databases) != 2 || !databases["db1"] || !databases["db2"] {
+		t.Errorf("Backups created for wrong databases: %v", databases)
+	}
+}
+
+func activeBackup() *dbv1alpha1.Backup {
+	return &dbv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nightly-testdb-previous",
+			Namespace: "testns",
+			Labels:    map[string]string{"app": "testdb", dbv1alpha1.BackupScheduleLabel: "nightly"},
+		},
+		Spec:   dbv1alpha1.BackupSpec{Database: "testdb"},
+		Status: dbv1alpha1.BackupStatus{Phase: dbv1alpha1.BackingUp},
+	}
+}
+
+func TestReconcileForbidConcurrent(t *testing.T) {
+	now := created.Add(3 * time.Hour)
+	r := fakeReconciler(now, testDatabase("testdb", nil), activeBackup(),
+		testSchedule(dbv1alpha1.BackupScheduleSpec{Database: "testdb"}))
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	items := backups(t, r)
+	if len(items) != 1 || items[0].Name != "nightly-testdb-previous" {
+		t.Errorf("Backup created while previous backup active: %v", items)
+	}
+}
+
+func TestReconcileAfterFailedBackup(t *testing.T) {
+	now := created.Add(3 * time.Hour)
+	failed := activeBackup()
+	failed.Status.Phase = dbv1alpha1.Failed
+	r := fakeReconciler(now, testDatabase("testdb", nil), failed,
+		testSchedule(dbv1alpha1.BackupScheduleSpec{Database: "testdb"}))
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	items := backups(t, r)
+	if len(items) != 2 {
+		t.Errorf("Failed backup blocked the next backup: %v", items)
+	}
+}
+
+func TestReconcileReplaceConcurrent(t *testing.T) {
+	now := created.Add(3 * time.Hour)
+	r := fakeReconciler(now, testDatabase("testdb", nil), activeBackup(),
+		testSchedule(dbv1alpha1.BackupScheduleSpec{
+			Database:          "testdb",
+			ConcurrencyPolicy: dbv1alpha1.ReplaceConcurrent,
+		}))
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	items := backups(t, r)
+	if len(items) != 1 || items[0].Name == "nightly-testdb-previous" {
+		t.Errorf("Previous backup not replaced: %v", items)
+	}
+}
@@ -5,22 +5,32 @@ import (
 	"fmt"
 	"time"
 
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
 
-// Create a Backup resource for the database and return its name
+// beforeDeleteBackupName returns the name of the backup taken before the
+// database is deleted. It is derived from the deletion timestamp so that
+// the same backup is found again after an operator restart
+func beforeDeleteBackupName(instance *dbv1alpha1.Database) string {
+	return fmt.Sprintf("%s-before-delete-%d", instance.Name, instance.DeletionTimestamp.Unix())
+}
+
+// Create a Backup resource for the database and return it
 func (r *ReconcileDatabase) createBackupResource(instance *dbv1alpha1.Database) (*dbv1alpha1.Backup, error) {
 	backup := &dbv1alpha1.Backup{
 		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: instance.Name + "-",
-			Namespace:    instance.Namespace,
+			Name:      beforeDeleteBackupName(instance),
+			Namespace: instance.Namespace,
 			Labels: map[string]string{
-				"app": instance.Name,
+				"app":                        instance.Name,
+				dbv1alpha1.BeforeDeleteLabel: "true",
 			},
 		},
 		Spec: dbv1alpha1.BackupSpec{
@@ -28,48 +38,57 @@ func (r *ReconcileDatabase) createBackupResource(instance *dbv1alpha1.Database)
 			Serial:   time.Now().Format(time.RFC3339),
 		},
 	}
-	if err := controllerutil.SetControllerReference(instance, backup, r.scheme); err != nil {
-		return nil, err
-	}
+	// The backup is not owned by the database, as it must outlive it
 	if err := r.client.Create(context.TODO(), backup); err != nil {
 		return nil, err
 	}
 	return backup, nil
 }
 
-func (r *ReconcileDatabase) blockUntilCompleted(Namespace, Name string) error {
-	delay, _ := time.ParseDuration("30s")
-	for {
-		time.Sleep(delay)
-		found := dbv1alpha1.Backup{}
-		err := r.client.Get(context.TODO(), types.NamespacedName{
-			Namespace: Namespace,
-			Name:      Name,
-		}, &found)
-		if err != nil && errors.IsNotFound(err) {
-			log.Info("Waiting for Backup resource")
-		} else if err != nil {
-			return err
-		} else {
-			if found.Status.Phase == dbv1alpha1.Completed {
-				return nil
-			}
-			log.Info(fmt.Sprintf("Backup phase is %s, waiting", found.Status.Phase))
-		}
+// backupBeforeDelete returns the backup taken before the database is
+// deleted, creating it if it does not yet exist
+func (r *ReconcileDatabase) backupBeforeDelete(instance *dbv1alpha1.Database) (*dbv1alpha1.Backup, error) {
+	backup := &dbv1alpha1.Backup{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Namespace: instance.Namespace,
+		Name:      beforeDeleteBackupName(instance),
+	}, backup)
+	if err == nil {
+		return backup, nil
 	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	log.Info("Creating backup before delete", "Database.Namespace", instance.Namespace, "Database.Name", instance.Name)
+	return r.createBackupResource(instance)
 }
 
-// Backup creates a backup resource and returns a channel that will
-// send nil/an error when the backup is completed
-func (r *ReconcileDatabase) Backup(instance *dbv1alpha1.Database) chan error {
-	c := make(chan error)
-	go func() {
-		backup, err := r.createBackupResource(instance)
-		if err != nil {
-			c <- err
-			return
+// followBackupBeforeDelete moves the database on once the backup taken
+// before it is deleted has completed. Nothing blocks here: the database is
+// reconciled again whenever the backup changes
+func (r *ReconcileDatabase) followBackupBeforeDelete(instance *dbv1alpha1.Database) (reconcile.Result, error) {
+	backup, err := r.backupBeforeDelete(instance)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if backup.Status.Phase == dbv1alpha1.Completed {
+		// The driver moves the phase on itself, but we follow the backup
+		// too in case it completed without doing so
+		return reconcile.Result{}, r.UpdatePhase(instance, dbv1alpha1.BackupBeforeDeleteCompleted)
+	}
+	degraded := util.FindCondition(backup.Status.Conditions, dbv1alpha1.ConditionDegraded)
+	if backup.Status.Phase == dbv1alpha1.Failed || degraded != nil && degraded.Status == dbv1alpha1.ConditionTrue {
+		// The database is not dropped without its backup, so it waits
+		// here until the backup is put right. A failed backup is taken
+		// again once it has been deleted
+		message := "Backup " + backup.Name + " failed"
+		if degraded != nil && degraded.Status == dbv1alpha1.ConditionTrue {
+			message += ": " + degraded.Message
 		}
-		c <- r.blockUntilCompleted(backup.Namespace, backup.Name)
-	}()
-	return c
+		log.Info("Backup before delete failed", "Backup.Namespace", backup.Namespace, "Backup.Name", backup.Name)
+		r.recorder.Event(instance, corev1.EventTypeWarning, "BackupFailed", message)
+		return reconcile.Result{}, nil
+	}
+	log.Info(fmt.Sprintf("Backup phase is %s, waiting", backup.Status.Phase))
+	return reconcile.Result{}, nil
 }
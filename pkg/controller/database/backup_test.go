@@ -1,35 +1,159 @@
 package database
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func fakeReconciler(objs []runtime.Object) *ReconcileDatabase {
 	s := scheme.Scheme
-	s.AddKnownTypes(dbv1alpha1.SchemeGroupVersion, &dbv1alpha1.Database{}, &dbv1alpha1.Backup{})
+	s.AddKnownTypes(dbv1alpha1.SchemeGroupVersion,
+		&dbv1alpha1.Database{}, &dbv1alpha1.Backup{}, &dbv1alpha1.BackupList{}, &dbv1alpha1.Restore{},
+		&dbv1alpha1.Provider{}, &dbv1alpha1.ProviderList{}, &dbv1alpha1.DatabaseInstance{})
 	cl := fake.NewFakeClient(objs...)
-	return &ReconcileDatabase{client: cl, scheme: s}
+	return &ReconcileDatabase{client: cl, scheme: s, recorder: &record.FakeRecorder{}}
 }
 
-func TestCreateBackupResource(t *testing.T) {
-	db := &dbv1alpha1.Database{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: "testdb",
-		},
+// deletedDatabase returns a database with backups configured that has been
+// deleted while in phase
+func deletedDatabase(phase dbv1alpha1.DatabasePhase) *dbv1alpha1.Database {
+	db := testDatabase()
+	db.Spec.BackupTo.S3.Bucket = "testbucket"
+	db.Status.Phase = phase
+	deleted := metav1.NewTime(time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC))
+	db.DeletionTimestamp = &deleted
+	db.Finalizers = []string{finalizerName}
+	return db
+}
+
+func getBeforeDeleteBackup(t *testing.T, r *ReconcileDatabase, db *dbv1alpha1.Database) *dbv1alpha1.Backup {
+	backup := &dbv1alpha1.Backup{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: db.Namespace, Name: beforeDeleteBackupName(db)}, backup)
+	if err != nil {
+		t.Fatalf("Unable to get backup: %s", err)
 	}
+	return backup
+}
+
+func TestCreateBackupResource(t *testing.T) {
+	db := deletedDatabase(dbv1alpha1.Created)
 	r := fakeReconciler([]runtime.Object{db})
 	backup, err := r.createBackupResource(db)
-	backup.Name = "testName" // fake client doesn't generate name
 	if err != nil {
-		t.Errorf("createBackupResource threw unexpected error: %s", err)
+		t.Fatalf("createBackupResource threw unexpected error: %s", err)
+	}
+	if backup.Name != "testdb-before-delete-1551441600" {
+		t.Errorf("Unexpected backup name %s", backup.Name)
+	}
+	if backup.Labels[dbv1alpha1.BeforeDeleteLabel] != "true" {
+		t.Errorf("Backup is not labelled as taken before delete")
+	}
+	// It must not be garbage collected along with the database
+	if len(backup.OwnerReferences) != 0 {
+		t.Errorf("Backup is owned by the database: %v", backup.OwnerReferences)
 	}
 	if backup.Status.Phase != "" {
 		t.Errorf("Error in initial phase")
 	}
 }
+
+func TestDeleteRequestsBackup(t *testing.T) {
+	db := deletedDatabase(dbv1alpha1.Created)
+	r := fakeReconciler([]runtime.Object{db})
+	found, err := reconcileDatabase(t, r, db)
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if found.Status.Phase != dbv1alpha1.BackupBeforeDeleteRequested {
+		t.Errorf("Expected phase BackupBeforeDeleteRequested, got %s", found.Status.Phase)
+	}
+	backup := getBeforeDeleteBackup(t, r, db)
+	if backup.Spec.Database != db.Name {
+		t.Errorf("Backup is of %s, not %s", backup.Spec.Database, db.Name)
+	}
+}
+
+func TestBackupBeforeDeleteWaits(t *testing.T) {
+	db := deletedDatabase(dbv1alpha1.BackupBeforeDeleteInProgress)
+	r := fakeReconciler([]runtime.Object{db})
+	if _, err := r.createBackupResource(db); err != nil {
+		t.Fatalf("createBackupResource threw unexpected error: %s", err)
+	}
+	found, err := reconcileDatabase(t, r, db)
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if found.Status.Phase != dbv1alpha1.BackupBeforeDeleteInProgress {
+		t.Errorf("Phase moved on before the backup completed: %s", found.Status.Phase)
+	}
+}
+
+func TestBackupBeforeDeleteResumes(t *testing.T) {
+	// The operator restarted before the backup was created
+	db := deletedDatabase(dbv1alpha1.BackupBeforeDeleteRequested)
+	r := fakeReconciler([]runtime.Object{db})
+	if _, err := reconcileDatabase(t, r, db); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	backup := getBeforeDeleteBackup(t, r, db)
+
+	// The backup completes without the driver moving the phase on
+	backup.Status.Phase = dbv1alpha1.Completed
+	if err := r.client.Status().Update(context.TODO(), backup); err != nil {
+		t.Fatalf("Unable to update backup: %s", err)
+	}
+	found, err := reconcileDatabase(t, r, db)
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if found.Status.Phase != dbv1alpha1.BackupBeforeDeleteCompleted {
+		t.Errorf("Expected phase BackupBeforeDeleteCompleted, got %s", found.Status.Phase)
+	}
+	found, err = reconcileDatabase(t, r, db)
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if found.Status.Phase != dbv1alpha1.DeletionRequested {
+		t.Errorf("Expected phase DeletionRequested, got %s", found.Status.Phase)
+	}
+}
+
+func TestBackupBeforeDeleteFailed(t *testing.T) {
+	db := deletedDatabase(dbv1alpha1.BackupBeforeDeleteInProgress)
+	r := fakeReconciler([]runtime.Object{db})
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+	backup, err := r.createBackupResource(db)
+	if err != nil {
+		t.Fatalf("createBackupResource threw unexpected error: %s", err)
+	}
+	backup.Status.Phase = dbv1alpha1.BackingUp
+	backup.Status.Conditions = []dbv1alpha1.Condition{{
+		Type:    dbv1alpha1.ConditionDegraded,
+		Status:  dbv1alpha1.ConditionTrue,
+		Message: "Job testdb-before-delete-1551441600-backup failed",
+	}}
+	if err := r.client.Status().Update(context.TODO(), backup); err != nil {
+		t.Fatalf("Unable to update backup: %s", err)
+	}
+	found, err := reconcileDatabase(t, r, db)
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if found.Status.Phase != dbv1alpha1.BackupBeforeDeleteInProgress {
+		t.Errorf("Phase moved on after the backup failed: %s", found.Status.Phase)
+	}
+	expected := "Warning BackupFailed Backup testdb-before-delete-1551441600 failed: Job testdb-before-delete-1551441600-backup failed"
+	if e := events(recorder); len(e) != 1 || e[0] != expected {
+		t.Errorf("Expected event %q, got %v", expected, e)
+	}
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// phaseOperations maps the phases in which a driver job is run to its
+// operation
+var phaseOperations = map[dbv1alpha1.DatabasePhase]util.Operation{
+	dbv1alpha1.Creating:           util.CreateOperation,
+	dbv1alpha1.Populating:         util.CloneOperation,
+	dbv1alpha1.RotationRequested:  util.RotateOperation,
+	dbv1alpha1.RotationInProgress: util.RotateOperation,
+	dbv1alpha1.DeletionInProgress: util.DropOperation,
+}
+
+// clearedReasons are those for which the Degraded condition is set, and
+// so cleared, here
+var clearedReasons = map[string]bool{
+	util.JobFailedReason:           true,
+	util.SecretMissingReason:       true,
+	util.ProviderMissingReason:     true,
+	util.ProviderUnhealthyReason:   true,
+	util.InstanceUnavailableReason: true,
+}
+
+// phaseJobName returns the name of the job run in the current phase of the
+// database, if there is one
+func phaseJobName(instance *dbv1alpha1.Database) string {
+	if instance.Status.Phase == dbv1alpha1.Populating && instance.Spec.Source != nil && instance.Spec.Source.Backup != "" {
+		return sourceRestoreName(instance) + "-" + string(util.RestoreOperation)
+	}
+	if op, ok := phaseOperations[instance.Status.Phase]; ok {
+		return jobName(instance, op)
+	}
+	return ""
+}
+
+// failedJob returns the job run in the current phase of the database if it
+// has failed, or nil
+func (r *ReconcileDatabase) failedJob(instance *dbv1alpha1.Database) (*batchv1.Job, error) {
+	name := phaseJobName(instance)
+	if name == "" {
+		return nil, nil
+	}
+	ref, err := r.jobInstance(instance, phaseOperations[instance.Status.Phase])
+	if err != nil {
+		return nil, err
+	}
+	job := &batchv1.Job{}
+	err = r.client.Get(context.TODO(), util.DriverJobKey(ref, instance.Namespace, name), job)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if util.JobFailed(job) {
+		return job, nil
+	}
+	return nil, nil
+}
+
+// secretExists returns true if the secret holding the connection details
+// of the database exists
+func (r *ReconcileDatabase) secretExists(instance *dbv1alpha1.Database) (bool, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}, secret)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// backupHealth returns the BackupHealthy condition of the database, from
+// its latest backup that has either completed or failed
+func (r *ReconcileDatabase) backupHealth(instance *dbv1alpha1.Database) (dbv1alpha1.Condition, error) {
+	condition := dbv1alpha1.Condition{
+		Type:               dbv1alpha1.ConditionBackupHealthy,
+		Status:             dbv1alpha1.ConditionUnknown,
+		ObservedGeneration: instance.Generation,
+		Reason:             "NoBackups",
+		Message:            "No backup has finished",
+	}
+	backups := &dbv1alpha1.BackupList{}
+	if err := r.client.List(context.TODO(), &client.ListOptions{Namespace: instance.Namespace}, backups); err != nil {
+		return condition, err
+	}
+	var latest *dbv1alpha1.Backup
+	for i := range backups.Items {
+		backup := &backups.Items[i]
+		if backup.Spec.Database != instance.Name {
+			continue
+		}
+		degraded := util.FindCondition(backup.Status.Conditions, dbv1alpha1.ConditionDegraded)
+		failed := backup.Status.Phase == dbv1alpha1.Failed || degraded != nil && degraded.Status == dbv1alpha1.ConditionTrue
+		if backup.Status.Phase != dbv1alpha1.Completed && !failed {
+			continue
+		}
+		if latest == nil || latest.CreationTimestamp.Before(&backup.CreationTimestamp) {
+			latest = backup
+		}
+	}
+	if latest == nil {
+		return condition, nil
+	}
+	degraded := util.FindCondition(latest.Status.Conditions, dbv1alpha1.ConditionDegraded)
+	verified := util.FindCondition(latest.Status.Conditions, dbv1alpha1.BackupVerified)
+	switch {
+	case degraded != nil && degraded.Status == dbv1alpha1.ConditionTrue:
+		condition.Status = dbv1alpha1.ConditionFalse
+		condition.Reason = "BackupFailed"
+		condition.Message = fmt.Sprintf("Backup %s failed: %s", latest.Name, degraded.Message)
+	case latest.Status.Phase == dbv1alpha1.Failed:
+		condition.Status = dbv1alpha1.ConditionFalse
+		condition.Reason = "BackupFailed"
+		condition.Message = fmt.Sprintf("Backup %s failed", latest.Name)
+	case verified != nil && verified.Status == dbv1alpha1.ConditionFalse:
+		condition.Status = dbv1alpha1.ConditionFalse
+		condition.Reason = "VerificationFailed"
+		condition.Message = fmt.Sprintf("Backup %s failed verification: %s", latest.Name, verified.Message)
+	default:
+		condition.Status = dbv1alpha1.ConditionTrue
+		condition.Reason = "BackupCompleted"
+		condition.Message = fmt.Sprintf("Backup %s completed", latest.Name)
+	}
+	return condition, nil
+}
+
+// providerDegraded returns a Degraded condition if the provider of the
+// database is missing or not ready, or nil
+func (r *ReconcileDatabase) providerDegraded(instance *dbv1alpha1.Database) (*dbv1alpha1.Condition, error) {
+	namespace := util.JobNamespace(instance.Spec.Instance, instance.Namespace)
+	provider, err := util.FindProvider(r.client, namespace, instance.Spec.Provider)
+	if err != nil {
+		return nil, err
+	}
+	if provider == nil {
+		c := util.Degraded(instance.Generation, util.ProviderMissingReason,
+			fmt.Errorf("No provider %q found in namespace %s", instance.Spec.Provider, namespace))
+		return &c, nil
+	}
+	ready := util.FindCondition(provider.Status.Conditions, dbv1alpha1.ConditionReady)
+	if ready != nil && ready.Status == dbv1alpha1.ConditionFalse {
+		c := util.Degraded(instance.Generation, util.ProviderUnhealthyReason,
+			fmt.Errorf("Provider %s is not ready: %s", provider.Name, ready.Message))
+		return &c, nil
+	}
+	return nil, nil
+}
+
+// reconcileConditions brings the conditions of the database up to date with
+// its phase, its secret, its provider, the job run in its phase and its
+// backups. The driver marks the database Degraded if it fails, which is
+// left for it to clear, as only the reasons given here are cleared here
+func (r *ReconcileDatabase) reconcileConditions(key types.NamespacedName) error {
+	instance := &dbv1alpha1.Database{}
+	if err := r.client.Get(context.TODO(), key, instance); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	ready, progressing := util.DatabasePhaseConditions(instance)
+	var degraded *dbv1alpha1.Condition
+	if ready.Status == dbv1alpha1.ConditionTrue {
+		exists, err := r.secretExists(instance)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			missing := fmt.Errorf("Secret %s holding the connection details is missing", instance.Name)
+			ready.Status = dbv1alpha1.ConditionFalse
+			ready.Reason = util.SecretMissingReason
+			ready.Message = missing.Error()
+			c := util.Degraded(instance.Generation, util.SecretMissingReason, missing)
+			degraded = &c
+		}
+	}
+	// The provider and backup destination may be those of the instance
+	resolved, err := util.ResolveDatabase(r.client, instance)
+	if err != nil {
+		c := util.Degraded(instance.Generation, util.InstanceUnavailableReason, err)
+		degraded = &c
+		resolved = instance
+	} else {
+		unhealthy, err := r.providerDegraded(resolved)
+		if err != nil {
+			return err
+		}
+		if unhealthy != nil {
+			degraded = unhealthy
+		}
+	}
+	job, err := r.failedJob(instance)
+	if err != nil {
+		return err
+	}
+	if job != nil {
+		c := util.Degraded(instance.Generation, util.JobFailedReason, fmt.Errorf("Job %s failed", job.Name))
+		degraded = &c
+	}
+	if degraded == nil {
+		existing := util.FindCondition(instance.Status.Conditions, dbv1alpha1.ConditionDegraded)
+		if existing == nil || clearedReasons[existing.Reason] {
+			c := util.Degraded(instance.Generation, "", nil)
+			degraded = &c
+		}
+	}
+
+	changed := util.SetCondition(&instance.Status.Conditions, ready)
+	changed = util.SetCondition(&instance.Status.Conditions, progressing) || changed
+	if degraded != nil {
+		changed = util.SetCondition(&instance.Status.Conditions, *degraded) || changed
+	}
+	if resolved.Spec.BackupTo.Configured() {
+		health, err := r.backupHealth(instance)
+		if err != nil {
+			return err
+		}
+		changed = util.SetCondition(&instance.Status.Conditions, health) || changed
+	}
+	if !changed {
+		return nil
+	}
+	return r.client.Status().Update(context.TODO(), instance)
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func conditionsOf(t *testing.T, r *ReconcileDatabase, db *dbv1alpha1.Database) map[dbv1alpha1.ConditionType]dbv1alpha1.Condition {
+	if err := r.reconcileConditions(nameOf(db)); err != nil {
+		t.Fatalf("reconcileConditions threw unexpected error: %s", err)
+	}
+	found := &dbv1alpha1.Database{}
+	if err := r.client.Get(context.TODO(), nameOf(db), found); err != nil {
+		t.Fatalf("Unable to get database: %s", err)
+	}
+	conditions := map[dbv1alpha1.ConditionType]dbv1alpha1.Condition{}
+	for _, c := range found.Status.Conditions {
+		conditions[c.Type] = c
+	}
+	return conditions
+}
+
+func testSecret() *corev1.Secret {
+	return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "testdb", Namespace: "testns"}}
+}
+
+func TestConditionsReady(t *testing.T) {
+	db := testDatabase()
+	db.Status.Phase = dbv1alpha1.Created
+	r := fakeReconciler([]runtime.Object{db, testSecret(), testProvider()})
+	c := conditionsOf(t, r, db)
+	if c[dbv1alpha1.ConditionReady].Status != dbv1alpha1.ConditionTrue {
+		t.Errorf("Database not Ready: %v", c[dbv1alpha1.ConditionReady])
+	}
+	if c[dbv1alpha1.ConditionProgressing].Status != dbv1alpha1.ConditionFalse {
+		t.Errorf("Database Progressing: %v", c[dbv1alpha1.ConditionProgressing])
+	}
+	if c[dbv1alpha1.ConditionDegraded].Status != dbv1alpha1.ConditionFalse {
+		t.Errorf("Database Degraded: %v", c[dbv1alpha1.ConditionDegraded])
+	}
+	if _, ok := c[dbv1alpha1.ConditionBackupHealthy]; ok {
+		t.Errorf("BackupHealthy set without backups configured")
+	}
+}
+
+func TestConditionsSecretMissing(t *testing.T) {
+	db := testDatabase()
+	db.Status.Phase = dbv1alpha1.Created
+	r := fakeReconciler([]runtime.Object{db, testProvider()})
+	c := conditionsOf(t, r, db)
+	if c[dbv1alpha1.ConditionReady].Status != dbv1alpha1.ConditionFalse || c[dbv1alpha1.ConditionReady].Reason != util.SecretMissingReason {
+		t.Errorf("Database Ready without a secret: %v", c[dbv1alpha1.ConditionReady])
+	}
+	if c[dbv1alpha1.ConditionDegraded].Status != dbv1alpha1.ConditionTrue {
+		t.Errorf("Database not Degraded without a secret")
+	}
+
+	// Once the secret is restored it is no longer degraded
+	r.client.Create(context.TODO(), testSecret())
+	c = conditionsOf(t, r, db)
+	if c[dbv1alpha1.ConditionReady].Status != dbv1alpha1.ConditionTrue || c[dbv1alpha1.ConditionDegraded].Status != dbv1alpha1.ConditionFalse {
+		t.Errorf("Database not recovered: %v", c)
+	}
+}
+
+func TestConditionsJobFailed(t *testing.T) {
+	db := testDatabase()
+	db.Status.Phase = dbv1alpha1.Creating
+	r := fakeReconciler([]runtime.Object{db, testProvider()})
+	if err := r.Create(db); err != nil {
+		t.Fatalf("Create threw unexpected error: %s", err)
+	}
+	job, _ := r.getJob(db, util.CreateOperation)
+	job.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+	}
+	r.client.Update(context.TODO(), job)
+	c := conditionsOf(t, r, db)
+	if c[dbv1alpha1.ConditionDegraded].Status != dbv1alpha1.ConditionTrue || c[dbv1alpha1.ConditionDegraded].Reason != util.JobFailedReason {
+		t.Errorf("Failed job not recorded: %v", c[dbv1alpha1.ConditionDegraded])
+	}
+	if c[dbv1alpha1.ConditionProgressing].Status != dbv1alpha1.ConditionTrue || c[dbv1alpha1.ConditionReady].Status != dbv1alpha1.ConditionFalse {
+		t.Errorf("Creating database conditions incorrect: %v", c)
+	}
+}
+
+func TestConditionsProvider(t *testing.T) {
+	unhealthy := testProvider()
+	unhealthy.Status.Conditions = []dbv1alpha1.Condition{{
+		Type:    dbv1alpha1.ConditionReady,
+		Status:  dbv1alpha1.ConditionFalse,
+		Message: "Image isotoma/db-operator-postgresql does not register driver postgresql",
+	}}
+	cases := []struct {
+		objs   []runtime.Object
+		reason string
+	}{
+		{nil, util.ProviderMissingReason},
+		{[]runtime.Object{unhealthy}, util.ProviderUnhealthyReason},
+	}
+	for _, tc := range cases {
+		db := testDatabase()
+		db.Status.Phase = dbv1alpha1.Created
+		r := fakeReconciler(append(tc.objs, db, testSecret()))
+		c := conditionsOf(t, r, db)
+		if c[dbv1alpha1.ConditionDegraded].Status != dbv1alpha1.ConditionTrue || c[dbv1alpha1.ConditionDegraded].Reason != tc.reason {
+			t.Errorf("Expected Degraded %s, got %v", tc.reason, c[dbv1alpha1.ConditionDegraded])
+		}
+
+		// Once the provider is put right it is no longer degraded
+		r.client.Delete(context.TODO(), unhealthy)
+		r.client.Create(context.TODO(), testProvider())
+		c = conditionsOf(t, r, db)
+		if c[dbv1alpha1.ConditionDegraded].Status != dbv1alpha1.ConditionFalse {
+			t.Errorf("Database not recovered: %v", c[dbv1alpha1.ConditionDegraded])
+		}
+	}
+}
+
+func TestConditionsBackupHealthy(t *testing.T) {
+	backup := func(name string, minute int, phase dbv1alpha1.BackupPhase, conditions ...dbv1alpha1.Condition) *dbv1alpha1.Backup {
+		return &dbv1alpha1.Backup{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "testns",
+				CreationTimestamp: metav1.Date(2019, 3, 1, 2, minute, 0, 0, time.UTC),
+			},
+			Spec:   dbv1alpha1.BackupSpec{Database: "testdb"},
+			Status: dbv1alpha1.BackupStatus{Phase: phase, Conditions: conditions},
+		}
+	}
+	failed := dbv1alpha1.Condition{Type: dbv1alpha1.ConditionDegraded, Status: dbv1alpha1.ConditionTrue}
+	unverified := dbv1alpha1.Condition{Type: dbv1alpha1.BackupVerified, Status: dbv1alpha1.ConditionFalse}
+	cases := []struct {
+		backups []runtime.Object
+		status  dbv1alpha1.ConditionStatus
+		reason  string
+	}{
+		{nil, dbv1alpha1.ConditionUnknown, "NoBackups"},
+		{[]runtime.Object{backup("b1", 1, dbv1alpha1.Completed)}, dbv1alpha1.ConditionTrue, "BackupCompleted"},
+		// A backup still in progress does not count
+		{[]runtime.Object{backup("b1", 1, dbv1alpha1.Completed), backup("b2", 2, dbv1alpha1.BackingUp)}, dbv1alpha1.ConditionTrue, "BackupCompleted"},
+		{[]runtime.Object{backup("b1", 1, dbv1alpha1.Completed), backup("b2", 2, dbv1alpha1.BackingUp, failed)}, dbv1alpha1.ConditionFalse, "BackupFailed"},
+		{[]runtime.Object{backup("b1", 1, dbv1alpha1.Completed), backup("b2", 2, dbv1alpha1.Failed)}, dbv1alpha1.ConditionFalse, "BackupFailed"},
+		{[]runtime.Object{backup("b1", 1, dbv1alpha1.Completed, unverified)}, dbv1alpha1.ConditionFalse, "VerificationFailed"},
+		{[]runtime.Object{backup("b1", 1, dbv1alpha1.Completed, unverified), backup("b2", 2, dbv1alpha1.Completed)}, dbv1alpha1.ConditionTrue, "BackupCompleted"},
+	}
+	for _, tc := range cases {
+		db := testDatabase()
+		db.Status.Phase = dbv1alpha1.Created
+		db.Spec.BackupTo.S3.Bucket = "my-backup-bucket"
+		r := fakeReconciler(append(tc.backups, db, testSecret(), testProvider()))
+		c := conditionsOf(t, r, db)[dbv1alpha1.ConditionBackupHealthy]
+		if c.Status != tc.status || c.Reason != tc.reason {
+			t.Errorf("Expected %s %s, got %s %s", tc.status, tc.reason, c.Status, c.Reason)
+		}
+	}
+}
@@ -1,36 +1,114 @@
 package database
 
-import dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
-
-func (r *ReconcileDatabase) Create(instance *dbv1alpha1.Database) chan error {
-	c := make(chan error)
-	go func() {
-		// Create a Job and wait for it
-		c <- nil
-	}()
-	return c
+import (
+	"context"
+	"fmt"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// jobName returns the name of the job that performs op on the database
+func jobName(instance *dbv1alpha1.Database, op util.Operation) string {
+	return instance.Name + "-" + string(op)
 }
 
-func (r *ReconcileDatabase) Drop(instance *dbv1alpha1.Database) chan error {
-	c := make(chan error)
-	go func() {
-		// Create a Job and wait for it
-		c <- nil
-	}()
-	return c
+// jobInstance returns the reference to the instance whose namespace the
+// job to perform op on the database runs in, or nil if it runs in the
+// database's own. A clone runs where the jobs of its source would, if that
+// is on an instance, as it is given the instance's master credentials
+func (r *ReconcileDatabase) jobInstance(instance *dbv1alpha1.Database, op util.Operation) (*dbv1alpha1.InstanceRef, error) {
+	source := instance.Spec.Source
+	if op != util.CloneOperation || source == nil || source.Database == nil {
+		return instance.Spec.Instance, nil
+	}
+	namespace := source.Database.Namespace
+	if namespace == "" {
+		namespace = instance.Namespace
+	}
+	database := &dbv1alpha1.Database{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: source.Database.Name}, database)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return instance.Spec.Instance, nil
+		}
+		return nil, err
+	}
+	ref := util.InstanceOf(database)
+	jobNamespace := util.JobNamespace(instance.Spec.Instance, instance.Namespace)
+	if ref == nil || ref.Namespace == jobNamespace {
+		return instance.Spec.Instance, nil
+	}
+	// A single job cannot be placed with both instances
+	if jobNamespace != instance.Namespace {
+		return nil, fmt.Errorf("Unable to clone database %s/%s on an instance in namespace %s into an instance in namespace %s", namespace, database.Name, ref.Namespace, jobNamespace)
+	}
+	return ref, nil
 }
 
-func (r *ReconcileDatabase) BackupThenDrop(instance *dbv1alpha1.Database) chan error {
-	c := make(chan error)
-	go func() {
-		c2 := r.Backup(instance)
-		err := <-c2
-		if err != nil {
-			c <- err
-		} else {
-			// call Drop
-			c <- nil
+// getJob returns the job launched to perform op on the database, or nil
+// if there is no such job
+func (r *ReconcileDatabase) getJob(instance *dbv1alpha1.Database, op util.Operation) (*batchv1.Job, error) {
+	ref, err := r.jobInstance(instance, op)
+	if err != nil {
+		return nil, err
+	}
+	job := &batchv1.Job{}
+	key := util.DriverJobKey(ref, instance.Namespace, jobName(instance, op))
+	err = r.client.Get(context.TODO(), key, job)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
 		}
-	}()
-	return c
+		return nil, err
+	}
+	return job, nil
+}
+
+// launchJob creates a driver job to perform op on the database, unless
+// one already exists
+func (r *ReconcileDatabase) launchJob(instance *dbv1alpha1.Database, op util.Operation) error {
+	found, err := r.getJob(instance, op)
+	if err != nil || found != nil {
+		return err
+	}
+	resolved, err := util.ResolveDatabase(r.client, instance)
+	if err != nil {
+		return err
+	}
+	ref, err := r.jobInstance(instance, op)
+	if err != nil {
+		return err
+	}
+	// The provider of a database on an instance in another namespace is
+	// taken from there, as its jobs are given the master credentials
+	provider, err := util.GetProvider(r.client, util.JobNamespace(ref, instance.Namespace), resolved.Spec.Provider)
+	if err != nil {
+		r.recorder.Event(instance, corev1.EventTypeWarning, util.ProviderMissingReason, err.Error())
+		return err
+	}
+	job := util.DriverJob(provider, jobName(instance, op), instance.Namespace, op, instance.Name)
+	if err := util.PlaceDriverJob(r.client, r.scheme, job, instance, "Database", ref); err != nil {
+		return err
+	}
+	log.Info("Creating driver job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+	if err := r.client.Create(context.TODO(), job); err != nil {
+		return err
+	}
+	r.recorder.Eventf(instance, corev1.EventTypeNormal, util.JobLaunchedReason, "Launched %s job %s", op, job.Name)
+	return nil
+}
+
+// Create launches a job to create the database
+func (r *ReconcileDatabase) Create(instance *dbv1alpha1.Database) error {
+	return r.launchJob(instance, util.CreateOperation)
+}
+
+// Drop launches a job to drop the database
+func (r *ReconcileDatabase) Drop(instance *dbv1alpha1.Database) error {
+	return r.launchJob(instance, util.DropOperation)
 }
@@ -2,12 +2,17 @@ package database
 
 import (
 	"context"
+	"time"
 
 	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
 	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -22,6 +27,13 @@ var (
 	finalizerName = "database.v1alpha1.db.isotoma.com"
 )
 
+// A failed driver job is retried after minJobBackoff, doubling with each
+// failure in a row up to maxJobBackoff
+const (
+	minJobBackoff = 10 * time.Second
+	maxJobBackoff = 10 * time.Minute
+)
+
 /**
 * USER ACTION REQUIRED: This is a scaffold file intended for the user to modify with their own Controller
 * business logic.  Delete these comments after modifying this file.*
@@ -35,7 +47,11 @@ func Add(mgr manager.Manager) error {
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileDatabase{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+	return &ReconcileDatabase{
+		client:   mgr.GetClient(),
+		scheme:   mgr.GetScheme(),
+		recorder: mgr.GetRecorder("database-controller"),
+	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -52,9 +68,125 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
-	// TODO(user): Modify this to be the types you create that are owned by the primary resource
-	// Watch for changes to secondary resource Pods and requeue the owner Database
-	err = c.Watch(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestForOwner{
+	// Watch for changes to the driver Jobs and requeue the owner Database
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &dbv1alpha1.Database{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the driver Jobs run in the namespace of an
+	// instance, which the Database cannot own
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, util.EnqueueJobOwner("Database"))
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the connection Secrets, so that the database is
+	// no longer Ready if its secret is removed
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &dbv1alpha1.Database{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to Backups, which are not necessarily owned by the
+	// database, to keep its BackupHealthy condition up to date and to
+	// follow the backup taken before it is deleted
+	err = c.Watch(&source.Kind{Type: &dbv1alpha1.Backup{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			backup, ok := o.Object.(*dbv1alpha1.Backup)
+			if !ok {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{
+				Namespace: backup.Namespace,
+				Name:      backup.Spec.Database,
+			}}}
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to Providers, so that the databases using one,
+	// directly or through an instance, are Degraded if it goes missing or
+	// is unhealthy
+	cl := mgr.GetClient()
+	err = c.Watch(&source.Kind{Type: &dbv1alpha1.Provider{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			provider, ok := o.Object.(*dbv1alpha1.Provider)
+			if !ok {
+				return nil
+			}
+			databases := &dbv1alpha1.DatabaseList{}
+			if err := cl.List(context.TODO(), &client.ListOptions{Namespace: provider.Namespace}, databases); err != nil {
+				return nil
+			}
+			var requests []reconcile.Request
+			for _, db := range databases.Items {
+				if db.Spec.Provider == provider.Spec.Name {
+					requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+						Namespace: db.Namespace,
+						Name:      db.Name,
+					}})
+				}
+			}
+			instances := &dbv1alpha1.DatabaseInstanceList{}
+			if err := cl.List(context.TODO(), &client.ListOptions{Namespace: provider.Namespace}, instances); err != nil {
+				return requests
+			}
+			all := &dbv1alpha1.DatabaseList{}
+			if err := cl.List(context.TODO(), &client.ListOptions{}, all); err != nil {
+				return requests
+			}
+			for _, instance := range instances.Items {
+				if instance.Spec.Provider != provider.Spec.Name {
+					continue
+				}
+				for _, db := range util.OnInstance(all.Items, instance.Namespace, instance.Name) {
+					requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+						Namespace: db.Namespace,
+						Name:      db.Name,
+					}})
+				}
+			}
+			return requests
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to DatabaseInstances, which the databases on them
+	// take their provider and backup destination from
+	err = c.Watch(&source.Kind{Type: &dbv1alpha1.DatabaseInstance{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			databases := &dbv1alpha1.DatabaseList{}
+			if err := cl.List(context.TODO(), &client.ListOptions{}, databases); err != nil {
+				return nil
+			}
+			var requests []reconcile.Request
+			for _, db := range util.OnInstance(databases.Items, o.Meta.GetNamespace(), o.Meta.GetName()) {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+					Namespace: db.Namespace,
+					Name:      db.Name,
+				}})
+			}
+			return requests
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the Restores that populate databases from a
+	// backup
+	err = c.Watch(&source.Kind{Type: &dbv1alpha1.Restore{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
 		OwnerType:    &dbv1alpha1.Database{},
 	})
@@ -71,14 +203,108 @@ var _ reconcile.Reconciler = &ReconcileDatabase{}
 type ReconcileDatabase struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
-	client client.Client
-	scheme *runtime.Scheme
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
 }
 
 // UpdatePhase updates the phase of the database to the one requested
 func (r *ReconcileDatabase) UpdatePhase(instance *dbv1alpha1.Database, phase dbv1alpha1.DatabasePhase) error {
+	previous := instance.Status.Phase
 	instance.Status.Phase = phase
-	return r.client.Update(context.TODO(), instance)
+	if previous != phase {
+		instance.Status.FailedJobs = 0
+	}
+	if err := r.client.Status().Update(context.TODO(), instance); err != nil {
+		return err
+	}
+	if previous != phase {
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, string(phase), "Database is %s", phase)
+	}
+	return nil
+}
+
+// followJob moves the database to the next phase once the job performing op
+// has succeeded. If the job has gone missing it is launched again, as it is
+// once a failed job has been removed
+func (r *ReconcileDatabase) followJob(instance *dbv1alpha1.Database, op util.Operation, next dbv1alpha1.DatabasePhase) (reconcile.Result, error) {
+	job, err := r.getJob(instance, op)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	switch {
+	case job == nil:
+		return reconcile.Result{}, r.launchJob(instance, op)
+	case util.JobSucceeded(job):
+		return reconcile.Result{}, r.UpdatePhase(instance, next)
+	case util.JobFailed(job):
+		return r.retryJob(instance, job)
+	}
+	return reconcile.Result{}, nil
+}
+
+// jobBackoff returns how long to wait before retrying a driver job that has
+// failed the given number of times in a row
+func jobBackoff(failed int32) time.Duration {
+	backoff := minJobBackoff
+	for i := int32(1); i < failed && backoff < maxJobBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxJobBackoff {
+		return maxJobBackoff
+	}
+	return backoff
+}
+
+// retryJob records the failure of a driver job the first time it is seen,
+// and removes the job once its backoff has passed. Its deletion will
+// requeue us, and followJob launches it again
+func (r *ReconcileDatabase) retryJob(instance *dbv1alpha1.Database, job *batchv1.Job) (reconcile.Result, error) {
+	if _, ok := job.Annotations[util.FailureRecordedAnnotation]; !ok {
+		log.Info("Driver job failed", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		if job.Annotations == nil {
+			job.Annotations = map[string]string{}
+		}
+		job.Annotations[util.FailureRecordedAnnotation] = "true"
+		if err := r.client.Update(context.TODO(), job); err != nil {
+			return reconcile.Result{}, err
+		}
+		instance.Status.FailedJobs++
+		if err := r.client.Status().Update(context.TODO(), instance); err != nil {
+			return reconcile.Result{}, err
+		}
+		r.recorder.Eventf(instance, corev1.EventTypeWarning, util.JobFailedReason, "Job %s failed, retrying in %s",
+			job.Name, jobBackoff(instance.Status.FailedJobs))
+	}
+	retry := util.JobFinishedAt(job).Add(jobBackoff(instance.Status.FailedJobs))
+	if wait := retry.Sub(time.Now()); wait > 0 {
+		return reconcile.Result{RequeueAfter: wait}, nil
+	}
+	log.Info("Removing failed driver job to retry it", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+	err := r.client.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	return reconcile.Result{}, err
+}
+
+// deleteUnfinished handles a database deleted before it was Created. It
+// holds nothing worth backing up, but may exist on the server, so it is
+// dropped once its create job has finished. Populating it is stopped first
+func (r *ReconcileDatabase) deleteUnfinished(instance *dbv1alpha1.Database) (reconcile.Result, error) {
+	switch instance.Status.Phase {
+	case dbv1alpha1.Creating:
+		job, err := r.getJob(instance, util.CreateOperation)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if job != nil && !util.JobSucceeded(job) && !util.JobFailed(job) {
+			log.Info("Deleted while creating, waiting for the create job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			return reconcile.Result{}, nil
+		}
+	case dbv1alpha1.Populating:
+		if err := r.stopPopulating(instance); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	return reconcile.Result{}, r.UpdatePhase(instance, dbv1alpha1.DeletionRequested)
 }
 
 // Reconcile reads that state of the cluster for a Database object and makes changes based on the state read
@@ -99,43 +325,109 @@ func (r *ReconcileDatabase) Reconcile(request reconcile.Request) (reconcile.Resu
 		return reconcile.Result{}, err
 	}
 
+	// The conditions are brought up to date whatever happened, so that an
+	// error is reflected in them
+	result, err := r.reconcile(instance)
+	if condErr := r.reconcileConditions(request.NamespacedName); condErr != nil && err == nil {
+		return result, condErr
+	}
+	return result, err
+}
+
+// reconcile moves the database through its phases
+func (r *ReconcileDatabase) reconcile(instance *dbv1alpha1.Database) (reconcile.Result, error) {
 	switch {
 	case instance.Status.Phase == "":
-		if err := r.UpdatePhase(instance, dbv1alpha1.Creating); err != nil {
-			return reconcile.Result{}, err
-
+		if instance.DeletionTimestamp != nil {
+			// Nothing was created, so there is nothing to drop
+			if util.RemoveFinalizer(&instance.ObjectMeta, finalizerName) {
+				return reconcile.Result{}, r.client.Update(context.TODO(), instance)
+			}
+			return reconcile.Result{}, nil
+		}
+		// The finalizer is added before anything is created, so that we
+		// have the opportunity to drop the database however early this
+		// resource is deleted
+		if util.AddFinalizer(&instance.ObjectMeta, finalizerName) {
+			if err := r.client.Update(context.TODO(), instance); err != nil {
+				return reconcile.Result{}, err
+			}
 		}
-		c := r.Create(instance)
-		if err := <-c; err != nil {
+		if err := r.Create(instance); err != nil {
 			return reconcile.Result{}, err
 		}
-		if err := r.UpdatePhase(instance, dbv1alpha1.Created); err != nil {
+		if err := r.UpdatePhase(instance, dbv1alpha1.Creating); err != nil {
 			return reconcile.Result{}, err
-
 		}
 		return reconcile.Result{}, nil
-	case instance.Status.Phase == "Created":
-		// the driver has completed the creation process. We need to add a
-		// finalizer so we have the opportunity to drop/backup the database
-		// if this resource is deleted
+	case instance.Status.Phase == dbv1alpha1.Creating ||
+		instance.Status.Phase == dbv1alpha1.Populating:
+		if instance.DeletionTimestamp != nil {
+			return r.deleteUnfinished(instance)
+		}
+		if instance.Status.Phase == dbv1alpha1.Populating {
+			return r.populate(instance)
+		}
+		// The driver moves the phase on itself, but we follow the job too in
+		// case it completed without doing so
+		return r.followJob(instance, util.CreateOperation, createdPhase(instance))
+	case instance.Status.Phase == dbv1alpha1.Created:
+		// the driver has completed the creation process. The finalizer is
+		// added when it is first reconciled, but databases created before
+		// that are given it here
 		if util.AddFinalizer(&instance.ObjectMeta, finalizerName) {
 			if err := r.client.Update(context.TODO(), instance); err != nil {
 				return reconcile.Result{}, err
 			}
 		}
 		if instance.ObjectMeta.DeletionTimestamp != nil {
-			// decide whether to back up first or just delete
-			if instance.Spec.BackupTo.S3.Bucket != "" {
+			// decide whether to back up first or just delete. Scratch
+			// databases that backups were verified in are never backed up
+			_, scratch := instance.Labels[dbv1alpha1.VerificationLabel]
+			resolved, err := util.ResolveDatabase(r.client, instance)
+			if err != nil {
+				return reconcile.Result{}, err
+			}
+			if resolved.Spec.BackupTo.Configured() && !scratch {
+				if _, err := r.backupBeforeDelete(instance); err != nil {
+					return reconcile.Result{}, err
+				}
 				if err := r.UpdatePhase(instance, dbv1alpha1.BackupBeforeDeleteRequested); err != nil {
 					return reconcile.Result{}, err
 				}
-				err := r.BackupThenDrop(instance)
+				return reconcile.Result{}, nil
+			}
+			if err := r.UpdatePhase(instance, dbv1alpha1.DeletionRequested); err != nil {
 				return reconcile.Result{}, err
 			}
-			// start a drop job, which cycles the Phase through Deleting to Deleted
-
+			return reconcile.Result{}, nil
+		}
+		return r.reconcileRotation(instance)
+	case instance.Status.Phase == dbv1alpha1.RotationRequested ||
+		instance.Status.Phase == dbv1alpha1.RotationInProgress:
+		return r.followJob(instance, util.RotateOperation, dbv1alpha1.Created)
+	case instance.Status.Phase == dbv1alpha1.BackupCompleted:
+		if err := r.UpdatePhase(instance, dbv1alpha1.Created); err != nil {
+			return reconcile.Result{}, err
+		}
+	case instance.Status.Phase == dbv1alpha1.BackupBeforeDeleteRequested ||
+		instance.Status.Phase == dbv1alpha1.BackupBeforeDeleteInProgress:
+		return r.followBackupBeforeDelete(instance)
+	case instance.Status.Phase == dbv1alpha1.BackupBeforeDeleteCompleted:
+		if err := r.UpdatePhase(instance, dbv1alpha1.DeletionRequested); err != nil {
+			return reconcile.Result{}, err
+		}
+	case instance.Status.Phase == dbv1alpha1.DeletionRequested:
+		// start a drop job, which cycles the Phase through Deleting to Deleted
+		if err := r.Drop(instance); err != nil {
+			return reconcile.Result{}, err
+		}
+		if err := r.UpdatePhase(instance, dbv1alpha1.DeletionInProgress); err != nil {
+			return reconcile.Result{}, err
 		}
-	case instance.Status.Phase == "Deleted":
+	case instance.Status.Phase == dbv1alpha1.DeletionInProgress:
+		return r.followJob(instance, util.DropOperation, dbv1alpha1.Deleted)
+	case instance.Status.Phase == dbv1alpha1.Deleted:
 		// The driver has completed the deletion process, so we can remove
 		// the finalizer and allow the resource to be finally deleted
 		if util.RemoveFinalizer(&instance.ObjectMeta, finalizerName) {
@@ -0,0 +1,313 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func testDatabase() *dbv1alpha1.Database {
+	return &dbv1alpha1.Database{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "testdb",
+			Namespace: "testns",
+		},
+		Spec: dbv1alpha1.DatabaseSpec{
+			Provider: "postgresql",
+		},
+	}
+}
+
+func testProvider() *dbv1alpha1.Provider {
+	return &dbv1alpha1.Provider{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "postgresql-provider",
+			Namespace: "testns",
+		},
+		Spec: dbv1alpha1.ProviderSpec{
+			Name:    "postgresql",
+			Image:   "isotoma/db-operator-postgresql",
+			Command: []string{"driver"},
+		},
+	}
+}
+
+func nameOf(db *dbv1alpha1.Database) types.NamespacedName {
+	return types.NamespacedName{Namespace: db.Namespace, Name: db.Name}
+}
+
+func envValue(job *batchv1.Job, name string) string {
+	for _, e := range job.Spec.Template.Spec.Containers[0].Env {
+		if e.Name == name {
+			return e.Value
+		}
+	}
+	return ""
+}
+
+func TestCreateLaunchesJob(t *testing.T) {
+	db := testDatabase()
+	r := fakeReconciler([]runtime.Object{db, testProvider()})
+	if err := r.Create(db); err != nil {
+		t.Fatalf("Create threw unexpected error: %s", err)
+	}
+	job, err := r.getJob(db, util.CreateOperation)
+	if err != nil {
+		t.Fatalf("getJob threw unexpected error: %s", err)
+	}
+	if job == nil {
+		t.Fatalf("Create did not launch a job")
+	}
+	if job.Spec.Template.Spec.Containers[0].Image != "isotoma/db-operator-postgresql" {
+		t.Errorf("Job does not use the provider image")
+	}
+	if envValue(job, "DB_OPERATOR_DATABASE") != "testdb" {
+		t.Errorf("DB_OPERATOR_DATABASE not set on job")
+	}
+	if envValue(job, "DB_OPERATOR_NAMESPACE") != "testns" {
+		t.Errorf("DB_OPERATOR_NAMESPACE not set on job")
+	}
+	if envValue(job, "DB_OPERATOR_OPERATION") != "create" {
+		t.Errorf("DB_OPERATOR_OPERATION not set on job")
+	}
+	if len(job.OwnerReferences) != 1 || job.OwnerReferences[0].Name != "testdb" {
+		t.Errorf("Job is not owned by the database")
+	}
+}
+
+func TestCreateMissingProvider(t *testing.T) {
+	db := testDatabase()
+	r := fakeReconciler([]runtime.Object{db})
+	if err := r.Create(db); err == nil {
+		t.Errorf("Create did not fail without a provider")
+	}
+}
+
+func TestCreateOnInstance(t *testing.T) {
+	instance := &dbv1alpha1.DatabaseInstance{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "testinstance",
+			Namespace:   "dbas",
+			Annotations: map[string]string{dbv1alpha1.AllowNamespacesAnnotation: "testns"},
+		},
+		Spec: dbv1alpha1.DatabaseInstanceSpec{Provider: "postgresql"},
+	}
+	db := testDatabase()
+	db.Spec.Provider = ""
+	db.Spec.Instance = &dbv1alpha1.InstanceRef{Name: "testinstance", Namespace: "dbas"}
+	// The provider in the database's namespace is ignored, as the job is
+	// given the master credentials
+	tenant := testProvider()
+	tenant.Spec.Image = "tenant/image"
+	provider := testProvider()
+	provider.Namespace = "dbas"
+	r := fakeReconciler([]runtime.Object{db, instance, tenant, provider})
+	if err := r.Create(db); err != nil {
+		t.Fatalf("Create threw unexpected error: %s", err)
+	}
+	job, _ := r.getJob(db, util.CreateOperation)
+	if job == nil {
+		t.Fatalf("Create did not launch a job with the provider of the instance")
+	}
+	if job.Namespace != "dbas" || job.Name != "testns-testdb-create" {
+		t.Errorf("Job %s/%s not run in the namespace of the instance", job.Namespace, job.Name)
+	}
+	if job.Spec.Template.Spec.Containers[0].Image != "isotoma/db-operator-postgresql" {
+		t.Errorf("Job uses the provider in the database's namespace")
+	}
+	if envValue(job, "DB_OPERATOR_NAMESPACE") != "testns" {
+		t.Errorf("DB_OPERATOR_NAMESPACE is not the namespace of the database")
+	}
+	if job.Labels[util.OwnerNamespaceLabel] != "testns" || job.Labels[util.OwnerNameLabel] != "testdb" {
+		t.Errorf("Job is not labelled with the database, got %v", job.Labels)
+	}
+	if len(job.OwnerReferences) != 1 || job.OwnerReferences[0].Kind != "DatabaseInstance" {
+		t.Errorf("Job is not owned by the instance")
+	}
+	if db.Spec.Provider != "" {
+		t.Errorf("Provider of the instance copied into the database")
+	}
+
+	// The database is Degraded while its instance cannot be used
+	r.client.Delete(context.TODO(), instance)
+	db.Status.Phase = dbv1alpha1.Creating
+	r.client.Status().Update(context.TODO(), db)
+	c := conditionsOf(t, r, db)[dbv1alpha1.ConditionDegraded]
+	if c.Status != dbv1alpha1.ConditionTrue || c.Reason != util.InstanceUnavailableReason {
+		t.Errorf("Expected Degraded InstanceUnavailable, got %v", c)
+	}
+}
+
+func TestFollowJobSucceeded(t *testing.T) {
+	db := testDatabase()
+	db.Status.Phase = dbv1alpha1.Creating
+	r := fakeReconciler([]runtime.Object{db, testProvider()})
+	if err := r.Create(db); err != nil {
+		t.Fatalf("Create threw unexpected error: %s", err)
+	}
+	if _, err := r.followJob(db, util.CreateOperation, dbv1alpha1.Created); err != nil {
+		t.Fatalf("followJob threw unexpected error: %s", err)
+	}
+	if db.Status.Phase != dbv1alpha1.Creating {
+		t.Errorf("Phase changed before the job completed")
+	}
+	job, _ := r.getJob(db, util.CreateOperation)
+	job.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+	}
+	if err := r.client.Update(context.TODO(), job); err != nil {
+		t.Fatalf("Unable to update job: %s", err)
+	}
+	if _, err := r.followJob(db, util.CreateOperation, dbv1alpha1.Created); err != nil {
+		t.Fatalf("followJob threw unexpected error: %s", err)
+	}
+	if db.Status.Phase != dbv1alpha1.Created {
+		t.Errorf("Phase not moved on after the job completed")
+	}
+}
+
+func TestFinalizerAddedBeforeCreate(t *testing.T) {
+	db := testDatabase()
+	r := fakeReconciler([]runtime.Object{db, testProvider()})
+	found, err := reconcileDatabase(t, r, db)
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if found.Status.Phase != dbv1alpha1.Creating {
+		t.Errorf("Create not started, phase is %s", found.Status.Phase)
+	}
+	if len(found.Finalizers) != 1 || found.Finalizers[0] != finalizerName {
+		t.Errorf("Finalizer not added before creating, got %v", found.Finalizers)
+	}
+}
+
+func TestDeletedWhileCreating(t *testing.T) {
+	db := deletedDatabase(dbv1alpha1.Creating)
+	r := fakeReconciler([]runtime.Object{db, testProvider()})
+	if err := r.Create(db); err != nil {
+		t.Fatalf("Create threw unexpected error: %s", err)
+	}
+	found, err := reconcileDatabase(t, r, db)
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if found.Status.Phase != dbv1alpha1.Creating {
+		t.Errorf("Moved on before the create job finished: %s", found.Status.Phase)
+	}
+	job, _ := r.getJob(db, util.CreateOperation)
+	job.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+	}
+	r.client.Update(context.TODO(), job)
+	// Even a failed create may have left the database on the server, and
+	// nothing worth backing up
+	found, err = reconcileDatabase(t, r, db)
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if found.Status.Phase != dbv1alpha1.DeletionRequested {
+		t.Errorf("Expected DeletionRequested, got %s", found.Status.Phase)
+	}
+}
+
+func TestDeletedWhilePopulating(t *testing.T) {
+	db := deletedDatabase(dbv1alpha1.Populating)
+	db.Spec.Source = &dbv1alpha1.DatabaseSource{Backup: "testbackup"}
+	restore := &dbv1alpha1.Restore{
+		ObjectMeta: metav1.ObjectMeta{Name: "testdb-source", Namespace: "testns"},
+		Spec:       dbv1alpha1.RestoreSpec{Backup: "testbackup", Database: "testdb"},
+	}
+	r := fakeReconciler([]runtime.Object{db, restore, testProvider()})
+	found, err := reconcileDatabase(t, r, db)
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if found.Status.Phase != dbv1alpha1.DeletionRequested {
+		t.Errorf("Expected DeletionRequested, got %s", found.Status.Phase)
+	}
+	key := types.NamespacedName{Namespace: "testns", Name: "testdb-source"}
+	if err := r.client.Get(context.TODO(), key, &dbv1alpha1.Restore{}); err == nil {
+		t.Errorf("Source restore not stopped")
+	}
+}
+
+func TestFailedJobRetried(t *testing.T) {
+	db := testDatabase()
+	db.Status.Phase = dbv1alpha1.Creating
+	r := fakeReconciler([]runtime.Object{db, testProvider()})
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+	if err := r.Create(db); err != nil {
+		t.Fatalf("Create threw unexpected error: %s", err)
+	}
+	job, _ := r.getJob(db, util.CreateOperation)
+	job.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, LastTransitionTime: metav1.Now()},
+	}
+	r.client.Update(context.TODO(), job)
+	events(recorder)
+
+	// The failure is recorded once, and the job kept until its backoff
+	for i := 0; i < 2; i++ {
+		result, err := r.Reconcile(reconcile.Request{NamespacedName: nameOf(db)})
+		if err != nil {
+			t.Fatalf("Reconcile threw unexpected error: %s", err)
+		}
+		if result.RequeueAfter <= 0 || result.RequeueAfter > minJobBackoff {
+			t.Errorf("Expected a requeue within %s, got %s", minJobBackoff, result.RequeueAfter)
+		}
+	}
+	recorded := events(recorder)
+	if len(recorded) != 1 || recorded[0] != "Warning JobFailed Job testdb-create failed, retrying in 10s" {
+		t.Errorf("Expected one JobFailed warning, got %v", recorded)
+	}
+	if job, _ := r.getJob(db, util.CreateOperation); job == nil {
+		t.Errorf("Failed job removed before its backoff")
+	}
+
+	// Once the backoff has passed the job is removed, and then relaunched
+	job, _ = r.getJob(db, util.CreateOperation)
+	job.Status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-minJobBackoff))
+	r.client.Update(context.TODO(), job)
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: nameOf(db)}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if job, _ := r.getJob(db, util.CreateOperation); job != nil {
+		t.Fatalf("Failed job not removed after its backoff")
+	}
+	found, err := reconcileDatabase(t, r, db)
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if job, _ := r.getJob(db, util.CreateOperation); job == nil || util.JobFailed(job) {
+		t.Errorf("Failed job not relaunched")
+	}
+	if found.Status.Phase != dbv1alpha1.Creating || found.Status.FailedJobs != 1 {
+		t.Errorf("Expected Creating with 1 failed job, got %s with %d", found.Status.Phase, found.Status.FailedJobs)
+	}
+}
+
+func TestJobBackoff(t *testing.T) {
+	cases := map[int32]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		3:  40 * time.Second,
+		10: maxJobBackoff,
+		64: maxJobBackoff,
+	}
+	for failed, expected := range cases {
+		if backoff := jobBackoff(failed); backoff != expected {
+			t.Errorf("Expected a backoff of %s after %d failures, got %s", expected, failed, backoff)
+		}
+	}
+}
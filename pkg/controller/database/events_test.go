@@ -0,0 +1,57 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// events returns the events recorded so far
+func events(recorder *record.FakeRecorder) []string {
+	var found []string
+	for {
+		select {
+		case e := <-recorder.Events:
+			found = append(found, e)
+		default:
+			return found
+		}
+	}
+}
+
+func TestEventsCreate(t *testing.T) {
+	db := testDatabase()
+	r := fakeReconciler([]runtime.Object{db, testProvider()})
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: nameOf(db)}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	expected := []string{
+		"Normal JobLaunched Launched create job testdb-create",
+		"Normal Creating Database is Creating",
+	}
+	if found := events(recorder); strings.Join(found, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected events %v, got %v", expected, found)
+	}
+}
+
+func TestEventsProviderMissing(t *testing.T) {
+	db := testDatabase()
+	r := fakeReconciler([]runtime.Object{db})
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: nameOf(db)}); err == nil {
+		t.Errorf("Expected error without a provider")
+	}
+	found := events(recorder)
+	if len(found) != 1 || !strings.HasPrefix(found[0], "Warning ProviderMissing ") {
+		t.Errorf("Expected a ProviderMissing warning, got %v", found)
+	}
+	if db.Status.Phase != "" {
+		t.Errorf("Phase changed without a provider")
+	}
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	"github.com/robfig/cron"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// nextRotation returns when the password of the database user is next due
+// to be rotated, and false if no rotation is scheduled
+func nextRotation(instance *dbv1alpha1.Database) (time.Time, bool, error) {
+	rotation := instance.Spec.Rotation
+	last := instance.CreationTimestamp.Time
+	if instance.Status.LastRotated != nil {
+		last = instance.Status.LastRotated.Time
+	}
+	switch {
+	case rotation.Schedule != "":
+		schedule, err := cron.ParseStandard(rotation.Schedule)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		return schedule.Next(last), true, nil
+	case rotation.Interval.Duration > 0:
+		return last.Add(rotation.Interval.Duration), true, nil
+	}
+	return time.Time{}, false, nil
+}
+
+// Rotate launches a job to rotate the password of the database user
+func (r *ReconcileDatabase) Rotate(instance *dbv1alpha1.Database) error {
+	if err := r.launchJob(instance, util.RotateOperation); err != nil {
+		return err
+	}
+	if _, ok := instance.Annotations[dbv1alpha1.RotateAnnotation]; ok {
+		delete(instance.Annotations, dbv1alpha1.RotateAnnotation)
+		if err := r.client.Update(context.TODO(), instance); err != nil {
+			return err
+		}
+	}
+	return r.UpdatePhase(instance, dbv1alpha1.RotationRequested)
+}
+
+// reconcileRotation rotates the password of the database user if it has
+// been requested or is due, and otherwise requeues for when it will be due
+func (r *ReconcileDatabase) reconcileRotation(instance *dbv1alpha1.Database) (reconcile.Result, error) {
+	_, requested := instance.Annotations[dbv1alpha1.RotateAnnotation]
+	next, scheduled, err := nextRotation(instance)
+	if err != nil {
+		log.Error(err, "Invalid rotation schedule", "Database.Name", instance.Name)
+		scheduled = false
+	}
+	if !requested && !scheduled {
+		return reconcile.Result{}, nil
+	}
+	if wait := next.Sub(time.Now()); !requested && wait > 0 {
+		return reconcile.Result{RequeueAfter: wait}, nil
+	}
+	// The job from the previous rotation must be removed before another
+	// is launched. Its deletion will requeue us
+	job, err := r.getJob(instance, util.RotateOperation)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if job != nil {
+		log.Info("Removing previous rotation job", "Job.Name", job.Name)
+		err := r.client.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, r.Rotate(instance)
+}
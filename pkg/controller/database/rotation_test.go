@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestNextRotation(t *testing.T) {
+	created := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	rotated := metav1.NewTime(time.Date(2019, 2, 1, 12, 0, 0, 0, time.UTC))
+	cases := []struct {
+		name      string
+		rotation  dbv1alpha1.Rotation
+		last      *metav1.Time
+		next      time.Time
+		scheduled bool
+	}{
+		{"none", dbv1alpha1.Rotation{}, nil, time.Time{}, false},
+		{"interval from creation", dbv1alpha1.Rotation{Interval: metav1.Duration{Duration: 90 * 24 * time.Hour}}, nil,
+			time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC), true},
+		{"interval from rotation", dbv1alpha1.Rotation{Interval: metav1.Duration{Duration: 24 * time.Hour}}, &rotated,
+			time.Date(2019, 2, 2, 12, 0, 0, 0, time.UTC), true},
+		{"schedule", dbv1alpha1.Rotation{Schedule: "0 3 1 * *"}, &rotated,
+			time.Date(2019, 3, 1, 3, 0, 0, 0, time.UTC), true},
+	}
+	for _, c := range cases {
+		db := testDatabase()
+		db.CreationTimestamp = metav1.NewTime(created)
+		db.Spec.Rotation = c.rotation
+		db.Status.LastRotated = c.last
+		next, scheduled, err := nextRotation(db)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+		}
+		if scheduled != c.scheduled || !next.Equal(c.next) {
+			t.Errorf("%s: expected %s (%t), got %s (%t)", c.name, c.next, c.scheduled, next, scheduled)
+		}
+	}
+	db := testDatabase()
+	db.Spec.Rotation.Schedule = "every tuesday"
+	if _, _, err := nextRotation(db); err == nil {
+		t.Errorf("Expected an error for an invalid schedule")
+	}
+}
+
+func TestReconcileRotationNotDue(t *testing.T) {
+	db := testDatabase()
+	db.CreationTimestamp = metav1.Now()
+	db.Status.Phase = dbv1alpha1.Created
+	db.Spec.Rotation.Interval = metav1.Duration{Duration: time.Hour}
+	r := fakeReconciler([]runtime.Object{db, testProvider()})
+	result, err := r.reconcileRotation(db)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if result.RequeueAfter <= 0 || result.RequeueAfter > time.Hour {
+		t.Errorf("Expected a requeue within the hour, got %s", result.RequeueAfter)
+	}
+	if job, _ := r.getJob(db, util.RotateOperation); job != nil {
+		t.Errorf("Rotation job launched before it was due")
+	}
+}
+
+func TestReconcileRotationRequested(t *testing.T) {
+	db := testDatabase()
+	db.CreationTimestamp = metav1.Now()
+	db.Status.Phase = dbv1alpha1.Created
+	db.Annotations = map[string]string{dbv1alpha1.RotateAnnotation: "true"}
+	r := fakeReconciler([]runtime.Object{db, testProvider()})
+	if _, err := r.reconcileRotation(db); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if job, _ := r.getJob(db, util.RotateOperation); job == nil {
+		t.Errorf("Rotation job not launched")
+	}
+	found := &dbv1alpha1.Database{}
+	if err := r.client.Get(context.TODO(), nameOf(db), found); err != nil {
+		t.Fatalf("Unable to get database: %s", err)
+	}
+	if _, ok := found.Annotations[dbv1alpha1.RotateAnnotation]; ok {
+		t.Errorf("Rotate annotation not removed")
+	}
+	if found.Status.Phase != dbv1alpha1.RotationRequested {
+		t.Errorf("Expected phase RotationRequested, got %s", found.Status.Phase)
+	}
+}
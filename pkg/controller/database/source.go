@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// sourceRestoreName is the name of the restore that populates the database
+// from a backup
+func sourceRestoreName(instance *dbv1alpha1.Database) string {
+	return instance.Name + "-source"
+}
+
+// sourceName describes the source of the database, as recorded once it has
+// been populated
+func sourceName(instance *dbv1alpha1.Database) string {
+	source := instance.Spec.Source
+	if source.Backup != "" {
+		return "backup/" + source.Backup
+	}
+	namespace := source.Database.Namespace
+	if namespace == "" {
+		namespace = instance.Namespace
+	}
+	return "database/" + namespace + "/" + source.Database.Name
+}
+
+// createdPhase returns the phase a newly created database moves to, which
+// is Populating until it has been populated from its source
+func createdPhase(instance *dbv1alpha1.Database) dbv1alpha1.DatabasePhase {
+	if instance.Populated() {
+		return dbv1alpha1.Created
+	}
+	return dbv1alpha1.Populating
+}
+
+// checkSource returns an error if the database cannot be populated from its
+// source, or cannot be yet
+func (r *ReconcileDatabase) checkSource(instance *dbv1alpha1.Database) error {
+	source := instance.Spec.Source
+	switch {
+	case source.Backup != "":
+		backup := &dbv1alpha1.Backup{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: source.Backup}, backup)
+		if err != nil {
+			return err
+		}
+		if backup.Status.Phase != dbv1alpha1.Completed {
+			return fmt.Errorf("Source backup %s has not completed", backup.Name)
+		}
+	case source.Database != nil:
+		namespace := source.Database.Namespace
+		if namespace == "" {
+			namespace = instance.Namespace
+		}
+		database := &dbv1alpha1.Database{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: source.Database.Name}, database)
+		if err != nil {
+			return err
+		}
+		if !util.AllowsNamespace(database, instance.Namespace) {
+			return fmt.Errorf("Source database %s/%s does not allow cloning into namespace %s", namespace, database.Name, instance.Namespace)
+		}
+		// The clone is given the master credentials of the source's
+		// instance, which must allow that too
+		sourceInstance, err := util.GetInstance(r.client, database)
+		if err != nil {
+			return err
+		}
+		if sourceInstance != nil && !util.AllowsNamespace(sourceInstance, instance.Namespace) {
+			return fmt.Errorf("Instance %s/%s of source database %s/%s does not allow cloning into namespace %s", sourceInstance.Namespace, sourceInstance.Name, namespace, database.Name, instance.Namespace)
+		}
+		// Either may take its provider from an instance
+		if database, err = util.ResolveDatabase(r.client, database); err != nil {
+			return err
+		}
+		resolved, err := util.ResolveDatabase(r.client, instance)
+		if err != nil {
+			return err
+		}
+		if database.Spec.Provider != resolved.Spec.Provider {
+			return fmt.Errorf("Source database %s/%s uses provider %s, not %s", namespace, database.Name, database.Spec.Provider, resolved.Spec.Provider)
+		}
+		if database.Status.Phase != dbv1alpha1.Created {
+			return fmt.Errorf("Source database %s/%s is %s, not Created", namespace, database.Name, database.Status.Phase)
+		}
+	default:
+		return fmt.Errorf("Source of database %s names neither a database nor a backup", instance.Name)
+	}
+	return nil
+}
+
+// populated records that the database has been populated from its source
+func (r *ReconcileDatabase) populated(instance *dbv1alpha1.Database) error {
+	log.Info("Database populated", "Database.Name", instance.Name, "Source", sourceName(instance))
+	instance.Status.PopulatedFrom = sourceName(instance)
+	return r.UpdatePhase(instance, dbv1alpha1.Created)
+}
+
+// populateFromBackup restores the source backup into the database, with a
+// restore owned by the database
+func (r *ReconcileDatabase) populateFromBackup(instance *dbv1alpha1.Database) error {
+	restore := &dbv1alpha1.Restore{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: sourceRestoreName(instance)}, restore)
+	if errors.IsNotFound(err) {
+		restore = &dbv1alpha1.Restore{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      sourceRestoreName(instance),
+				Namespace: instance.Namespace,
+			},
+			Spec: dbv1alpha1.RestoreSpec{
+				Backup:   instance.Spec.Source.Backup,
+				Database: instance.Name,
+			},
+		}
+		if err := controllerutil.SetControllerReference(instance, restore, r.scheme); err != nil {
+			return err
+		}
+		log.Info("Creating source restore", "Restore.Name", restore.Name)
+		return r.client.Create(context.TODO(), restore)
+	}
+	if err != nil {
+		return err
+	}
+	if restore.Status.Phase == dbv1alpha1.RestoreCompleted {
+		return r.populated(instance)
+	}
+	return nil
+}
+
+// populate fills a newly created database from its source. Backups are
+// restored, and databases cloned by a driver job, which uses the driver's
+// own fast path if it has one
+func (r *ReconcileDatabase) populate(instance *dbv1alpha1.Database) (reconcile.Result, error) {
+	if err := r.checkSource(instance); err != nil {
+		return reconcile.Result{}, err
+	}
+	if instance.Spec.Source.Backup != "" {
+		return reconcile.Result{}, r.populateFromBackup(instance)
+	}
+	job, err := r.getJob(instance, util.CloneOperation)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	switch {
+	case job == nil:
+		return reconcile.Result{}, r.launchJob(instance, util.CloneOperation)
+	case util.JobSucceeded(job):
+		// The driver records this itself, unless it completed without
+		// doing so
+		return reconcile.Result{}, r.populated(instance)
+	case util.JobFailed(job):
+		log.Info("Driver job failed", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		r.recorder.Eventf(instance, corev1.EventTypeWarning, util.JobFailedReason, "Job %s failed", job.Name)
+	}
+	return reconcile.Result{}, nil
+}
+
+// stopPopulating deletes the restore or clone job populating the database,
+// as it is being deleted
+func (r *ReconcileDatabase) stopPopulating(instance *dbv1alpha1.Database) error {
+	var populating runtime.Object
+	if instance.Spec.Source != nil && instance.Spec.Source.Backup != "" {
+		populating = &dbv1alpha1.Restore{ObjectMeta: metav1.ObjectMeta{
+			Namespace: instance.Namespace,
+			Name:      sourceRestoreName(instance),
+		}}
+	} else {
+		job, err := r.getJob(instance, util.CloneOperation)
+		if err != nil || job == nil {
+			return err
+		}
+		populating = job
+	}
+	log.Info("Deleted while populating, stopping", "Database.Namespace", instance.Namespace, "Database.Name", instance.Name)
+	err := r.client.Delete(context.TODO(), populating, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func populatingDatabase(source *dbv1alpha1.DatabaseSource) *dbv1alpha1.Database {
+	db := testDatabase()
+	db.Spec.Source = source
+	db.Status.Phase = dbv1alpha1.Populating
+	return db
+}
+
+func reconcileDatabase(t *testing.T, r *ReconcileDatabase, db *dbv1alpha1.Database) (*dbv1alpha1.Database, error) {
+	_, err := r.Reconcile(reconcile.Request{NamespacedName: nameOf(db)})
+	found := &dbv1alpha1.Database{}
+	if err := r.client.Get(context.TODO(), nameOf(db), found); err != nil {
+		t.Fatalf("Unable to get database: %s", err)
+	}
+	return found, err
+}
+
+func TestPopulateFromBackup(t *testing.T) {
+	db := populatingDatabase(&dbv1alpha1.DatabaseSource{Backup: "testbackup"})
+	backup := &dbv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{Name: "testbackup", Namespace: "testns"},
+		Status:     dbv1alpha1.BackupStatus{Phase: dbv1alpha1.Completed},
+	}
+	r := fakeReconciler([]runtime.Object{db, backup, testProvider()})
+	if _, err := reconcileDatabase(t, r, db); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	restore := &dbv1alpha1.Restore{}
+	key := types.NamespacedName{Namespace: "testns", Name: "testdb-source"}
+	if err := r.client.Get(context.TODO(), key, restore); err != nil {
+		t.Fatalf("Source restore not created: %s", err)
+	}
+	if restore.Spec.Backup != "testbackup" || restore.Spec.Database != "testdb" {
+		t.Errorf("Source restore incorrect: %v", restore.Spec)
+	}
+	restore.Status.Phase = dbv1alpha1.RestoreCompleted
+	r.client.Status().Update(context.TODO(), restore)
+
+	found, err := reconcileDatabase(t, r, db)
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if found.Status.Phase != dbv1alpha1.Created || found.Status.PopulatedFrom != "backup/testbackup" {
+		t.Errorf("Database not populated: %s %q", found.Status.Phase, found.Status.PopulatedFrom)
+	}
+}
+
+func TestPopulateFromDatabase(t *testing.T) {
+	db := populatingDatabase(&dbv1alpha1.DatabaseSource{
+		Database: &dbv1alpha1.DatabaseRef{Name: "sourcedb", Namespace: "otherns"},
+	})
+	source := testDatabase()
+	source.Name = "sourcedb"
+	source.Namespace = "otherns"
+	source.Annotations = map[string]string{dbv1alpha1.AllowNamespacesAnnotation: "testns"}
+	source.Status.Phase = dbv1alpha1.Created
+	r := fakeReconciler([]runtime.Object{db, source, testProvider()})
+	if _, err := reconcileDatabase(t, r, db); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	job, err := r.getJob(db, util.CloneOperation)
+	if err != nil || job == nil {
+		t.Fatalf("No clone job launched")
+	}
+	job.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+	}
+	r.client.Update(context.TODO(), job)
+
+	found, err := reconcileDatabase(t, r, db)
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	if found.Status.Phase != dbv1alpha1.Created || found.Status.PopulatedFrom != "database/otherns/sourcedb" {
+		t.Errorf("Database not populated: %s %q", found.Status.Phase, found.Status.PopulatedFrom)
+	}
+}
+
+func TestPopulateSourceNotAllowed(t *testing.T) {
+	cases := []func(*dbv1alpha1.Database){
+		func(db *dbv1alpha1.Database) { db.Annotations = nil },
+		func(db *dbv1alpha1.Database) { db.Spec.Provider = "mysql" },
+		func(db *dbv1alpha1.Database) { db.Status.Phase = dbv1alpha1.Creating },
+	}
+	for _, change := range cases {
+		db := populatingDatabase(&dbv1alpha1.DatabaseSource{
+			Database: &dbv1alpha1.DatabaseRef{Name: "sourcedb", Namespace: "otherns"},
+		})
+		source := testDatabase()
+		source.Name = "sourcedb"
+		source.Namespace = "otherns"
+		source.Annotations = map[string]string{dbv1alpha1.AllowNamespacesAnnotation: "*"}
+		source.Status.Phase = dbv1alpha1.Created
+		change(source)
+		r := fakeReconciler([]runtime.Object{db, source, testProvider()})
+		if _, err := reconcileDatabase(t, r, db); err == nil {
+			t.Errorf("Expected error populating from %v", source)
+		}
+		if job, _ := r.getJob(db, util.CloneOperation); job != nil {
+			t.Errorf("Clone job launched for a source that cannot be cloned")
+		}
+	}
+}
+
+func TestPopulateFromDatabaseOnInstance(t *testing.T) {
+	for _, allow := range []string{"otherns,testns", "otherns"} {
+		db := populatingDatabase(&dbv1alpha1.DatabaseSource{
+			Database: &dbv1alpha1.DatabaseRef{Name: "sourcedb", Namespace: "otherns"},
+		})
+		source := testDatabase()
+		source.Name = "sourcedb"
+		source.Namespace = "otherns"
+		source.Annotations = map[string]string{dbv1alpha1.AllowNamespacesAnnotation: "testns"}
+		source.Spec.Provider = ""
+		source.Spec.Instance = &dbv1alpha1.InstanceRef{Name: "testinstance", Namespace: "dbas"}
+		source.Status.Phase = dbv1alpha1.Created
+		instance := &dbv1alpha1.DatabaseInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "testinstance",
+				Namespace:   "dbas",
+				Annotations: map[string]string{dbv1alpha1.AllowNamespacesAnnotation: allow},
+			},
+			Spec: dbv1alpha1.DatabaseInstanceSpec{Provider: "postgresql"},
+		}
+		// The clone is given the master credentials of the source's
+		// instance, so the provider in the database's namespace is ignored
+		tenant := testProvider()
+		tenant.Spec.Image = "tenant/image"
+		provider := testProvider()
+		provider.Namespace = "dbas"
+		r := fakeReconciler([]runtime.Object{db, source, instance, tenant, provider})
+		_, err := reconcileDatabase(t, r, db)
+		job := &batchv1.Job{}
+		launched := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "dbas", Name: "testns-testdb-clone"}, job) == nil
+		if allow == "otherns" {
+			if err == nil || launched {
+				t.Errorf("Clone job launched for a source whose instance does not allow it")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Reconcile threw unexpected error: %s", err)
+		}
+		if !launched {
+			t.Fatalf("Clone job not launched in the namespace of the source's instance")
+		}
+		if job.Spec.Template.Spec.Containers[0].Image != "isotoma/db-operator-postgresql" {
+			t.Errorf("Clone job uses the provider in the database's namespace")
+		}
+		if job.Labels[util.OwnerNamespaceLabel] != "testns" || job.Labels[util.OwnerNameLabel] != "testdb" {
+			t.Errorf("Clone job is not labelled with the database, got %v", job.Labels)
+		}
+		if found, err := r.getJob(db, util.CloneOperation); err != nil || found == nil {
+			t.Errorf("Clone job not found for the database: %v", err)
+		}
+	}
+}
+
+func TestCreatedPhase(t *testing.T) {
+	db := testDatabase()
+	if createdPhase(db) != dbv1alpha1.Created {
+		t.Errorf("Database without a source not Created")
+	}
+	db.Spec.Source = &dbv1alpha1.DatabaseSource{Backup: "testbackup"}
+	if createdPhase(db) != dbv1alpha1.Populating {
+		t.Errorf("Database with a source not Populating")
+	}
+	db.Status.PopulatedFrom = "backup/testbackup"
+	if createdPhase(db) != dbv1alpha1.Created {
+		t.Errorf("Populated database not Created")
+	}
+}
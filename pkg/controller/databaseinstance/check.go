@@ -0,0 +1,78 @@
+package databaseinstance
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// checkJobName returns the name of the job that checks the instance
+func checkJobName(instance *dbv1alpha1.DatabaseInstance) string {
+	return instance.Name + "-" + string(util.CheckOperation)
+}
+
+// launchCheck creates a job running the driver to reach the server of the
+// instance
+func (r *ReconcileDatabaseInstance) launchCheck(instance *dbv1alpha1.DatabaseInstance, provider *dbv1alpha1.Provider) error {
+	job := util.DriverJob(provider, checkJobName(instance), instance.Namespace, util.CheckOperation, "",
+		corev1.EnvVar{Name: "DB_OPERATOR_INSTANCE", Value: instance.Name})
+	job.Annotations = map[string]string{util.GenerationAnnotation: strconv.FormatInt(instance.Generation, 10)}
+	if err := controllerutil.SetControllerReference(instance, job, r.scheme); err != nil {
+		return err
+	}
+	log.Info("Creating check job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+	return r.client.Create(context.TODO(), job)
+}
+
+// reconcileCheck keeps a check job running for the current generation of
+// the instance, replacing it every checkInterval. It returns when to
+// requeue the instance, and a Degraded condition if the instance could not
+// be checked
+func (r *ReconcileDatabaseInstance) reconcileCheck(instance *dbv1alpha1.DatabaseInstance) (reconcile.Result, *dbv1alpha1.Condition, error) {
+	job := &batchv1.Job{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: checkJobName(instance)}, job)
+	if errors.IsNotFound(err) {
+		provider, err := util.FindProvider(r.client, instance.Namespace, instance.Spec.Provider)
+		if err != nil {
+			return reconcile.Result{}, nil, err
+		}
+		if provider == nil {
+			c := util.Degraded(instance.Generation, util.ProviderMissingReason,
+				fmt.Errorf("No provider %q found in namespace %s", instance.Spec.Provider, instance.Namespace))
+			return reconcile.Result{}, &c, nil
+		}
+		return reconcile.Result{}, nil, r.launchCheck(instance, provider)
+	}
+	if err != nil {
+		return reconcile.Result{}, nil, err
+	}
+	finished := util.JobFinishedAt(job)
+	switch {
+	case job.Annotations[util.GenerationAnnotation] != strconv.FormatInt(instance.Generation, 10) ||
+		finished != nil && time.Since(finished.Time) >= checkInterval:
+		// The job is replaced once it has gone
+		log.Info("Deleting outdated check job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		err = r.client.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		return reconcile.Result{}, nil, err
+	case finished == nil:
+		return reconcile.Result{}, nil, nil
+	}
+	result := reconcile.Result{RequeueAfter: checkInterval - time.Since(finished.Time)}
+	if util.JobFailed(job) {
+		c := util.Degraded(instance.Generation, util.JobFailedReason, fmt.Errorf("Job %s failed", job.Name))
+		return result, &c, nil
+	}
+	return result, nil, nil
+}
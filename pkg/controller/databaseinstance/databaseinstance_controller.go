@@ -0,0 +1,184 @@
+package databaseinstance
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_databaseinstance")
+
+// checkInterval is how often the server of an instance is checked
+const checkInterval = 10 * time.Minute
+
+// Add creates a new DatabaseInstance Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileDatabaseInstance{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller
+	c, err := controller.New("databaseinstance-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource DatabaseInstance
+	err = c.Watch(&source.Kind{Type: &dbv1alpha1.DatabaseInstance{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the check Jobs and requeue the owner
+	// DatabaseInstance
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &dbv1alpha1.DatabaseInstance{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the driver Jobs run in the namespace of the
+	// instance for databases on it, to remove them once they are orphaned
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			name, ok := o.Meta.GetLabels()[util.InstanceLabel]
+			if !ok {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{
+				Namespace: o.Meta.GetNamespace(),
+				Name:      name,
+			}}}
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to Databases, to keep count of those on each
+	// instance
+	err = c.Watch(&source.Kind{Type: &dbv1alpha1.Database{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			db, ok := o.Object.(*dbv1alpha1.Database)
+			if !ok || db.Spec.Instance == nil {
+				return nil
+			}
+			namespace := db.Spec.Instance.Namespace
+			if namespace == "" {
+				namespace = db.Namespace
+			}
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{
+				Namespace: namespace,
+				Name:      db.Spec.Instance.Name,
+			}}}
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileDatabaseInstance{}
+
+// ReconcileDatabaseInstance reconciles a DatabaseInstance object
+type ReconcileDatabaseInstance struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client client.Client
+	scheme *runtime.Scheme
+}
+
+// countDatabases returns the number of databases on the instance
+func (r *ReconcileDatabaseInstance) countDatabases(instance *dbv1alpha1.DatabaseInstance) (int, error) {
+	databases := &dbv1alpha1.DatabaseList{}
+	if err := r.client.List(context.TODO(), &client.ListOptions{}, databases); err != nil {
+		return 0, err
+	}
+	return len(util.OnInstance(databases.Items, instance.Namespace, instance.Name)), nil
+}
+
+// Reconcile reads that state of the cluster for a DatabaseInstance object and makes changes based on the state read
+// and what is in the DatabaseInstance.Spec
+// Note:
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcileDatabaseInstance) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Reconciling DatabaseInstance")
+
+	// Fetch the DatabaseInstance instance
+	instance := &dbv1alpha1.DatabaseInstance{}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, instance); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	if err := r.removeOrphanedJobs(instance); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	count, err := r.countDatabases(instance)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	changed := instance.Status.Databases != count
+	instance.Status.Databases = count
+
+	var result reconcile.Result
+	degraded := util.Degraded(instance.Generation, "", nil)
+	if instance.Spec.Provider == "" {
+		degraded = util.Degraded(instance.Generation, "InvalidSpec", fmt.Errorf("No provider given"))
+	} else {
+		var problem *dbv1alpha1.Condition
+		if result, problem, err = r.reconcileCheck(instance); err != nil {
+			return reconcile.Result{}, err
+		}
+		if problem != nil {
+			degraded = *problem
+		}
+	}
+	if degraded.Status == dbv1alpha1.ConditionTrue {
+		// The driver reports whether the server was reached, so Ready is
+		// only set here when it could not be checked at all
+		changed = util.SetCondition(&instance.Status.Conditions, dbv1alpha1.Condition{
+			Type:               dbv1alpha1.ConditionReady,
+			Status:             dbv1alpha1.ConditionFalse,
+			ObservedGeneration: instance.Generation,
+			Reason:             degraded.Reason,
+			Message:            degraded.Message,
+		}) || changed
+	}
+	changed = util.SetCondition(&instance.Status.Conditions, degraded) || changed
+	if !changed {
+		return result, nil
+	}
+	reqLogger.Info("Updating instance status", "Databases", count, "Degraded", degraded.Status)
+	return result, r.client.Status().Update(context.TODO(), instance)
+}
@@ -0,0 +1,189 @@
+package databaseinstance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func fakeReconciler(objs []runtime.Object) *ReconcileDatabaseInstance {
+	s := scheme.Scheme
+	s.AddKnownTypes(dbv1alpha1.SchemeGroupVersion,
+		&dbv1alpha1.DatabaseInstance{}, &dbv1alpha1.Database{}, &dbv1alpha1.DatabaseList{},
+		&dbv1alpha1.Provider{}, &dbv1alpha1.ProviderList{})
+	cl := fake.NewFakeClient(objs...)
+	return &ReconcileDatabaseInstance{client: cl, scheme: s}
+}
+
+func testInstance() *dbv1alpha1.DatabaseInstance {
+	return &dbv1alpha1.DatabaseInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: "testinstance", Namespace: "dbas"},
+		Spec: dbv1alpha1.DatabaseInstanceSpec{
+			Provider: "postgresql",
+			Connect:  map[string]string{"host": "db.example.com"},
+		},
+	}
+}
+
+func testProvider() *dbv1alpha1.Provider {
+	return &dbv1alpha1.Provider{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgresql-provider", Namespace: "dbas"},
+		Spec:       dbv1alpha1.ProviderSpec{Name: "postgresql", Image: "isotoma/db-operator-postgresql"},
+	}
+}
+
+func testDatabase(namespace, name string, ref *dbv1alpha1.InstanceRef) *dbv1alpha1.Database {
+	return &dbv1alpha1.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       dbv1alpha1.DatabaseSpec{Name: name, Instance: ref},
+	}
+}
+
+func reconcileInstance(t *testing.T, r *ReconcileDatabaseInstance) (*dbv1alpha1.DatabaseInstance, reconcile.Result) {
+	key := types.NamespacedName{Namespace: "dbas", Name: "testinstance"}
+	result, err := r.Reconcile(reconcile.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	instance := &dbv1alpha1.DatabaseInstance{}
+	if err := r.client.Get(context.TODO(), key, instance); err != nil {
+		t.Fatalf("Unable to get instance: %s", err)
+	}
+	return instance, result
+}
+
+func checkJob(t *testing.T, r *ReconcileDatabaseInstance) *batchv1.Job {
+	job := &batchv1.Job{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "dbas", Name: "testinstance-check"}, job); err != nil {
+		t.Fatalf("Unable to get check job: %s", err)
+	}
+	return job
+}
+
+func finishJob(t *testing.T, r *ReconcileDatabaseInstance, job *batchv1.Job, condition batchv1.JobConditionType, at time.Time) {
+	job.Status.Conditions = []batchv1.JobCondition{{
+		Type:               condition,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: metav1.NewTime(at),
+	}}
+	if err := r.client.Status().Update(context.TODO(), job); err != nil {
+		t.Fatalf("Unable to update job: %s", err)
+	}
+}
+
+func TestReconcileCountsDatabases(t *testing.T) {
+	r := fakeReconciler([]runtime.Object{
+		testInstance(),
+		testProvider(),
+		testDatabase("dbas", "local", &dbv1alpha1.InstanceRef{Name: "testinstance"}),
+		testDatabase("app", "remote", &dbv1alpha1.InstanceRef{Name: "testinstance", Namespace: "dbas"}),
+		testDatabase("app", "elsewhere", &dbv1alpha1.InstanceRef{Name: "testinstance"}),
+	})
+	instance, _ := reconcileInstance(t, r)
+	if instance.Status.Databases != 2 {
+		t.Errorf("Expected 2 databases, got %d", instance.Status.Databases)
+	}
+	job := checkJob(t, r)
+	env := job.Spec.Template.Spec.Containers[0].Env
+	found := false
+	for _, e := range env {
+		found = found || (e.Name == "DB_OPERATOR_INSTANCE" && e.Value == "testinstance")
+	}
+	if !found {
+		t.Errorf("Check job is not given the instance: %v", env)
+	}
+}
+
+func TestReconcileProviderMissing(t *testing.T) {
+	r := fakeReconciler([]runtime.Object{testInstance()})
+	instance, _ := reconcileInstance(t, r)
+	ready := util.FindCondition(instance.Status.Conditions, dbv1alpha1.ConditionReady)
+	degraded := util.FindCondition(instance.Status.Conditions, dbv1alpha1.ConditionDegraded)
+	if ready == nil || ready.Status != dbv1alpha1.ConditionFalse || ready.Reason != util.ProviderMissingReason {
+		t.Errorf("Expected Ready False ProviderMissing, got %v", ready)
+	}
+	if degraded == nil || degraded.Status != dbv1alpha1.ConditionTrue {
+		t.Errorf("Expected Degraded, got %v", degraded)
+	}
+}
+
+func TestReconcileCheckFailed(t *testing.T) {
+	r := fakeReconciler([]runtime.Object{testInstance(), testProvider()})
+	reconcileInstance(t, r)
+	finishJob(t, r, checkJob(t, r), batchv1.JobFailed, time.Now())
+	instance, result := reconcileInstance(t, r)
+	degraded := util.FindCondition(instance.Status.Conditions, dbv1alpha1.ConditionDegraded)
+	if degraded == nil || degraded.Status != dbv1alpha1.ConditionTrue || degraded.Reason != util.JobFailedReason {
+		t.Errorf("Expected Degraded JobFailed, got %v", degraded)
+	}
+	if result.RequeueAfter <= 0 || result.RequeueAfter > checkInterval {
+		t.Errorf("Expected a requeue for the next check, got %v", result.RequeueAfter)
+	}
+}
+
+func TestReconcileCheckReplaced(t *testing.T) {
+	r := fakeReconciler([]runtime.Object{testInstance(), testProvider()})
+	reconcileInstance(t, r)
+	finishJob(t, r, checkJob(t, r), batchv1.JobComplete, time.Now().Add(-checkInterval))
+	reconcileInstance(t, r)
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "dbas", Name: "testinstance-check"}, &batchv1.Job{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("Expected the old check job to be deleted, got %v", err)
+	}
+	// The next reconcile, prompted by the deletion, checks again
+	reconcileInstance(t, r)
+	checkJob(t, r)
+}
+
+func TestRemoveOrphanedJobs(t *testing.T) {
+	ref := &dbv1alpha1.InstanceRef{Name: "testinstance", Namespace: "dbas"}
+	driverJob := func(name, owner string, finished bool) *batchv1.Job {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "dbas",
+				Labels: map[string]string{
+					util.InstanceLabel:       "testinstance",
+					util.OwnerKindLabel:      "Database",
+					util.OwnerNamespaceLabel: "app",
+					util.OwnerNameLabel:      owner,
+				},
+			},
+		}
+		if finished {
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		}
+		return job
+	}
+	instance := testInstance()
+	r := fakeReconciler([]runtime.Object{
+		instance, testDatabase("app", "mydb", ref),
+		driverJob("app-mydb-create", "mydb", true),
+		driverJob("app-olddb-drop", "olddb", true),
+		driverJob("app-olddb-backup", "olddb", false),
+	})
+	if err := r.removeOrphanedJobs(instance); err != nil {
+		t.Fatalf("removeOrphanedJobs threw unexpected error: %s", err)
+	}
+	for name, kept := range map[string]bool{"app-mydb-create": true, "app-olddb-drop": false, "app-olddb-backup": true} {
+		err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "dbas", Name: name}, &batchv1.Job{})
+		if kept && err != nil {
+			t.Errorf("Job %s was removed: %s", name, err)
+		}
+		if !kept && !errors.IsNotFound(err) {
+			t.Errorf("Orphaned job %s was not removed", name)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package databaseinstance
+
+import (
+	"context"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ownerTypes are the kinds of resource that driver jobs are run in the
+// namespace of an instance for
+var ownerTypes = map[string]func() runtime.Object{
+	"Database": func() runtime.Object { return &dbv1alpha1.Database{} },
+	"Backup":   func() runtime.Object { return &dbv1alpha1.Backup{} },
+	"Restore":  func() runtime.Object { return &dbv1alpha1.Restore{} },
+}
+
+// ownerExists returns true if the resource a driver job was launched for
+// still exists
+func (r *ReconcileDatabaseInstance) ownerExists(job *batchv1.Job) (bool, error) {
+	newObject, ok := ownerTypes[job.Labels[util.OwnerKindLabel]]
+	if !ok {
+		return true, nil
+	}
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Namespace: job.Labels[util.OwnerNamespaceLabel],
+		Name:      job.Labels[util.OwnerNameLabel],
+	}, newObject())
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// removeOrphanedJobs deletes the finished driver jobs run in the namespace
+// of the instance for resources that no longer exist. They cannot be owned
+// by those resources, which are in other namespaces, so are not garbage
+// collected with them
+func (r *ReconcileDatabaseInstance) removeOrphanedJobs(instance *dbv1alpha1.DatabaseInstance) error {
+	jobs := &batchv1.JobList{}
+	if err := r.client.List(context.TODO(), &client.ListOptions{Namespace: instance.Namespace}, jobs); err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Labels[util.InstanceLabel] != instance.Name || util.JobFinishedAt(job) == nil {
+			continue
+		}
+		exists, err := r.ownerExists(job)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		log.Info("Deleting orphaned driver job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		err = r.client.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package driverjob
+
+import (
+	"context"
+
+	"github.com/isotoma/db-operator/pkg/metrics"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_driverjob")
+
+// ObservedAnnotation is set on driver jobs once they have finished and been
+// recorded in the metrics, so that they are only recorded once
+const ObservedAnnotation = "db.isotoma.com/metrics-observed"
+
+// Add creates a new driver Job Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileDriverJob{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller
+	c, err := controller.New("driverjob-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to Jobs. Those that are not driver jobs are ignored
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileDriverJob{}
+
+// ReconcileDriverJob records driver jobs in the metrics as they finish
+type ReconcileDriverJob struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client client.Client
+	scheme *runtime.Scheme
+}
+
+// Reconcile records the duration of a driver job that has succeeded, or
+// counts one that has failed, then marks it as observed
+func (r *ReconcileDriverJob) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	job := &batchv1.Job{}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, job); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if _, ok := job.Labels["operation"]; !ok {
+		return reconcile.Result{}, nil
+	}
+	if _, ok := job.Annotations[ObservedAnnotation]; ok {
+		return reconcile.Result{}, nil
+	}
+	succeeded := util.JobSucceeded(job)
+	if !succeeded && !util.JobFailed(job) {
+		return reconcile.Result{}, nil
+	}
+
+	log.Info("Observing driver job", "Job.Namespace", job.Namespace, "Job.Name", job.Name, "Succeeded", succeeded)
+	if job.Annotations == nil {
+		job.Annotations = map[string]string{}
+	}
+	job.Annotations[ObservedAnnotation] = "true"
+	if err := r.client.Update(context.TODO(), job); err != nil {
+		return reconcile.Result{}, err
+	}
+	metrics.ObserveJob(job, succeeded)
+	return reconcile.Result{}, nil
+}
@@ -0,0 +1,53 @@
+package driverjob
+
+import (
+	"context"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func reconcileJob(t *testing.T, job *batchv1.Job) *batchv1.Job {
+	r := &ReconcileDriverJob{client: fake.NewFakeClient(job), scheme: scheme.Scheme}
+	key := types.NamespacedName{Namespace: job.Namespace, Name: job.Name}
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	found := &batchv1.Job{}
+	if err := r.client.Get(context.TODO(), key, found); err != nil {
+		t.Fatalf("Unable to get job: %s", err)
+	}
+	return found
+}
+
+func TestReconcileObservesFinishedJobs(t *testing.T) {
+	cases := []struct {
+		labels    map[string]string
+		condition batchv1.JobConditionType
+		observed  bool
+	}{
+		{map[string]string{"operation": "create", "provider": "postgresql"}, batchv1.JobComplete, true},
+		{map[string]string{"operation": "create", "provider": "postgresql"}, batchv1.JobFailed, true},
+		// Jobs still running, and jobs that are not driver jobs, are left
+		{map[string]string{"operation": "create", "provider": "postgresql"}, "", false},
+		{map[string]string{"app": "other"}, batchv1.JobComplete, false},
+	}
+	for _, c := range cases {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "testdb-create", Namespace: "testns", Labels: c.labels},
+		}
+		if c.condition != "" {
+			job.Status.Conditions = []batchv1.JobCondition{{Type: c.condition, Status: corev1.ConditionTrue}}
+		}
+		found := reconcileJob(t, job)
+		if _, ok := found.Annotations[ObservedAnnotation]; ok != c.observed {
+			t.Errorf("Expected observed %v for %v %s", c.observed, c.labels, c.condition)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// probeJobName returns the name of the job that probes the provider
+func probeJobName(instance *dbv1alpha1.Provider) string {
+	return instance.Name + "-" + string(util.ProbeOperation)
+}
+
+// launchProbe creates a job running the image of the provider to report the
+// drivers it registers
+func (r *ReconcileProvider) launchProbe(instance *dbv1alpha1.Provider) error {
+	job := util.DriverJob(instance, probeJobName(instance), instance.Namespace, util.ProbeOperation, "",
+		corev1.EnvVar{Name: "DB_OPERATOR_PROVIDER", Value: instance.Name})
+	job.Annotations = map[string]string{util.GenerationAnnotation: strconv.FormatInt(instance.Generation, 10)}
+	if err := controllerutil.SetControllerReference(instance, job, r.scheme); err != nil {
+		return err
+	}
+	log.Info("Creating probe job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+	return r.client.Create(context.TODO(), job)
+}
+
+// reconcileProbe probes the current generation of the provider, unless the
+// driver has already reported its drivers, and returns the Probed condition
+func (r *ReconcileProvider) reconcileProbe(instance *dbv1alpha1.Provider) (dbv1alpha1.Condition, error) {
+	if instance.Status.ProbedGeneration == instance.Generation {
+		existing := util.FindCondition(instance.Status.Conditions, dbv1alpha1.ProviderProbed)
+		if existing != nil && existing.Status == dbv1alpha1.ConditionTrue {
+			return *existing, nil
+		}
+	}
+	probed := dbv1alpha1.Condition{
+		Type:               dbv1alpha1.ProviderProbed,
+		Status:             dbv1alpha1.ConditionUnknown,
+		ObservedGeneration: instance.Generation,
+		Reason:             "Probing",
+		Message:            fmt.Sprintf("Waiting for probe job %s", probeJobName(instance)),
+	}
+	job := &batchv1.Job{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: probeJobName(instance)}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return probed, err
+	}
+	switch {
+	case errors.IsNotFound(err):
+		err = r.launchProbe(instance)
+	case job.Annotations[util.GenerationAnnotation] != strconv.FormatInt(instance.Generation, 10):
+		// The provider has changed since, so the job is replaced once it
+		// has gone
+		log.Info("Deleting outdated probe job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		err = r.client.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	case util.JobFailed(job):
+		probed.Status = dbv1alpha1.ConditionFalse
+		probed.Reason = "ProbeFailed"
+		probed.Message = fmt.Sprintf("Probe job %s failed", job.Name)
+	case util.JobSucceeded(job):
+		probed.Status = dbv1alpha1.ConditionFalse
+		probed.Reason = "ProbeFailed"
+		probed.Message = fmt.Sprintf("Probe job %s did not report any drivers", job.Name)
+	}
+	return probed, err
+}
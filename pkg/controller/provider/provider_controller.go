@@ -0,0 +1,193 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_provider")
+
+// imagePattern matches image references, with an optional registry, tag
+// and digest, such as registry.example.com:5000/isotoma/db-operator:1.0.
+// As with docker, a registry is told apart from the first part of the path
+// by a dot or port, or by being localhost
+var imagePattern = regexp.MustCompile(`^((localhost|[a-zA-Z0-9-]+(\.[a-zA-Z0-9-]+)+)(:[0-9]+)?/|[a-zA-Z0-9-]+:[0-9]+/)?[a-z0-9]+([._-][a-z0-9]+)*(/[a-z0-9]+([._-][a-z0-9]+)*)*(:[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?(@sha256:[a-f0-9]{64})?$`)
+
+// Add creates a new Provider Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileProvider{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller
+	c, err := controller.New("provider-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource Provider. Every provider in the
+	// namespace is requeued, as they must not provide the same driver
+	cl := mgr.GetClient()
+	err = c.Watch(&source.Kind{Type: &dbv1alpha1.Provider{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			requests := []reconcile.Request{{NamespacedName: types.NamespacedName{
+				Namespace: o.Meta.GetNamespace(),
+				Name:      o.Meta.GetName(),
+			}}}
+			providers := &dbv1alpha1.ProviderList{}
+			if err := cl.List(context.TODO(), &client.ListOptions{Namespace: o.Meta.GetNamespace()}, providers); err != nil {
+				return requests
+			}
+			for _, p := range providers.Items {
+				if p.Name != o.Meta.GetName() {
+					requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+						Namespace: p.Namespace,
+						Name:      p.Name,
+					}})
+				}
+			}
+			return requests
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the probe Jobs and requeue the owner Provider
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &dbv1alpha1.Provider{},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileProvider{}
+
+// ReconcileProvider reconciles a Provider object
+type ReconcileProvider struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client client.Client
+	scheme *runtime.Scheme
+}
+
+// validate returns the reason and an error if the provider cannot be used
+func (r *ReconcileProvider) validate(instance *dbv1alpha1.Provider) (string, error) {
+	if instance.Spec.Name == "" {
+		return "InvalidSpec", fmt.Errorf("No driver name given")
+	}
+	if instance.Spec.Image == "" {
+		return "InvalidSpec", fmt.Errorf("No driver image given")
+	}
+	if !imagePattern.MatchString(instance.Spec.Image) {
+		return "InvalidSpec", fmt.Errorf("Image %q is not a valid image reference", instance.Spec.Image)
+	}
+	// Databases name the driver, so it must pick out one provider
+	providers := &dbv1alpha1.ProviderList{}
+	if err := r.client.List(context.TODO(), &client.ListOptions{Namespace: instance.Namespace}, providers); err != nil {
+		return "", err
+	}
+	for _, p := range providers.Items {
+		if p.Name != instance.Name && p.Spec.Name == instance.Spec.Name {
+			return "DuplicateName", fmt.Errorf("Provider %s also provides driver %s", p.Name, p.Spec.Name)
+		}
+	}
+	return "", nil
+}
+
+// Reconcile reads that state of the cluster for a Provider object and makes changes based on the state read
+// and what is in the Provider.Spec
+// Note:
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcileProvider) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Reconciling Provider")
+
+	// Fetch the Provider instance
+	instance := &dbv1alpha1.Provider{}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, instance); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	reason, invalid := r.validate(instance)
+	if invalid != nil && reason == "" {
+		return reconcile.Result{}, invalid
+	}
+	ready := dbv1alpha1.Condition{
+		Type:               dbv1alpha1.ConditionReady,
+		Status:             dbv1alpha1.ConditionTrue,
+		ObservedGeneration: instance.Generation,
+		Reason:             "Valid",
+		Message:            fmt.Sprintf("Provides driver %s", instance.Spec.Name),
+	}
+	var changed bool
+	switch {
+	case invalid == nil && instance.Spec.Probe:
+		probed, err := r.reconcileProbe(instance)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		changed = util.SetCondition(&instance.Status.Conditions, probed)
+		switch {
+		case probed.Status == dbv1alpha1.ConditionFalse:
+			reason, invalid = probed.Reason, fmt.Errorf("%s", probed.Message)
+		case probed.Status == dbv1alpha1.ConditionUnknown:
+			ready.Status = dbv1alpha1.ConditionUnknown
+			ready.Reason = probed.Reason
+			ready.Message = probed.Message
+		case instance.Status.Driver(instance.Spec.Name) == nil:
+			reason = "DriverNotRegistered"
+			invalid = fmt.Errorf("Image %s does not register driver %s", instance.Spec.Image, instance.Spec.Name)
+		}
+	case !instance.Spec.Probe:
+		// Anything probed before is out of date
+		changed = util.RemoveCondition(&instance.Status.Conditions, dbv1alpha1.ProviderProbed)
+		if instance.Status.Drivers != nil || instance.Status.ProbedGeneration != 0 {
+			instance.Status.Drivers = nil
+			instance.Status.ProbedGeneration = 0
+			changed = true
+		}
+	}
+	if invalid != nil {
+		ready.Status = dbv1alpha1.ConditionFalse
+		ready.Reason = reason
+		ready.Message = invalid.Error()
+	}
+	changed = util.SetCondition(&instance.Status.Conditions, ready) || changed
+	changed = util.SetCondition(&instance.Status.Conditions, util.Degraded(instance.Generation, reason, invalid)) || changed
+	if !changed {
+		return reconcile.Result{}, nil
+	}
+	reqLogger.Info("Updating provider conditions", "Ready", ready.Status, "Reason", ready.Reason)
+	return reconcile.Result{}, r.client.Status().Update(context.TODO(), instance)
+}
@@ -0,0 +1,186 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func fakeReconciler(objs []runtime.Object) *ReconcileProvider {
+	s := scheme.Scheme
+	s.AddKnownTypes(dbv1alpha1.SchemeGroupVersion, &dbv1alpha1.Provider{}, &dbv1alpha1.ProviderList{})
+	cl := fake.NewFakeClient(objs...)
+	return &ReconcileProvider{client: cl, scheme: s}
+}
+
+func testProvider(name, driver, image string) *dbv1alpha1.Provider {
+	return &dbv1alpha1.Provider{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "testns"},
+		Spec:       dbv1alpha1.ProviderSpec{Name: driver, Image: image},
+	}
+}
+
+func reconcileProvider(t *testing.T, r *ReconcileProvider, name string) *dbv1alpha1.Provider {
+	key := types.NamespacedName{Namespace: "testns", Name: name}
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	provider := &dbv1alpha1.Provider{}
+	if err := r.client.Get(context.TODO(), key, provider); err != nil {
+		t.Fatalf("Unable to get provider: %s", err)
+	}
+	return provider
+}
+
+func TestReconcileConditions(t *testing.T) {
+	cases := []struct {
+		objs   []runtime.Object
+		ready  dbv1alpha1.ConditionStatus
+		reason string
+	}{
+		{[]runtime.Object{testProvider("pg", "postgresql", "isotoma/db-operator-postgresql")}, dbv1alpha1.ConditionTrue, "Valid"},
+		{[]runtime.Object{testProvider("pg", "postgresql", "")}, dbv1alpha1.ConditionFalse, "InvalidSpec"},
+		{[]runtime.Object{testProvider("pg", "", "isotoma/db-operator-postgresql")}, dbv1alpha1.ConditionFalse, "InvalidSpec"},
+		{[]runtime.Object{testProvider("pg", "postgresql", "Isotoma/DB operator")}, dbv1alpha1.ConditionFalse, "InvalidSpec"},
+		{[]runtime.Object{
+			testProvider("pg", "postgresql", "isotoma/db-operator-postgresql"),
+			testProvider("pg2", "postgresql", "isotoma/db-operator-postgresql"),
+		}, dbv1alpha1.ConditionFalse, "DuplicateName"},
+	}
+	for _, c := range cases {
+		provider := reconcileProvider(t, fakeReconciler(c.objs), "pg")
+		ready := util.FindCondition(provider.Status.Conditions, dbv1alpha1.ConditionReady)
+		degraded := util.FindCondition(provider.Status.Conditions, dbv1alpha1.ConditionDegraded)
+		if ready == nil || degraded == nil {
+			t.Fatalf("Conditions not set: %v", provider.Status.Conditions)
+		}
+		if ready.Status != c.ready || ready.Reason != c.reason {
+			t.Errorf("Expected Ready %s %s, got %s %s", c.ready, c.reason, ready.Status, ready.Reason)
+		}
+		if (degraded.Status == dbv1alpha1.ConditionTrue) != (c.ready == dbv1alpha1.ConditionFalse) {
+			t.Errorf("Degraded %s does not match Ready %s", degraded.Status, ready.Status)
+		}
+	}
+}
+
+func TestValidImages(t *testing.T) {
+	cases := []struct {
+		image string
+		valid bool
+	}{
+		{"postgres", true},
+		{"isotoma/db-operator-postgresql:1.0", true},
+		{"registry.example.com:5000/isotoma/db-operator-postgresql", true},
+		{"localhost/db-operator", true},
+		{"isotoma/db-operator@sha256:" + strings.Repeat("a", 64), true},
+		{"Isotoma/db-operator", false},
+		{"isotoma/db-operator:", false},
+		{"isotoma db-operator", false},
+	}
+	for _, c := range cases {
+		if imagePattern.MatchString(c.image) != c.valid {
+			t.Errorf("Expected image %q to be valid: %t", c.image, c.valid)
+		}
+	}
+}
+
+func probedProvider() *dbv1alpha1.Provider {
+	provider := testProvider("pg", "postgresql", "isotoma/db-operator-postgresql")
+	provider.Spec.Probe = true
+	return provider
+}
+
+func probeJob(t *testing.T, r *ReconcileProvider) *batchv1.Job {
+	job := &batchv1.Job{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "testns", Name: "pg-probe"}, job); err != nil {
+		t.Fatalf("Unable to get probe job: %s", err)
+	}
+	return job
+}
+
+func TestReconcileProbe(t *testing.T) {
+	r := fakeReconciler([]runtime.Object{probedProvider()})
+	provider := reconcileProvider(t, r, "pg")
+	ready := util.FindCondition(provider.Status.Conditions, dbv1alpha1.ConditionReady)
+	if ready.Status != dbv1alpha1.ConditionUnknown || ready.Reason != "Probing" {
+		t.Errorf("Expected Ready Unknown Probing, got %s %s", ready.Status, ready.Reason)
+	}
+	job := probeJob(t, r)
+	env := job.Spec.Template.Spec.Containers[0].Env
+	found := false
+	for _, e := range env {
+		found = found || (e.Name == "DB_OPERATOR_PROVIDER" && e.Value == "pg")
+	}
+	if !found {
+		t.Errorf("Probe job is not given the provider: %v", env)
+	}
+
+	// The driver reports what the image registers
+	provider.Status.Drivers = []dbv1alpha1.DriverInfo{{Name: "postgresql"}}
+	util.SetCondition(&provider.Status.Conditions, dbv1alpha1.Condition{
+		Type:   dbv1alpha1.ProviderProbed,
+		Status: dbv1alpha1.ConditionTrue,
+		Reason: "Probed",
+	})
+	if err := r.client.Status().Update(context.TODO(), provider); err != nil {
+		t.Fatalf("Unable to update provider: %s", err)
+	}
+	provider = reconcileProvider(t, r, "pg")
+	ready = util.FindCondition(provider.Status.Conditions, dbv1alpha1.ConditionReady)
+	if ready.Status != dbv1alpha1.ConditionTrue {
+		t.Errorf("Expected Ready True, got %s %s", ready.Status, ready.Reason)
+	}
+}
+
+func TestReconcileProbeDriverNotRegistered(t *testing.T) {
+	provider := probedProvider()
+	provider.Status.Drivers = []dbv1alpha1.DriverInfo{{Name: "mysql"}}
+	provider.Status.Conditions = []dbv1alpha1.Condition{{Type: dbv1alpha1.ProviderProbed, Status: dbv1alpha1.ConditionTrue}}
+	provider = reconcileProvider(t, fakeReconciler([]runtime.Object{provider}), "pg")
+	ready := util.FindCondition(provider.Status.Conditions, dbv1alpha1.ConditionReady)
+	if ready.Status != dbv1alpha1.ConditionFalse || ready.Reason != "DriverNotRegistered" {
+		t.Errorf("Expected Ready False DriverNotRegistered, got %s %s", ready.Status, ready.Reason)
+	}
+}
+
+func TestReconcileProbeFailed(t *testing.T) {
+	r := fakeReconciler([]runtime.Object{probedProvider()})
+	reconcileProvider(t, r, "pg")
+	job := probeJob(t, r)
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	if err := r.client.Status().Update(context.TODO(), job); err != nil {
+		t.Fatalf("Unable to update job: %s", err)
+	}
+	provider := reconcileProvider(t, r, "pg")
+	ready := util.FindCondition(provider.Status.Conditions, dbv1alpha1.ConditionReady)
+	if ready.Status != dbv1alpha1.ConditionFalse || ready.Reason != "ProbeFailed" {
+		t.Errorf("Expected Ready False ProbeFailed, got %s %s", ready.Status, ready.Reason)
+	}
+}
+
+func TestReconcileProbeOutdated(t *testing.T) {
+	r := fakeReconciler([]runtime.Object{probedProvider()})
+	reconcileProvider(t, r, "pg")
+	job := probeJob(t, r)
+	job.Annotations[util.GenerationAnnotation] = "-1"
+	if err := r.client.Update(context.TODO(), job); err != nil {
+		t.Fatalf("Unable to update job: %s", err)
+	}
+	reconcileProvider(t, r, "pg")
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "testns", Name: "pg-probe"}, job)
+	if !errors.IsNotFound(err) {
+		t.Errorf("Expected the outdated probe job to be deleted, got %v", err)
+	}
+}
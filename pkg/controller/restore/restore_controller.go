@@ -0,0 +1,239 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_restore")
+
+// Add creates a new Restore Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager) error {
+	return add(mgr, newReconciler(mgr))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &ReconcileRestore{
+		client:   mgr.GetClient(),
+		scheme:   mgr.GetScheme(),
+		recorder: mgr.GetRecorder("restore-controller"),
+	}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller
+	c, err := controller.New("restore-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource Restore
+	err = c.Watch(&source.Kind{Type: &dbv1alpha1.Restore{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the driver Jobs and requeue the owner Restore
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &dbv1alpha1.Restore{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the driver Jobs run in the namespace of an
+	// instance, which the Restore cannot own
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, util.EnqueueJobOwner("Restore"))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileRestore{}
+
+// ReconcileRestore reconciles a Restore object
+type ReconcileRestore struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+}
+
+// UpdatePhase updates the phase of the restore to the one requested
+func (r *ReconcileRestore) UpdatePhase(instance *dbv1alpha1.Restore, phase dbv1alpha1.RestorePhase) error {
+	previous := instance.Status.Phase
+	instance.Status.Phase = phase
+	if err := r.client.Status().Update(context.TODO(), instance); err != nil {
+		return err
+	}
+	if previous != phase {
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, string(phase), "Restore is %s", phase)
+	}
+	return nil
+}
+
+// jobName returns the name of the job that performs the restore
+func jobName(instance *dbv1alpha1.Restore) string {
+	return instance.Name + "-" + string(util.RestoreOperation)
+}
+
+// instanceRef returns a reference to the instance the database restored
+// into is on, or nil if it is not on one or no longer exists
+func (r *ReconcileRestore) instanceRef(instance *dbv1alpha1.Restore) (*dbv1alpha1.InstanceRef, error) {
+	database := &dbv1alpha1.Database{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{
+		Namespace: instance.Namespace,
+		Name:      instance.Spec.Database,
+	}, database)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return util.InstanceOf(database), nil
+}
+
+// getJob returns the job launched to perform the restore, or nil if there
+// is no such job
+func (r *ReconcileRestore) getJob(instance *dbv1alpha1.Restore) (*batchv1.Job, error) {
+	ref, err := r.instanceRef(instance)
+	if err != nil {
+		return nil, err
+	}
+	job := &batchv1.Job{}
+	err = r.client.Get(context.TODO(), util.DriverJobKey(ref, instance.Namespace, jobName(instance)), job)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// launchJob creates a driver job to restore into the database, using the
+// provider of the database, unless one already exists
+func (r *ReconcileRestore) launchJob(instance *dbv1alpha1.Restore) error {
+	found, err := r.getJob(instance)
+	if err != nil || found != nil {
+		return err
+	}
+	backup := &dbv1alpha1.Backup{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{
+		Namespace: instance.Namespace,
+		Name:      instance.Spec.Backup,
+	}, backup)
+	if err != nil {
+		return err
+	}
+	if backup.Status.Phase != dbv1alpha1.Completed {
+		return fmt.Errorf("Backup %s has not completed", backup.Name)
+	}
+	database := &dbv1alpha1.Database{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{
+		Namespace: instance.Namespace,
+		Name:      instance.Spec.Database,
+	}, database)
+	if err != nil {
+		return err
+	}
+	if database, err = util.ResolveDatabase(r.client, database); err != nil {
+		return err
+	}
+	// The provider of a database on an instance in another namespace is
+	// taken from there, as its jobs are given the master credentials
+	ref := util.InstanceOf(database)
+	provider, err := util.GetProvider(r.client, util.JobNamespace(ref, instance.Namespace), database.Spec.Provider)
+	if err != nil {
+		r.recorder.Event(instance, corev1.EventTypeWarning, util.ProviderMissingReason, err.Error())
+		return err
+	}
+	job := util.DriverJob(provider, jobName(instance), instance.Namespace, util.RestoreOperation, database.Name,
+		corev1.EnvVar{Name: "DB_OPERATOR_RESTORE", Value: instance.Name})
+	if claim := util.BackupClaim(backup.Status.Destination); claim != "" {
+		util.MountClaim(job, claim)
+	}
+	if err := util.PlaceDriverJob(r.client, r.scheme, job, instance, "Restore", ref); err != nil {
+		return err
+	}
+	log.Info("Creating driver job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+	if err := r.client.Create(context.TODO(), job); err != nil {
+		return err
+	}
+	r.recorder.Eventf(instance, corev1.EventTypeNormal, util.JobLaunchedReason, "Launched %s job %s", util.RestoreOperation, job.Name)
+	return nil
+}
+
+// Reconcile reads that state of the cluster for a Restore object and makes changes based on the state read
+// and what is in the Restore.Spec
+// Note:
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcileRestore) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Reconciling Restore")
+
+	// Fetch the Restore instance
+	instance := &dbv1alpha1.Restore{}
+	err := r.client.Get(context.TODO(), request.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	switch instance.Status.Phase {
+	case "":
+		if err := r.launchJob(instance); err != nil {
+			return reconcile.Result{}, err
+		}
+		if err := r.UpdatePhase(instance, dbv1alpha1.RestoreStarting); err != nil {
+			return reconcile.Result{}, err
+		}
+	case dbv1alpha1.RestoreStarting, dbv1alpha1.Restoring:
+		// The driver reports Restoring and Completed itself, but we follow
+		// the job too in case it completed without doing so
+		job, err := r.getJob(instance)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		switch {
+		case job == nil:
+			return reconcile.Result{}, r.launchJob(instance)
+		case util.JobSucceeded(job):
+			return reconcile.Result{}, r.UpdatePhase(instance, dbv1alpha1.RestoreCompleted)
+		case util.JobFailed(job):
+			reqLogger.Info("Restore job failed", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			r.recorder.Eventf(instance, corev1.EventTypeWarning, util.JobFailedReason, "Job %s failed", job.Name)
+			return reconcile.Result{}, r.UpdatePhase(instance, dbv1alpha1.RestoreFailed)
+		}
+	case dbv1alpha1.RestoreCompleted, dbv1alpha1.RestoreFailed:
+		// Nothing to do
+	}
+	return reconcile.Result{}, nil
+}
@@ -0,0 +1,129 @@
+package restore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func fakeReconciler(objs []runtime.Object) *ReconcileRestore {
+	s := scheme.Scheme
+	s.AddKnownTypes(dbv1alpha1.SchemeGroupVersion,
+		&dbv1alpha1.Database{}, &dbv1alpha1.Backup{}, &dbv1alpha1.Restore{},
+		&dbv1alpha1.Provider{}, &dbv1alpha1.ProviderList{})
+	cl := fake.NewFakeClient(objs...)
+	return &ReconcileRestore{client: cl, scheme: s, recorder: &record.FakeRecorder{}}
+}
+
+func testObjects(backupPhase dbv1alpha1.BackupPhase) []runtime.Object {
+	return []runtime.Object{
+		&dbv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Name: "testdb", Namespace: "testns"},
+			Spec:       dbv1alpha1.DatabaseSpec{Provider: "postgresql"},
+		},
+		&dbv1alpha1.Provider{
+			ObjectMeta: metav1.ObjectMeta{Name: "postgresql-provider", Namespace: "testns"},
+			Spec: dbv1alpha1.ProviderSpec{
+				Name:  "postgresql",
+				Image: "isotoma/db-operator-postgresql",
+			},
+		},
+		&dbv1alpha1.Backup{
+			ObjectMeta: metav1.ObjectMeta{Name: "testbackup", Namespace: "testns"},
+			Spec:       dbv1alpha1.BackupSpec{Database: "olddb"},
+			Status:     dbv1alpha1.BackupStatus{Phase: backupPhase},
+		},
+		&dbv1alpha1.Restore{
+			ObjectMeta: metav1.ObjectMeta{Name: "testrestore", Namespace: "testns"},
+			Spec:       dbv1alpha1.RestoreSpec{Backup: "testbackup", Database: "testdb"},
+		},
+	}
+}
+
+var key = types.NamespacedName{Namespace: "testns", Name: "testrestore"}
+
+func TestReconcileLaunchesJob(t *testing.T) {
+	r := fakeReconciler(testObjects(dbv1alpha1.Completed))
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	restore := &dbv1alpha1.Restore{}
+	if err := r.client.Get(context.TODO(), key, restore); err != nil {
+		t.Fatalf("Unable to get restore: %s", err)
+	}
+	if restore.Status.Phase != dbv1alpha1.RestoreStarting {
+		t.Errorf("Restore not moved to Starting")
+	}
+	job, err := r.getJob(restore)
+	if err != nil || job == nil {
+		t.Fatalf("No restore job launched")
+	}
+	env := map[string]string{}
+	for _, e := range job.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["DB_OPERATOR_RESTORE"] != "testrestore" || env["DB_OPERATOR_DATABASE"] != "testdb" {
+		t.Errorf("Restore job environment incorrect: %v", env)
+	}
+	if env["DB_OPERATOR_OPERATION"] != "restore" {
+		t.Errorf("Restore job operation incorrect: %s", env["DB_OPERATOR_OPERATION"])
+	}
+}
+
+func TestReconcileIncompleteBackup(t *testing.T) {
+	r := fakeReconciler(testObjects(dbv1alpha1.BackingUp))
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err == nil {
+		t.Errorf("Reconcile did not fail for an incomplete backup")
+	}
+}
+
+func TestReconcileFailedJob(t *testing.T) {
+	r := fakeReconciler(testObjects(dbv1alpha1.Completed))
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile threw unexpected error: %s", err)
+	}
+	restore := &dbv1alpha1.Restore{}
+	r.client.Get(context.TODO(), key, restore)
+	job, err := r.getJob(restore)
+	if err != nil || job == nil {
+		t.Fatalf("No restore job launched")
+	}
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	r.client.Update(context.TODO(), job)
+	// The failure is only recorded once
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(reconcile.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("Reconcile threw unexpected error: %s", err)
+		}
+	}
+	r.client.Get(context.TODO(), key, restore)
+	if restore.Status.Phase != dbv1alpha1.RestoreFailed {
+		t.Errorf("Expected Failed, got %s", restore.Status.Phase)
+	}
+	expected := []string{
+		"Normal JobLaunched Launched restore job testrestore-restore",
+		"Normal Starting Restore is Starting",
+		"Warning JobFailed Job testrestore-restore failed",
+		"Normal Failed Restore is Failed",
+	}
+	var found []string
+	for len(recorder.Events) > 0 {
+		found = append(found, <-recorder.Events)
+	}
+	if strings.Join(found, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected events %v, got %v", expected, found)
+	}
+}
@@ -5,12 +5,17 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
+	"filippo.io/age"
 	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/isotoma/db-operator/pkg/apis"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -22,12 +27,40 @@ import (
 type Container struct {
 	k8sclient client.Client
 	backup    dbv1alpha1.Backup
+	restore   dbv1alpha1.Restore
 	database  dbv1alpha1.Database
+	provider  dbv1alpha1.Provider
+	// instance is the one the database is on, if any
+	instance  *dbv1alpha1.DatabaseInstance
 	secret    corev1.Secret
 	Namespace string
 	Database  string
 	Backup    string
+	Restore   string
+	// Provider is the name of the provider resource, set only for probes
+	Provider string
+	// Instance is the name of the instance resource, set only for checks
+	Instance  string
+	Operation util.Operation
 	drivers   map[string]*Driver
+	// sinkFor returns the destination for backups of a database
+	sinkFor func(*dbv1alpha1.Database) (Sink, error)
+	// sinkHolding returns the sink holding a backup, for restores and
+	// pruning
+	sinkHolding func(string, *dbv1alpha1.Database) (Sink, string, error)
+	// backupNamespace is where the credentials of the backup destination
+	// are read, if not the namespace of the database. It is that of the
+	// instance when the destination was taken from it
+	backupNamespace string
+	// secretBackends are registered in addition to the built in backends
+	secretBackends []SecretBackend
+	// vault is kept so its login is reused for each credential
+	vault *VaultSecretBackend
+	// ProgressInterval is how often progress is written to the status of
+	// the resource being reconciled, at most
+	ProgressInterval time.Duration
+	progress         *progressReporter
+	recorder         record.EventRecorder
 }
 
 type ConnectionDetails map[string]string
@@ -38,13 +71,30 @@ type Credentials struct {
 }
 
 type Driver struct {
-	Name     string
+	Name string
+	// Version of the driver, recorded on backups
+	Version  string
 	Connect  ConnectionDetails
 	Master   Credentials
 	Database Credentials
 	Create   func(*Driver) error
 	Drop     func(*Driver) error
 	Backup   func(*Driver, *io.Writer) error
+	// Rotate sets the password of the database user to Database.Password
+	Rotate func(*Driver) error
+	// Restore loads a backup, as written by Backup, into the database
+	Restore func(*Driver, io.Reader) error
+	// Clone replaces the contents of the target database with a copy of
+	// the source, which is on the same server. Without it databases are
+	// cloned by piping a Backup of the source into a Restore of the target
+	Clone func(target *Driver, source *Driver) error
+	// ServerVersion returns the version of the database server, which is
+	// recorded on backups
+	ServerVersion func(*Driver) (string, error)
+	// Verify sanity checks a database a backup has been restored into, for
+	// example by counting the rows of a table that should never be empty
+	Verify   func(*Driver) error
+	progress *progressReporter
 }
 
 var log = logf.Log.WithName("provider-api")
@@ -85,6 +135,7 @@ func (p *Container) connect() error {
 	mgr.GetCache().WaitForCacheSync(stopChan)
 	log.Info("Getting client")
 	p.k8sclient = mgr.GetClient()
+	p.recorder = mgr.GetRecorder("db-operator-driver")
 	return nil
 }
 
@@ -106,69 +157,255 @@ func (p *Container) setup() error {
 	if p.Backup == "" {
 		p.Backup = os.Getenv("DB_OPERATOR_BACKUP")
 	}
-	if p.Database == "" && p.Backup == "" {
-		return fmt.Errorf("No database or backup name provided")
+	if p.Restore == "" {
+		p.Restore = os.Getenv("DB_OPERATOR_RESTORE")
+	}
+	if p.Provider == "" {
+		p.Provider = os.Getenv("DB_OPERATOR_PROVIDER")
+	}
+	if p.Instance == "" {
+		p.Instance = os.Getenv("DB_OPERATOR_INSTANCE")
+	}
+	if p.Operation == "" {
+		p.Operation = util.Operation(os.Getenv("DB_OPERATOR_OPERATION"))
+	}
+	if p.Database == "" && p.Backup == "" && p.Restore == "" && p.Provider == "" && p.Instance == "" {
+		return fmt.Errorf("No database, backup, restore, provider or instance name provided")
+	}
+	if p.sinkFor == nil {
+		p.sinkFor = p.newSink
+	}
+	if p.sinkHolding == nil {
+		p.sinkHolding = p.locateBackup
+	}
+	if p.ProgressInterval == 0 {
+		p.ProgressInterval = DefaultProgressInterval
 	}
 	if err := p.connect(); err != nil {
 		return err
 	}
-	if p.Database != "" {
-		if err := p.getResource(p.Database, &p.database); err != nil {
+	return p.load()
+}
+
+// load fetches the resources we are to reconcile
+func (p *Container) load() error {
+	// Probes are not of any database
+	if p.Provider != "" {
+		return p.getResource(p.Provider, &p.provider)
+	}
+	// Checks are given the instance's details as if it were a database
+	if p.Instance != "" {
+		p.instance = &dbv1alpha1.DatabaseInstance{}
+		if err := p.getResource(p.Instance, p.instance); err != nil {
 			return err
 		}
-		if err := p.getResource(p.Database, &p.secret); err != nil {
-			if !errors.IsNotFound(err) {
-				return err
-			}
+		util.MergeInstance(&p.database, p.instance)
+		p.backupNamespace = p.instance.Namespace
+		return nil
+	}
+	if p.Restore != "" {
+		if err := p.getResource(p.Restore, &p.restore); err != nil {
+			return err
+		}
+		if p.Database == "" {
+			p.Database = p.restore.Spec.Database
+		}
+		if err := p.getResource(p.restore.Spec.Backup, &p.backup); err != nil {
+			return err
 		}
 	}
 	if p.Backup != "" {
 		if err := p.getResource(p.Backup, &p.backup); err != nil {
 			return err
 		}
+		if p.Database == "" {
+			p.Database = p.backup.Spec.Database
+		}
+	}
+	if err := p.getResource(p.Database, &p.database); err != nil {
+		// Backups are pruned after their database has been deleted, from
+		// the destination recorded on them
+		if errors.IsNotFound(err) && p.Backup != "" && p.Operation == util.PruneOperation {
+			p.database.Name = p.Database
+			p.database.Namespace = p.Namespace
+			return nil
+		}
+		return err
+	}
+	// A database on an instance takes its connection details and
+	// credentials from it. The database is only ever written back with a
+	// status update, so they are not copied into it
+	instance, err := util.GetInstance(p.k8sclient, &p.database)
+	if err != nil {
+		return err
+	}
+	if instance != nil {
+		if !p.database.Spec.BackupTo.Configured() {
+			p.backupNamespace = instance.Namespace
+		}
+		util.MergeInstance(&p.database, instance)
+		p.instance = instance
+	}
+	if err := p.getResource(p.Database, &p.secret); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
 	}
 	return nil
 }
 
-func (p *Container) readFromKubernetesSecret(s dbv1alpha1.SecretKeyRef) (string, error) {
-	return "", nil
+// RegisterSecretBackend registers an additional store that credentials may
+// be read from. Registered backends are consulted before the built in ones
+func (p *Container) RegisterSecretBackend(b SecretBackend) {
+	p.secretBackends = append(p.secretBackends, b)
 }
 
-func (p *Container) readFromAwsSecret(s dbv1alpha1.AwsSecretRef) (string, error) {
-	return "", nil
+// backends returns the registered secret backends followed by those for
+// Kubernetes, reading from the namespace, AWS and Vault secrets
+func (p *Container) backends(namespace string) []SecretBackend {
+	if p.vault == nil {
+		p.vault = NewVaultSecretBackend()
+	}
+	backends := append([]SecretBackend{}, p.secretBackends...)
+	return append(backends,
+		&KubernetesSecretBackend{Client: p.k8sclient, Namespace: namespace},
+		NewAwsSecretBackend(p.database.Spec.AwsCredentials),
+		p.vault,
+	)
 }
 
+// getCredential returns the value of the credential, reading secrets in the
+// namespace of the database
 func (p *Container) getCredential(cred dbv1alpha1.Credential) (string, error) {
-	if cred.Value != "" {
-		return "", nil
+	return p.getCredentialIn(p.Namespace, cred)
+}
+
+// getBackupCredential returns the value of a credential of the backup
+// destination, reading secrets in the namespace it was given in
+func (p *Container) getBackupCredential(cred dbv1alpha1.Credential) (string, error) {
+	return p.getCredentialIn(p.backupCredentialNamespace(), cred)
+}
+
+// backupCredentialNamespace returns the namespace the credentials of the
+// backup destination are read in
+func (p *Container) backupCredentialNamespace() string {
+	if p.backupNamespace != "" {
+		return p.backupNamespace
 	}
-	if cred.ValueFrom.SecretKeyRef.Name != "" {
-		return p.readFromKubernetesSecret(cred.ValueFrom.SecretKeyRef)
+	return p.Namespace
+}
+
+// getCredentialIn returns the value of the credential, reading secrets in
+// the namespace. Failures are reported as events on the database, as they
+// are usually down to its spec
+func (p *Container) getCredentialIn(namespace string, cred dbv1alpha1.Credential) (string, error) {
+	if cred.Value != "" {
+		return cred.Value, nil
 	}
-	if cred.ValueFrom.AwsSecretKeyRef.ARN != "" {
-		return p.readFromAwsSecret(cred.ValueFrom.AwsSecretKeyRef)
+	err := fmt.Errorf("No credentials provided")
+	for _, b := range p.backends(namespace) {
+		if b.Handles(cred.ValueFrom) {
+			var value string
+			if value, err = b.Read(cred.ValueFrom); err == nil {
+				return value, nil
+			}
+			break
+		}
 	}
-	return "", fmt.Errorf("No credentials provided")
+	p.recorder.Eventf(&p.database, corev1.EventTypeWarning, util.CredentialsFailedReason, "Unable to read credential: %s", err)
+	return "", err
 }
 
 func (p *Container) getDriver() (*Driver, error) {
 	spec := p.database.Spec
-	driver := p.drivers[spec.Provider]
+	registered, ok := p.drivers[spec.Provider]
+	if !ok {
+		return nil, fmt.Errorf("No driver %q is registered by this image", spec.Provider)
+	}
+	// Each database gets its own copy, as a clone needs two at once
+	d := *registered
+	driver := &d
 	driver.Connect = spec.Connect
-	username, err := p.getCredential(spec.Credentials.Username)
+	// The master credentials of an instance are read in its namespace
+	namespace := p.Namespace
+	if p.instance != nil {
+		namespace = p.instance.Namespace
+	}
+	username, err := p.getCredentialIn(namespace, spec.Credentials.Username)
 	if err != nil {
 		return nil, err
 	}
-	password, err := p.getCredential(spec.Credentials.Password)
+	password, err := p.getCredentialIn(namespace, spec.Credentials.Password)
 	if err != nil {
 		return nil, err
 	}
 	driver.Master.Username = username
 	driver.Master.Password = password
 	driver.Database.Username = spec.Name
+	driver.Database.Password = string(p.secret.Data["password"])
+	driver.progress = p.progress
 	return driver, nil
 }
 
+// updateDatabasePhase persists the phase of the database
+func (p *Container) updateDatabasePhase(phase dbv1alpha1.DatabasePhase) error {
+	log.Info("Updating database phase", "Phase", phase)
+	previous := p.database.Status.Phase
+	p.database.Status.Phase = phase
+	p.database.Status.Progress = nil
+	ready, progressing := util.DatabasePhaseConditions(&p.database)
+	util.SetCondition(&p.database.Status.Conditions, ready)
+	util.SetCondition(&p.database.Status.Conditions, progressing)
+	if err := p.k8sclient.Status().Update(context.TODO(), &p.database); err != nil {
+		return err
+	}
+	if previous != phase {
+		p.recorder.Eventf(&p.database, corev1.EventTypeNormal, string(phase), "Database is %s", phase)
+	}
+	return nil
+}
+
+// performing returns true if the container was launched to perform op. If
+// no operation was provided then it is determined by the phase alone
+func (p *Container) performing(op util.Operation) bool {
+	return p.Operation == "" || p.Operation == op
+}
+
+// create creates the database, recording progress in its phase. If we were
+// terminated part way through a previous creation it is simply repeated
+func (p *Container) create(driver *Driver) error {
+	if p.database.Status.Phase != dbv1alpha1.Creating {
+		if err := p.updateDatabasePhase(dbv1alpha1.Creating); err != nil {
+			return err
+		}
+	}
+	if err := p.ensureSecret(driver); err != nil {
+		return err
+	}
+	if err := driver.Create(driver); err != nil {
+		return err
+	}
+	// A database with a source is populated from it before it is ready
+	if !p.database.Populated() {
+		return p.updateDatabasePhase(dbv1alpha1.Populating)
+	}
+	return p.updateDatabasePhase(dbv1alpha1.Created)
+}
+
+// drop drops the database, recording progress in its phase. If we were
+// terminated part way through a previous deletion it is simply repeated
+func (p *Container) drop(driver *Driver) error {
+	if p.database.Status.Phase != dbv1alpha1.DeletionInProgress {
+		if err := p.updateDatabasePhase(dbv1alpha1.DeletionInProgress); err != nil {
+			return err
+		}
+	}
+	if err := driver.Drop(driver); err != nil {
+		return err
+	}
+	return p.updateDatabasePhase(dbv1alpha1.Deleted)
+}
+
 func (p *Container) reconcileDatabase() error {
 	phase := p.database.Status.Phase
 	driver, err := p.getDriver()
@@ -177,48 +414,417 @@ func (p *Container) reconcileDatabase() error {
 	}
 
 	switch {
-	case phase == "":
-		err = driver.Create(driver)
-		// change state to creating and call Create
-		// if it terminates without error then move state to Created
-	case phase == dbv1alpha1.Creating:
-		// We've been terminated during a creation
-		// call Create again
-		// if it terminates without error then move state to Created
-	case phase == dbv1alpha1.Created:
-		// We don't need to do anything
-	case phase == dbv1alpha1.DeletionRequested:
-		// do the delete
-	case phase == dbv1alpha1.DeletionInProgress:
-		// check status
-	case phase == dbv1alpha1.Deleted:
-		// do nothing
-	case phase == dbv1alpha1.BackupBeforeDeleteRequested:
-		// perform the backup
-	case phase == dbv1alpha1.BackupBeforeDeleteInProgress:
-		// check status
-	case phase == dbv1alpha1.BackupBeforeDeleteCompleted:
-		// do nothing
+	case phase == "" || phase == dbv1alpha1.Creating:
+		if p.performing(util.CreateOperation) {
+			return p.create(driver)
+		}
+	case phase == dbv1alpha1.DeletionRequested ||
+		phase == dbv1alpha1.DeletionInProgress ||
+		phase == dbv1alpha1.BackupBeforeDeleteCompleted:
+		if p.performing(util.DropOperation) {
+			return p.drop(driver)
+		}
+	case phase == dbv1alpha1.RotationRequested || phase == dbv1alpha1.RotationInProgress:
+		if p.performing(util.RotateOperation) {
+			return p.rotate(driver)
+		}
+	case phase == dbv1alpha1.BackupBeforeDeleteRequested ||
+		phase == dbv1alpha1.BackupBeforeDeleteInProgress:
+		// The backup is performed by a backup job, which moves the phase
+		// on to BackupBeforeDeleteCompleted. Only then can we drop
+		if p.performing(util.DropOperation) {
+			return fmt.Errorf("Database %s has not yet been backed up", p.Database)
+		}
+	}
+	// Created, Deleted and the backup phases need nothing from us
+	log.Info("Nothing to do", "Phase", phase, "Operation", p.Operation)
+	return nil
+}
+
+// updateBackupStatus persists the status of the backup
+func (p *Container) updateBackupStatus() error {
+	log.Info("Updating backup status", "Phase", p.backup.Status.Phase)
+	ready, progressing := util.BackupPhaseConditions(&p.backup)
+	util.SetCondition(&p.backup.Status.Conditions, ready)
+	util.SetCondition(&p.backup.Status.Conditions, progressing)
+	return p.k8sclient.Status().Update(context.TODO(), &p.backup)
+}
 
+// databaseBackupPhases maps the phase of a database awaiting a backup to
+// the phases it moves through while the backup is performed
+var databaseBackupPhases = map[dbv1alpha1.DatabasePhase][2]dbv1alpha1.DatabasePhase{
+	dbv1alpha1.BackupRequested:              {dbv1alpha1.BackupInProgress, dbv1alpha1.BackupCompleted},
+	dbv1alpha1.BackupInProgress:             {dbv1alpha1.BackupInProgress, dbv1alpha1.BackupCompleted},
+	dbv1alpha1.BackupBeforeDeleteRequested:  {dbv1alpha1.BackupBeforeDeleteInProgress, dbv1alpha1.BackupBeforeDeleteCompleted},
+	dbv1alpha1.BackupBeforeDeleteInProgress: {dbv1alpha1.BackupBeforeDeleteInProgress, dbv1alpha1.BackupBeforeDeleteCompleted},
+}
+
+// streamBackup runs the driver's Backup, streaming its output to the sink
+// after compressing and encrypting it. The size and checksum of what is
+// stored are recorded in the backup's status
+func (p *Container) streamBackup(driver *Driver, sink Sink, name string, compression dbv1alpha1.Compression, recipients []age.Recipient) error {
+	r, w := io.Pipe()
+	go func() {
+		enc, err := encode(w, compression, recipients)
+		if err == nil {
+			var out io.Writer = enc
+			// Progress is counted before compression and encryption, so
+			// that it can be compared with the total the driver reports
+			if p.progress != nil {
+				out = &countingWriter{w: enc, onWrite: func(size int64) { p.progress.count(size, 0) }}
+			}
+			if err = driver.Backup(driver, &out); err == nil {
+				err = enc.Close()
+			}
+		}
+		w.CloseWithError(err)
+	}()
+	d := newDigestReader(r)
+	err := sink.Upload(name, d)
+	// Unblock the driver if the upload gave up before reading everything
+	r.CloseWithError(err)
+	if err != nil {
+		return err
 	}
+	p.backup.Status.Size = d.size
+	p.backup.Status.Checksum = d.Checksum()
 	return nil
 }
 
+// recordDriver records the driver and database server versions on the
+// backup. The server version is only informational, so failing to read it
+// does not prevent the backup
+func (p *Container) recordDriver(driver *Driver) {
+	p.backup.Status.Driver = driver.Name
+	p.backup.Status.DriverVersion = driver.Version
+	if driver.ServerVersion != nil {
+		version, err := driver.ServerVersion(driver)
+		if err != nil {
+			log.Error(err, "Unable to read database server version")
+		}
+		p.backup.Status.ServerVersion = version
+	}
+}
+
 func (p *Container) reconcileBackup() error {
+	if p.backup.Status.Phase == dbv1alpha1.Completed {
+		log.Info("Backup already completed")
+		return nil
+	}
+	driver, err := p.getDriver()
+	if err != nil {
+		return err
+	}
+	sink, err := p.sinkFor(&p.database)
+	if err != nil {
+		return err
+	}
+	phases, awaited := databaseBackupPhases[p.database.Status.Phase]
+	if awaited && p.database.Status.Phase != phases[0] {
+		if err := p.updateDatabasePhase(phases[0]); err != nil {
+			return err
+		}
+	}
+	dest := p.database.Spec.BackupTo
+	var recipients []age.Recipient
+	if dest.Encryption != nil {
+		if recipients, err = p.recipients(dest.Encryption); err != nil {
+			return err
+		}
+		p.backup.Status.Encryption = dbv1alpha1.AgeEncryption
+	}
+	name := p.database.Name + "/" + p.backup.Name
+	p.backup.Status.Phase = dbv1alpha1.BackingUp
+	p.backup.Status.Destination = sink.Location(name)
+	p.backup.Status.BackupTo = dest.DeepCopy()
+	p.backup.Status.BackupToNamespace = p.backupCredentialNamespace()
+	p.backup.Status.Compression = dest.Compression
+	p.backup.Status.Progress = nil
+	now := metav1.Now()
+	p.backup.Status.StartTime = &now
+	p.recordDriver(driver)
+	if err := p.updateBackupStatus(); err != nil {
+		return err
+	}
+	if err := p.streamBackup(driver, sink, name, dest.Compression, recipients); err != nil {
+		return err
+	}
+	now = metav1.Now()
+	p.backup.Status.CompletionTime = &now
+	p.backup.Status.Phase = dbv1alpha1.Completed
+	p.backup.Status.Progress = nil
+	if err := p.updateBackupStatus(); err != nil {
+		return err
+	}
+	if awaited {
+		return p.updateDatabasePhase(phases[1])
+	}
+	return nil
+}
+
+// reconcilePrune removes the stored backup once it has been pruned under
+// its retention policy. The operator deletes the backup resource afterwards
+func (p *Container) reconcilePrune() error {
+	if p.backup.Status.Phase != dbv1alpha1.Pruning {
+		return fmt.Errorf("Backup %s is %s, not Pruning", p.backup.Name, p.backup.Status.Phase)
+	}
+	if p.backup.Status.Destination == "" {
+		log.Info("Backup has no destination, nothing to prune")
+		return nil
+	}
+	// The destination recorded on the backup is used, as the database may
+	// have been changed or deleted since
+	database := p.database.DeepCopy()
+	if p.backup.Status.BackupTo != nil {
+		database.Spec.BackupTo = *p.backup.Status.BackupTo
+		if p.backup.Status.BackupToNamespace != "" {
+			p.backupNamespace = p.backup.Status.BackupToNamespace
+		}
+	}
+	sink, name, err := p.sinkHolding(p.backup.Status.Destination, database)
+	if err != nil {
+		return err
+	}
+	log.Info("Deleting stored backup", "Destination", p.backup.Status.Destination)
+	return sink.Delete(name)
+}
+
+// setVerified records the result of verifying the backup
+func (p *Container) setVerified(status dbv1alpha1.ConditionStatus, reason, message string) error {
+	log.Info("Updating backup verification", "Status", status, "Reason", reason)
+	util.SetCondition(&p.backup.Status.Conditions, dbv1alpha1.Condition{
+		Type:               dbv1alpha1.BackupVerified,
+		Status:             status,
+		ObservedGeneration: p.backup.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+	return p.k8sclient.Status().Update(context.TODO(), &p.backup)
+}
+
+// reconcileVerify checks the scratch database the backup has been restored
+// into. A failed check is recorded on the backup, rather than failing the
+// job, which is reserved for being unable to run the check at all
+func (p *Container) reconcileVerify() error {
+	driver, err := p.getDriver()
+	if err != nil {
+		return err
+	}
+	if driver.Verify == nil {
+		return p.setVerified(dbv1alpha1.ConditionTrue, "Restored", fmt.Sprintf("The backup was restored, driver %s has no further checks", driver.Name))
+	}
+	if err := driver.Verify(driver); err != nil {
+		return p.setVerified(dbv1alpha1.ConditionFalse, "CheckFailed", err.Error())
+	}
+	return p.setVerified(dbv1alpha1.ConditionTrue, "Verified", "The backup was restored and passed the driver's checks")
+}
+
+// updateRestorePhase persists the phase of the restore
+func (p *Container) updateRestorePhase(phase dbv1alpha1.RestorePhase) error {
+	log.Info("Updating restore phase", "Phase", phase)
+	previous := p.restore.Status.Phase
+	p.restore.Status.Phase = phase
+	p.restore.Status.Progress = nil
+	if err := p.k8sclient.Status().Update(context.TODO(), &p.restore); err != nil {
+		return err
+	}
+	if previous != phase {
+		p.recorder.Eventf(&p.restore, corev1.EventTypeNormal, string(phase), "Restore of %s into %s is %s", p.backup.Name, p.database.Name, phase)
+	}
 	return nil
 }
 
+// recreate drops the database and creates it again, empty, keeping the
+// password of the database user
+func (p *Container) recreate(driver *Driver) error {
+	if err := driver.Drop(driver); err != nil {
+		return err
+	}
+	if err := p.ensureSecret(driver); err != nil {
+		return err
+	}
+	return driver.Create(driver)
+}
+
+func (p *Container) reconcileRestore() error {
+	if p.restore.Status.Phase == dbv1alpha1.RestoreCompleted {
+		log.Info("Restore already completed")
+		return nil
+	}
+	driver, err := p.getDriver()
+	if err != nil {
+		return err
+	}
+	if driver.Restore == nil {
+		return fmt.Errorf("Driver %s does not support restores", driver.Name)
+	}
+	if p.backup.Status.Phase != dbv1alpha1.Completed {
+		return fmt.Errorf("Backup %s has not completed", p.backup.Name)
+	}
+	// Databases are also populated from their source backup by a restore
+	if p.database.Status.Phase != dbv1alpha1.Created && p.database.Status.Phase != dbv1alpha1.Populating {
+		return fmt.Errorf("Database %s is %s, not Created", p.database.Name, p.database.Status.Phase)
+	}
+	sink, name, err := p.sinkHolding(p.backup.Status.Destination, &p.database)
+	if err != nil {
+		return err
+	}
+	var identities []age.Identity
+	if p.backup.Status.Encryption != "" {
+		enc := p.database.Spec.BackupTo.Encryption
+		if enc == nil {
+			return fmt.Errorf("Backup %s is encrypted, but database %s has no encryption configured", p.backup.Name, p.database.Name)
+		}
+		if identities, err = p.identities(enc); err != nil {
+			return err
+		}
+	}
+	if p.restore.Status.Phase != dbv1alpha1.Restoring {
+		if err := p.updateRestorePhase(dbv1alpha1.Restoring); err != nil {
+			return err
+		}
+	}
+	// The backup is checked before anything is changed in the database.
+	// Backups made before checksums were recorded cannot be checked
+	if p.backup.Status.Checksum != "" {
+		if err := verify(sink, name, p.backup.Status); err != nil {
+			return err
+		}
+	}
+	// This also clears out anything left by an earlier, interrupted, attempt
+	if p.restore.Spec.DropAndRecreate {
+		if err := p.recreate(driver); err != nil {
+			return err
+		}
+	}
+	stored, err := sink.Open(name)
+	if err != nil {
+		return err
+	}
+	if p.progress != nil {
+		d := newDigestReader(stored)
+		d.onRead = func(size int64) { p.progress.count(size, p.backup.Status.Size) }
+		stored = struct {
+			io.Reader
+			io.Closer
+		}{d, stored}
+	}
+	r, err := decode(stored, p.backup.Status, identities)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := driver.Restore(driver, r); err != nil {
+		return err
+	}
+	return p.updateRestorePhase(dbv1alpha1.RestoreCompleted)
+}
+
 // Run the provider, which will reconcile the provided database/backup
 // using the registered drivers
 func (p *Container) Run() error {
 	if err := p.setup(); err != nil {
 		return err
 	}
-	if p.Database != "" {
-		return p.reconcileDatabase()
+	err := p.reconcile()
+	if recordErr := p.recordResult(err); recordErr != nil {
+		log.Error(recordErr, "Unable to record the result in the Degraded condition")
+	}
+	return err
+}
+
+// recordResult reports a failure of the driver with an event, and marks the
+// database or backup reconciled as Degraded, clearing this once it
+// succeeds. Restores, verification, probes and checks have no Degraded
+// condition of their own, or one kept by the operator
+func (p *Container) recordResult(err error) error {
+	var obj runtime.Object
+	var conditions *[]dbv1alpha1.Condition
+	var name string
+	if err != nil {
+		var reconciled runtime.Object = &p.database
+		if p.Provider != "" {
+			reconciled = &p.provider
+		} else if p.Instance != "" {
+			reconciled = p.instance
+		} else if p.Restore != "" {
+			reconciled = &p.restore
+		} else if p.Backup != "" {
+			reconciled = &p.backup
+		}
+		p.recorder.Eventf(reconciled, corev1.EventTypeWarning, util.DriverErrorReason, "Driver %s failed: %s", p.Operation, err)
+	}
+	switch {
+	case p.Provider != "" || p.Instance != "" || p.Restore != "" || p.Operation == util.VerifyOperation:
+		return nil
+	case p.Backup != "":
+		backup := &dbv1alpha1.Backup{}
+		obj, conditions, name = backup, &backup.Status.Conditions, p.Backup
+	default:
+		database := &dbv1alpha1.Database{}
+		obj, conditions, name = database, &database.Status.Conditions, p.Database
+	}
+	// The resource is fetched again, as the driver may have left it stale
+	if getErr := p.getResource(name, obj); getErr != nil {
+		if errors.IsNotFound(getErr) {
+			return nil
+		}
+		return getErr
+	}
+	existing := util.FindCondition(*conditions, dbv1alpha1.ConditionDegraded)
+	if err == nil && existing != nil && existing.Reason != util.DriverErrorReason {
+		return nil
+	}
+	generation := obj.(metav1.Object).GetGeneration()
+	if !util.SetCondition(conditions, util.Degraded(generation, util.DriverErrorReason, err)) {
+		return nil
+	}
+	return p.k8sclient.Status().Update(context.TODO(), obj)
+}
+
+// reporter returns a progress reporter writing to the status of obj, after
+// set has put the progress in it
+func (p *Container) reporter(obj runtime.Object, set func(*dbv1alpha1.Progress)) *progressReporter {
+	return newProgressReporter(p.ProgressInterval, func(progress *dbv1alpha1.Progress) error {
+		set(progress)
+		return p.k8sclient.Status().Update(context.TODO(), obj)
+	})
+}
+
+func (p *Container) reconcile() error {
+	if p.Provider != "" {
+		return p.reconcileProbe()
+	}
+	if p.Instance != "" {
+		return p.reconcileCheck()
+	}
+	if p.Restore != "" {
+		p.progress = p.reporter(&p.restore, func(progress *dbv1alpha1.Progress) {
+			p.restore.Status.Progress = progress
+		})
+		return p.reconcileRestore()
+	}
+	// Backup jobs are provided with the database as well as the backup
+	if p.Backup != "" && p.Operation == util.PruneOperation {
+		return p.reconcilePrune()
+	}
+	if p.Backup != "" && p.Operation == util.VerifyOperation {
+		return p.reconcileVerify()
+	}
+	if p.Operation == util.CloneOperation {
+		p.progress = p.reporter(&p.database, func(progress *dbv1alpha1.Progress) {
+			p.database.Status.Progress = progress
+		})
+		return p.reconcileClone()
 	}
 	if p.Backup != "" {
+		p.progress = p.reporter(&p.backup, func(progress *dbv1alpha1.Progress) {
+			p.backup.Status.Progress = progress
+		})
 		return p.reconcileBackup()
 	}
-	return nil
+	p.progress = p.reporter(&p.database, func(progress *dbv1alpha1.Progress) {
+		p.database.Status.Progress = progress
+	})
+	return p.reconcileDatabase()
 }
@@ -0,0 +1,159 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeDriver records the calls made to it, and fails them if err is set
+type fakeDriver struct {
+	calls []string
+	err   error
+}
+
+func (f *fakeDriver) driver() *Driver {
+	return &Driver{
+		Name:    "fake",
+		Version: "1.0",
+		ServerVersion: func(d *Driver) (string, error) {
+			return "fake 9.6", nil
+		},
+		Create: func(d *Driver) error {
+			f.calls = append(f.calls, "create")
+			return f.err
+		},
+		Drop: func(d *Driver) error {
+			f.calls = append(f.calls, "drop")
+			return f.err
+		},
+		Backup: func(d *Driver, w *io.Writer) error {
+			f.calls = append(f.calls, "backup")
+			if _, err := io.WriteString(*w, "dump of "+d.Database.Username); err != nil {
+				return err
+			}
+			return f.err
+		},
+		Restore: func(d *Driver, r io.Reader) error {
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			f.calls = append(f.calls, "restore "+string(b))
+			return f.err
+		},
+	}
+}
+
+func testDatabase(phase dbv1alpha1.DatabasePhase) *dbv1alpha1.Database {
+	return &dbv1alpha1.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "testdb", Namespace: "testns"},
+		Spec: dbv1alpha1.DatabaseSpec{
+			Provider: "fake",
+			Name:     "testdb",
+			Credentials: dbv1alpha1.Credentials{
+				Username: dbv1alpha1.Credential{Value: "master"},
+				Password: dbv1alpha1.Credential{Value: "secret"},
+			},
+		},
+		Status: dbv1alpha1.DatabaseStatus{Phase: phase},
+	}
+}
+
+func fakeContainer(f *fakeDriver, op util.Operation, objs ...runtime.Object) *Container {
+	s := scheme.Scheme
+	s.AddKnownTypes(dbv1alpha1.SchemeGroupVersion, &dbv1alpha1.Database{}, &dbv1alpha1.Backup{}, &dbv1alpha1.Restore{}, &dbv1alpha1.Provider{},
+		&dbv1alpha1.DatabaseInstance{})
+	p := &Container{
+		k8sclient: fake.NewFakeClient(objs...),
+		Namespace: "testns",
+		Database:  "testdb",
+		Operation: op,
+		recorder:  &record.FakeRecorder{},
+	}
+	p.RegisterDriver(f.driver())
+	return p
+}
+
+func storedPhase(t *testing.T, p *Container) dbv1alpha1.DatabasePhase {
+	db := &dbv1alpha1.Database{}
+	key := types.NamespacedName{Namespace: "testns", Name: "testdb"}
+	if err := p.k8sclient.Get(context.TODO(), key, db); err != nil {
+		t.Fatalf("Unable to get database: %s", err)
+	}
+	return db.Status.Phase
+}
+
+func TestReconcileDatabase(t *testing.T) {
+	cases := []struct {
+		phase dbv1alpha1.DatabasePhase
+		op    util.Operation
+		calls []string
+		final dbv1alpha1.DatabasePhase
+	}{
+		{"", util.CreateOperation, []string{"create"}, dbv1alpha1.Created},
+		{dbv1alpha1.Creating, util.CreateOperation, []string{"create"}, dbv1alpha1.Created},
+		{dbv1alpha1.Created, util.CreateOperation, nil, dbv1alpha1.Created},
+		{dbv1alpha1.DeletionRequested, util.CreateOperation, nil, dbv1alpha1.DeletionRequested},
+		{dbv1alpha1.DeletionRequested, util.DropOperation, []string{"drop"}, dbv1alpha1.Deleted},
+		{dbv1alpha1.DeletionInProgress, util.DropOperation, []string{"drop"}, dbv1alpha1.Deleted},
+		{dbv1alpha1.BackupBeforeDeleteCompleted, util.DropOperation, []string{"drop"}, dbv1alpha1.Deleted},
+		{dbv1alpha1.Deleted, util.DropOperation, nil, dbv1alpha1.Deleted},
+		{dbv1alpha1.DeletionRequested, "", []string{"drop"}, dbv1alpha1.Deleted},
+	}
+	for _, c := range cases {
+		f := &fakeDriver{}
+		p := fakeContainer(f, c.op, testDatabase(c.phase))
+		if err := p.load(); err != nil {
+			t.Fatalf("Unable to load resources: %s", err)
+		}
+		if err := p.reconcileDatabase(); err != nil {
+			t.Errorf("%q/%s: unexpected error: %s", c.phase, c.op, err)
+		}
+		if fmt.Sprint(f.calls) != fmt.Sprint(c.calls) {
+			t.Errorf("%q/%s: expected calls %v, got %v", c.phase, c.op, c.calls, f.calls)
+		}
+		if phase := storedPhase(t, p); phase != c.final {
+			t.Errorf("%q/%s: expected phase %s, got %s", c.phase, c.op, c.final, phase)
+		}
+	}
+}
+
+func TestReconcileDatabaseFailure(t *testing.T) {
+	f := &fakeDriver{err: fmt.Errorf("connection refused")}
+	p := fakeContainer(f, util.CreateOperation, testDatabase(""))
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcileDatabase(); err == nil {
+		t.Errorf("Driver error not returned")
+	}
+	if phase := storedPhase(t, p); phase != dbv1alpha1.Creating {
+		t.Errorf("Expected phase to remain Creating, got %s", phase)
+	}
+}
+
+func TestReconcileDatabaseAwaitingBackup(t *testing.T) {
+	f := &fakeDriver{}
+	p := fakeContainer(f, util.DropOperation, testDatabase(dbv1alpha1.BackupBeforeDeleteInProgress))
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcileDatabase(); err == nil {
+		t.Errorf("Dropped a database before it was backed up")
+	}
+	if len(f.calls) != 0 {
+		t.Errorf("Unexpected driver calls %v", f.calls)
+	}
+}
@@ -0,0 +1,185 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+)
+
+const (
+	azureVersion = "2018-03-28"
+	// azureBlockSize is the default size of each block uploaded
+	azureBlockSize = 4 * 1024 * 1024
+	// azureMaxBlockSize is the largest block this version of the API
+	// accepts
+	azureMaxBlockSize = 100 * 1024 * 1024
+	// azureMaxBlocks is the most blocks a blob may have
+	azureMaxBlocks = 50000
+)
+
+// AzureSink streams backups to an Azure Blob Storage container, using a
+// shared access signature
+type AzureSink struct {
+	Account   string
+	Container string
+	Prefix    string
+	Endpoint  string
+	// BlockSize of uploads, which defaults to 4MiB
+	BlockSize int
+	sasToken  string
+	client    *http.Client
+	maxBlocks int
+}
+
+// NewAzureSink returns a sink writing to the container described by dest
+func NewAzureSink(dest dbv1alpha1.AzureBackup, sasToken string) *AzureSink {
+	endpoint := strings.TrimSuffix(dest.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", dest.Account)
+	}
+	blockSize := azureBlockSize
+	if dest.BlockSizeMiB > 0 {
+		blockSize = dest.BlockSizeMiB * 1024 * 1024
+	}
+	return &AzureSink{
+		Account:   dest.Account,
+		Container: dest.Container,
+		Prefix:    dest.Prefix,
+		Endpoint:  endpoint,
+		BlockSize: blockSize,
+		sasToken:  strings.TrimPrefix(sasToken, "?"),
+		client:    http.DefaultClient,
+		maxBlocks: azureMaxBlocks,
+	}
+}
+
+// newAzureSink returns a sink for dest, reading its shared access signature
+func (p *Container) newAzureSink(dest dbv1alpha1.AzureBackup) (*AzureSink, error) {
+	if dest.BlockSizeMiB < 0 || dest.BlockSizeMiB*1024*1024 > azureMaxBlockSize {
+		return nil, fmt.Errorf("Azure blockSizeMiB must be from 1 to %d, not %d", azureMaxBlockSize/1024/1024, dest.BlockSizeMiB)
+	}
+	token, err := p.getBackupCredential(dest.SASToken)
+	if err != nil {
+		return nil, err
+	}
+	return NewAzureSink(dest, token), nil
+}
+
+// blobURL returns the URL of the named backup with the signature and any
+// further query parameters
+func (s *AzureSink) blobURL(name, query string) string {
+	u := fmt.Sprintf("%s/%s/%s?%s", s.Endpoint, url.PathEscape(s.Container), s.escape(s.Prefix+name), s.sasToken)
+	if query != "" {
+		u += "&" + query
+	}
+	return u
+}
+
+// escape escapes each segment of the blob name, keeping the separators
+func (s *AzureSink) escape(name string) string {
+	segments := strings.Split(name, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+// do sends the request, returning an error if it did not succeed
+func (s *AzureSink) do(method, u string, body io.Reader, header map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureVersion)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Azure %s %s returned %s", method, req.URL.Path, resp.Status)
+	}
+	return resp, nil
+}
+
+// Location returns the azure:// URI of the named backup
+func (s *AzureSink) Location(name string) string {
+	return fmt.Sprintf("azure://%s/%s/%s%s", s.Account, s.Container, s.Prefix, name)
+}
+
+type azureBlockList struct {
+	XMLName xml.Name `xml:"BlockList"`
+	Latest  []string `xml:"Latest"`
+}
+
+// Upload streams r to the named backup as a series of blocks, committed
+// once they have all been uploaded, so the backup does not need to fit in
+// memory or on disk. It fails as soon as the backup needs more blocks than
+// a blob may have
+func (s *AzureSink) Upload(name string, r io.Reader) error {
+	list := azureBlockList{}
+	buf := make([]byte, s.BlockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if len(list.Latest) == s.maxBlocks {
+				return fmt.Errorf("Backup %s is larger than the %d blocks of %d bytes an Azure blob may have, raise blockSizeMiB",
+					name, s.maxBlocks, s.BlockSize)
+			}
+			// Block IDs must all be the same length
+			id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", len(list.Latest))))
+			query := "comp=block&blockid=" + url.QueryEscape(id)
+			resp, err := s.do("PUT", s.blobURL(name, query), bytes.NewReader(buf[:n]), nil)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			list.Latest = append(list.Latest, id)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	body, err := xml.Marshal(list)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do("PUT", s.blobURL(name, "comp=blocklist"), bytes.NewReader(body), map[string]string{
+		"Content-Type": "application/xml",
+	})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Open returns the body of the named backup, which is streamed as it is read
+func (s *AzureSink) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", s.blobURL(name, ""), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes the named backup from the container
+func (s *AzureSink) Delete(name string) error {
+	resp, err := s.do("DELETE", s.blobURL(name, ""), nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,139 @@
+package driver
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+)
+
+// fakeAzure emulates the parts of the Blob service used by AzureSink,
+// checking every request carries the signature
+func fakeAzure(t *testing.T, blobs map[string]string) *httptest.Server {
+	blocks := map[string]string{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("sig") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/testcontainer/")
+		switch {
+		case r.Method == "PUT" && q.Get("comp") == "block":
+			b, _ := ioutil.ReadAll(r.Body)
+			blocks[q.Get("blockid")] = string(b)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "PUT" && q.Get("comp") == "blocklist":
+			list := azureBlockList{}
+			if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+				t.Errorf("Invalid block list: %s", err)
+			}
+			blob := ""
+			for _, id := range list.Latest {
+				blob += blocks[id]
+			}
+			blobs[name] = blob
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "GET":
+			blob, ok := blobs[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(blob))
+		case r.Method == "DELETE":
+			delete(blobs, name)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestAzureSink(t *testing.T) {
+	blobs := map[string]string{}
+	server := fakeAzure(t, blobs)
+	defer server.Close()
+	sink := NewAzureSink(dbv1alpha1.AzureBackup{
+		Account:   "testaccount",
+		Container: "testcontainer",
+		Endpoint:  server.URL,
+	}, "?sv=2018-03-28&sig=secret")
+	// Small blocks so the backup is uploaded in several
+	sink.BlockSize = 3
+	if location := sink.Location("testdb/testbackup"); location != "azure://testaccount/testcontainer/testdb/testbackup" {
+		t.Errorf("Location incorrect: %s", location)
+	}
+	if err := sink.Upload("testdb/testbackup", strings.NewReader("database dump")); err != nil {
+		t.Fatalf("Upload threw unexpected error: %s", err)
+	}
+	if blobs["testdb/testbackup"] != "database dump" {
+		t.Fatalf("Backup not uploaded: %v", blobs)
+	}
+	r, err := sink.Open("testdb/testbackup")
+	if err != nil {
+		t.Fatalf("Open threw unexpected error: %s", err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(b) != "database dump" {
+		t.Errorf("Backup read incorrectly: %s", b)
+	}
+	if err := sink.Delete("testdb/testbackup"); err != nil {
+		t.Fatalf("Delete threw unexpected error: %s", err)
+	}
+	if len(blobs) != 0 {
+		t.Errorf("Backup not deleted: %v", blobs)
+	}
+}
+
+func TestAzureSinkUnauthorised(t *testing.T) {
+	server := fakeAzure(t, map[string]string{})
+	defer server.Close()
+	sink := NewAzureSink(dbv1alpha1.AzureBackup{
+		Account:   "testaccount",
+		Container: "testcontainer",
+		Endpoint:  server.URL,
+	}, "sig=wrong")
+	if err := sink.Upload("testdb/testbackup", strings.NewReader("dump")); err == nil {
+		t.Errorf("Expected error uploading without a valid signature")
+	}
+}
+
+func TestAzureSinkBlockSize(t *testing.T) {
+	sink := NewAzureSink(dbv1alpha1.AzureBackup{Account: "testaccount", BlockSizeMiB: 16}, "")
+	if sink.BlockSize != 16*1024*1024 {
+		t.Errorf("Block size not configured, got %d", sink.BlockSize)
+	}
+	p := &Container{}
+	if _, err := p.newAzureSink(dbv1alpha1.AzureBackup{BlockSizeMiB: 101}); err == nil {
+		t.Errorf("Expected error for a block size Azure does not accept")
+	}
+}
+
+func TestAzureSinkTooManyBlocks(t *testing.T) {
+	blobs := map[string]string{}
+	server := fakeAzure(t, blobs)
+	defer server.Close()
+	sink := NewAzureSink(dbv1alpha1.AzureBackup{
+		Account:   "testaccount",
+		Container: "testcontainer",
+		Endpoint:  server.URL,
+	}, "sig=secret")
+	sink.BlockSize = 4
+	sink.maxBlocks = 2
+	if err := sink.Upload("testdb/testbackup", strings.NewReader("12345678")); err != nil {
+		t.Fatalf("Upload of exactly the most blocks threw unexpected error: %s", err)
+	}
+	err := sink.Upload("testdb/toolarge", strings.NewReader("123456789"))
+	if err == nil || !strings.Contains(err.Error(), "blockSizeMiB") {
+		t.Errorf("Expected error uploading more blocks than a blob may have, got %v", err)
+	}
+	if _, ok := blobs["testdb/toolarge"]; ok {
+		t.Errorf("Blob committed despite exceeding the block limit")
+	}
+}
@@ -0,0 +1,156 @@
+package driver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// memorySink keeps uploaded backups in memory
+type memorySink struct {
+	objects map[string]string
+	err     error
+}
+
+func (m *memorySink) Location(name string) string {
+	return "memory://" + name
+}
+
+func (m *memorySink) Upload(name string, r io.Reader) error {
+	if m.err != nil {
+		return m.err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.objects[name] = string(b)
+	return nil
+}
+
+func (m *memorySink) Open(name string) (io.ReadCloser, error) {
+	object, ok := m.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", name)
+	}
+	return ioutil.NopCloser(strings.NewReader(object)), nil
+}
+
+func (m *memorySink) Delete(name string) error {
+	if _, ok := m.objects[name]; !ok {
+		return fmt.Errorf("%s not found", name)
+	}
+	delete(m.objects, name)
+	return nil
+}
+
+func testBackup() *dbv1alpha1.Backup {
+	return &dbv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{Name: "testbackup", Namespace: "testns"},
+		Spec:       dbv1alpha1.BackupSpec{Database: "testdb"},
+	}
+}
+
+func backupContainer(t *testing.T, f *fakeDriver, sink *memorySink, phase dbv1alpha1.DatabasePhase) *Container {
+	p := fakeContainer(f, util.BackupOperation, testDatabase(phase), testBackup())
+	p.Database = ""
+	p.Backup = "testbackup"
+	p.sinkFor = func(*dbv1alpha1.Database) (Sink, error) { return sink, nil }
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	return p
+}
+
+func storedBackup(t *testing.T, p *Container) *dbv1alpha1.Backup {
+	backup := &dbv1alpha1.Backup{}
+	key := types.NamespacedName{Namespace: "testns", Name: "testbackup"}
+	if err := p.k8sclient.Get(context.TODO(), key, backup); err != nil {
+		t.Fatalf("Unable to get backup: %s", err)
+	}
+	return backup
+}
+
+func TestReconcileBackup(t *testing.T) {
+	f := &fakeDriver{}
+	sink := &memorySink{objects: map[string]string{}}
+	p := backupContainer(t, f, sink, dbv1alpha1.BackupBeforeDeleteRequested)
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if sink.objects["testdb/testbackup"] != "dump of testdb" {
+		t.Errorf("Backup not uploaded, got %v", sink.objects)
+	}
+	backup := storedBackup(t, p)
+	if backup.Status.Phase != dbv1alpha1.Completed {
+		t.Errorf("Backup not completed, phase is %s", backup.Status.Phase)
+	}
+	if backup.Status.Destination != "memory://testdb/testbackup" {
+		t.Errorf("Destination not recorded, got %q", backup.Status.Destination)
+	}
+	if backup.Status.BackupTo == nil || backup.Status.BackupToNamespace != "testns" {
+		t.Errorf("Destination configuration not recorded")
+	}
+	sum := sha256.Sum256([]byte("dump of testdb"))
+	if backup.Status.Size != 14 || backup.Status.Checksum != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("Size and checksum incorrect, got %d %s", backup.Status.Size, backup.Status.Checksum)
+	}
+	if backup.Status.StartTime == nil || backup.Status.CompletionTime == nil {
+		t.Errorf("Timings not recorded")
+	}
+	if backup.Status.Driver != "fake" || backup.Status.DriverVersion != "1.0" || backup.Status.ServerVersion != "fake 9.6" {
+		t.Errorf("Driver not recorded, got %s %s %s", backup.Status.Driver, backup.Status.DriverVersion, backup.Status.ServerVersion)
+	}
+	if phase := storedPhase(t, p); phase != dbv1alpha1.BackupBeforeDeleteCompleted {
+		t.Errorf("Database phase not completed, got %s", phase)
+	}
+}
+
+func TestReconcileBackupLeavesCreatedDatabase(t *testing.T) {
+	f := &fakeDriver{}
+	sink := &memorySink{objects: map[string]string{}}
+	p := backupContainer(t, f, sink, dbv1alpha1.Created)
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if phase := storedPhase(t, p); phase != dbv1alpha1.Created {
+		t.Errorf("Database phase changed to %s", phase)
+	}
+}
+
+func TestReconcileBackupUploadFailure(t *testing.T) {
+	f := &fakeDriver{}
+	sink := &memorySink{err: fmt.Errorf("access denied")}
+	p := backupContainer(t, f, sink, dbv1alpha1.BackupBeforeDeleteRequested)
+	if err := p.reconcile(); err == nil {
+		t.Fatalf("Upload error not returned")
+	}
+	if phase := storedBackup(t, p).Status.Phase; phase != dbv1alpha1.BackingUp {
+		t.Errorf("Failed backup marked %s", phase)
+	}
+	if phase := storedPhase(t, p); phase != dbv1alpha1.BackupBeforeDeleteInProgress {
+		t.Errorf("Database phase should be in progress, got %s", phase)
+	}
+}
+
+func TestReconcileBackupDriverFailure(t *testing.T) {
+	f := &fakeDriver{err: fmt.Errorf("pg_dump failed")}
+	sink := &memorySink{objects: map[string]string{}}
+	p := backupContainer(t, f, sink, dbv1alpha1.Created)
+	if err := p.reconcile(); err == nil {
+		t.Fatalf("Driver error not returned")
+	}
+	if phase := storedBackup(t, p).Status.Phase; phase != dbv1alpha1.BackingUp {
+		t.Errorf("Failed backup marked %s", phase)
+	}
+}
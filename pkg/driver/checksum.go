@@ -0,0 +1,59 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+)
+
+// digestReader counts and hashes everything read through it
+type digestReader struct {
+	r    io.Reader
+	hash hash.Hash
+	size int64
+	// onRead is called with the size read so far, if set
+	onRead func(size int64)
+}
+
+func newDigestReader(r io.Reader) *digestReader {
+	return &digestReader{r: r, hash: sha256.New()}
+}
+
+func (d *digestReader) Read(b []byte) (int, error) {
+	n, err := d.r.Read(b)
+	d.hash.Write(b[:n])
+	d.size += int64(n)
+	if d.onRead != nil {
+		d.onRead(d.size)
+	}
+	return n, err
+}
+
+// Checksum returns the checksum of everything read so far
+func (d *digestReader) Checksum() string {
+	return "sha256:" + hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// verify reads the stored backup through, returning an error if its size
+// or checksum differ from those recorded when it was made
+func verify(sink Sink, name string, status dbv1alpha1.BackupStatus) error {
+	r, err := sink.Open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	d := newDigestReader(r)
+	if _, err := io.Copy(ioutil.Discard, d); err != nil {
+		return err
+	}
+	if d.size != status.Size || d.Checksum() != status.Checksum {
+		return fmt.Errorf("Backup at %s is %d bytes with checksum %s, expected %d bytes with checksum %s",
+			status.Destination, d.size, d.Checksum(), status.Size, status.Checksum)
+	}
+	return nil
+}
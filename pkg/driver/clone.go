@@ -0,0 +1,116 @@
+package driver
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+)
+
+// sourceContainer returns a container for the database being cloned. Its
+// credentials are read as if from its own namespace, which it must allow
+// us to clone from, as must the instance it is on
+func (p *Container) sourceContainer() (*Container, error) {
+	ref := p.database.Spec.Source.Database
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = p.Namespace
+	}
+	source := &Container{
+		k8sclient:      p.k8sclient,
+		Namespace:      namespace,
+		Database:       ref.Name,
+		drivers:        p.drivers,
+		secretBackends: p.secretBackends,
+		vault:          p.vault,
+		recorder:       p.recorder,
+	}
+	if err := source.load(); err != nil {
+		return nil, err
+	}
+	if !util.AllowsNamespace(&source.database, p.Namespace) {
+		return nil, fmt.Errorf("Source database %s/%s does not allow cloning into namespace %s", namespace, ref.Name, p.Namespace)
+	}
+	if source.instance != nil && !util.AllowsNamespace(source.instance, p.Namespace) {
+		return nil, fmt.Errorf("Instance %s/%s of source database %s/%s does not allow cloning into namespace %s", source.instance.Namespace, source.instance.Name, namespace, ref.Name, p.Namespace)
+	}
+	if source.database.Spec.Provider != p.database.Spec.Provider {
+		return nil, fmt.Errorf("Source database %s/%s uses provider %s, not %s", namespace, ref.Name, source.database.Spec.Provider, p.database.Spec.Provider)
+	}
+	return source, nil
+}
+
+// pipe copies the source database into the target by restoring a backup of
+// the source as it is made. The target is recreated first, to clear out
+// anything left by an earlier, interrupted, attempt
+func (p *Container) pipe(target, source *Driver) error {
+	if source.Backup == nil || target.Restore == nil {
+		return fmt.Errorf("Driver %s does not support cloning, backups or restores", target.Name)
+	}
+	if err := p.recreate(target); err != nil {
+		return err
+	}
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		out := io.Writer(w)
+		err := source.Backup(source, &out)
+		w.CloseWithError(err)
+		done <- err
+	}()
+	d := newDigestReader(r)
+	if p.progress != nil {
+		d.onRead = func(size int64) { p.progress.count(size, 0) }
+	}
+	err := target.Restore(target, d)
+	// The backup is stopped if the restore gave up before reading it all
+	r.CloseWithError(fmt.Errorf("Restore of %s has finished", target.Database.Username))
+	backupErr := <-done
+	if err != nil {
+		return err
+	}
+	return backupErr
+}
+
+// reconcileClone populates a newly created database from the database it
+// is a clone of. The driver's own Clone is used if the two are on the same
+// server, which for many databases is much faster
+func (p *Container) reconcileClone() error {
+	if p.database.Populated() {
+		log.Info("Database already populated", "Source", p.database.Status.PopulatedFrom)
+		return nil
+	}
+	if p.database.Status.Phase != dbv1alpha1.Populating {
+		return fmt.Errorf("Database %s is %s, not Populating", p.database.Name, p.database.Status.Phase)
+	}
+	if p.database.Spec.Source.Database == nil {
+		return fmt.Errorf("Database %s is not a clone of another database", p.database.Name)
+	}
+	source, err := p.sourceContainer()
+	if err != nil {
+		return err
+	}
+	from, err := source.getDriver()
+	if err != nil {
+		return err
+	}
+	from.progress = p.progress
+	driver, err := p.getDriver()
+	if err != nil {
+		return err
+	}
+	if driver.Clone != nil && reflect.DeepEqual(driver.Connect, from.Connect) {
+		log.Info("Cloning database", "Source", source.database.Name)
+		err = driver.Clone(driver, from)
+	} else {
+		log.Info("Copying database", "Source", source.database.Name)
+		err = p.pipe(driver, from)
+	}
+	if err != nil {
+		return err
+	}
+	p.database.Status.PopulatedFrom = "database/" + source.Namespace + "/" + source.database.Name
+	return p.updateDatabasePhase(dbv1alpha1.Created)
+}
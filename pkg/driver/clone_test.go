@@ -0,0 +1,110 @@
+package driver
+
+import (
+	"reflect"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+)
+
+func cloneContainer(t *testing.T, f *fakeDriver, allow string) *Container {
+	target := testDatabase(dbv1alpha1.Populating)
+	target.Spec.Source = &dbv1alpha1.DatabaseSource{
+		Database: &dbv1alpha1.DatabaseRef{Name: "sourcedb", Namespace: "otherns"},
+	}
+	source := testDatabase(dbv1alpha1.Created)
+	source.Name = "sourcedb"
+	source.Namespace = "otherns"
+	source.Spec.Name = "sourcedb"
+	source.Annotations = map[string]string{dbv1alpha1.AllowNamespacesAnnotation: allow}
+	p := fakeContainer(f, util.CloneOperation, target, source)
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	return p
+}
+
+func TestReconcileClonePipe(t *testing.T) {
+	f := &fakeDriver{}
+	p := cloneContainer(t, f, "testns")
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("reconcile threw unexpected error: %s", err)
+	}
+	expected := []string{"drop", "create", "backup", "restore dump of sourcedb"}
+	if !reflect.DeepEqual(f.calls, expected) {
+		t.Errorf("Expected calls %v, got %v", expected, f.calls)
+	}
+	if phase := storedPhase(t, p); phase != dbv1alpha1.Created {
+		t.Errorf("Expected Created, got %s", phase)
+	}
+	if p.database.Status.PopulatedFrom != "database/otherns/sourcedb" {
+		t.Errorf("Source not recorded, got %q", p.database.Status.PopulatedFrom)
+	}
+}
+
+func TestReconcileCloneNative(t *testing.T) {
+	f := &fakeDriver{}
+	p := cloneContainer(t, f, "*")
+	p.drivers["fake"].Clone = func(target *Driver, source *Driver) error {
+		f.calls = append(f.calls, "clone "+source.Database.Username+" to "+target.Database.Username)
+		return nil
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("reconcile threw unexpected error: %s", err)
+	}
+	expected := []string{"clone sourcedb to testdb"}
+	if !reflect.DeepEqual(f.calls, expected) {
+		t.Errorf("Expected calls %v, got %v", expected, f.calls)
+	}
+}
+
+func TestReconcileCloneNotAllowed(t *testing.T) {
+	f := &fakeDriver{}
+	p := cloneContainer(t, f, "preview")
+	if err := p.reconcile(); err == nil {
+		t.Errorf("Expected error cloning from a namespace that does not allow it")
+	}
+	if len(f.calls) != 0 {
+		t.Errorf("Expected no calls, got %v", f.calls)
+	}
+}
+
+func TestReconcileCloneInstanceNotAllowed(t *testing.T) {
+	f := &fakeDriver{}
+	target := testDatabase(dbv1alpha1.Populating)
+	target.Spec.Source = &dbv1alpha1.DatabaseSource{
+		Database: &dbv1alpha1.DatabaseRef{Name: "sourcedb", Namespace: "otherns"},
+	}
+	source := databaseOnInstance()
+	source.Name = "sourcedb"
+	source.Namespace = "otherns"
+	source.Annotations = map[string]string{dbv1alpha1.AllowNamespacesAnnotation: "testns"}
+	// The instance allows the source's namespace, but not the target's
+	p := fakeContainer(f, util.CloneOperation, target, source, testInstance("otherns"), testSecret("dbas", ""))
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcile(); err == nil {
+		t.Errorf("Expected error cloning from an instance that does not allow it")
+	}
+	if len(f.calls) != 0 {
+		t.Errorf("Expected no calls, got %v", f.calls)
+	}
+}
+
+func TestCreatePopulating(t *testing.T) {
+	f := &fakeDriver{}
+	db := testDatabase("")
+	db.Spec.Source = &dbv1alpha1.DatabaseSource{Backup: "testbackup"}
+	p := fakeContainer(f, util.CreateOperation, db)
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("reconcile threw unexpected error: %s", err)
+	}
+	if phase := storedPhase(t, p); phase != dbv1alpha1.Populating {
+		t.Errorf("Expected Populating, got %s", phase)
+	}
+}
@@ -0,0 +1,164 @@
+package driver
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/klauspost/compress/zstd"
+)
+
+// recipients returns the age recipients that backups are encrypted to
+func (p *Container) recipients(enc *dbv1alpha1.Encryption) ([]age.Recipient, error) {
+	if enc.Passphrase != nil {
+		if len(enc.Recipients) > 0 {
+			return nil, fmt.Errorf("Only one of recipients and passphrase may be given")
+		}
+		passphrase, err := p.getBackupCredential(*enc.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{recipient}, nil
+	}
+	if len(enc.Recipients) == 0 {
+		return nil, fmt.Errorf("No recipients or passphrase given for encryption")
+	}
+	return age.ParseRecipients(strings.NewReader(strings.Join(enc.Recipients, "\n")))
+}
+
+// identities returns the age identities that backups are decrypted with
+func (p *Container) identities(enc *dbv1alpha1.Encryption) ([]age.Identity, error) {
+	if enc.Passphrase != nil {
+		passphrase, err := p.getBackupCredential(*enc.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Identity{identity}, nil
+	}
+	if enc.Identities == nil {
+		return nil, fmt.Errorf("No identities or passphrase given for decryption")
+	}
+	identities, err := p.getBackupCredential(*enc.Identities)
+	if err != nil {
+		return nil, err
+	}
+	return age.ParseIdentities(strings.NewReader(identities))
+}
+
+// encoder compresses and then encrypts everything written to it before
+// writing it on. Closing it flushes everything through, without closing
+// the underlying writer
+type encoder struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (e *encoder) Close() error {
+	for _, c := range e.closers {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode returns a writer encoding backups written to it onto w. Without
+// compression or recipients they are written as they are
+func encode(w io.Writer, compression dbv1alpha1.Compression, recipients []age.Recipient) (io.WriteCloser, error) {
+	e := &encoder{Writer: w}
+	if len(recipients) > 0 {
+		encrypted, err := age.Encrypt(e.Writer, recipients...)
+		if err != nil {
+			return nil, err
+		}
+		e.Writer = encrypted
+		e.closers = append([]io.Closer{encrypted}, e.closers...)
+	}
+	switch compression {
+	case "":
+	case dbv1alpha1.GzipCompression:
+		compressed := gzip.NewWriter(e.Writer)
+		e.Writer = compressed
+		e.closers = append([]io.Closer{compressed}, e.closers...)
+	case dbv1alpha1.ZstdCompression:
+		compressed, err := zstd.NewWriter(e.Writer)
+		if err != nil {
+			return nil, err
+		}
+		e.Writer = compressed
+		e.closers = append([]io.Closer{compressed}, e.closers...)
+	default:
+		return nil, fmt.Errorf("Unsupported compression %s", compression)
+	}
+	return e, nil
+}
+
+// decoder reads a backup, closing the decompressor and the source when it
+// is closed
+type decoder struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decoder) Close() error {
+	var err error
+	for _, c := range d.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// decode returns a reader of the backup read from r, reversing the
+// compression and encryption recorded in its status
+func decode(r io.ReadCloser, status dbv1alpha1.BackupStatus, identities []age.Identity) (io.ReadCloser, error) {
+	d := &decoder{Reader: r, closers: []io.Closer{r}}
+	switch status.Encryption {
+	case "":
+	case dbv1alpha1.AgeEncryption:
+		decrypted, err := age.Decrypt(d.Reader, identities...)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		d.Reader = decrypted
+	default:
+		r.Close()
+		return nil, fmt.Errorf("Unsupported encryption %s", status.Encryption)
+	}
+	switch status.Compression {
+	case "":
+	case dbv1alpha1.GzipCompression:
+		decompressed, err := gzip.NewReader(d.Reader)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		d.Reader = decompressed
+		d.closers = append([]io.Closer{decompressed}, d.closers...)
+	case dbv1alpha1.ZstdCompression:
+		decompressed, err := zstd.NewReader(d.Reader)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		d.Reader = decompressed
+		d.closers = append([]io.Closer{decompressed.IOReadCloser()}, d.closers...)
+	default:
+		r.Close()
+		return nil, fmt.Errorf("Unsupported compression %s", status.Compression)
+	}
+	return d, nil
+}
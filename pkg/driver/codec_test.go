@@ -0,0 +1,133 @@
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func roundTrip(t *testing.T, status dbv1alpha1.BackupStatus, recipients []age.Recipient, identities []age.Identity) {
+	data := strings.Repeat("dump of testdb\n", 1000)
+	buf := &bytes.Buffer{}
+	w, err := encode(buf, status.Compression, recipients)
+	if err != nil {
+		t.Fatalf("encode threw unexpected error: %s", err)
+	}
+	w.Write([]byte(data))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close threw unexpected error: %s", err)
+	}
+	if buf.String() == data && (status.Compression != "" || len(recipients) > 0) {
+		t.Errorf("Backup was not encoded with %v", status)
+	}
+	r, err := decode(ioutil.NopCloser(buf), status, identities)
+	if err != nil {
+		t.Fatalf("decode threw unexpected error: %s", err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Read threw unexpected error: %s", err)
+	}
+	r.Close()
+	if string(b) != data {
+		t.Errorf("Backup not decoded with %v", status)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Unable to generate identity: %s", err)
+	}
+	recipients := []age.Recipient{identity.Recipient()}
+	identities := []age.Identity{identity}
+	for _, compression := range []dbv1alpha1.Compression{"", dbv1alpha1.GzipCompression, dbv1alpha1.ZstdCompression} {
+		roundTrip(t, dbv1alpha1.BackupStatus{Compression: compression}, nil, nil)
+		roundTrip(t, dbv1alpha1.BackupStatus{Compression: compression, Encryption: dbv1alpha1.AgeEncryption}, recipients, identities)
+	}
+}
+
+func TestUnsupportedCompression(t *testing.T) {
+	if _, err := encode(&bytes.Buffer{}, "lzma", nil); err == nil {
+		t.Errorf("Expected error for unsupported compression")
+	}
+}
+
+func TestEncryptionKeys(t *testing.T) {
+	identity, _ := age.GenerateX25519Identity()
+	p := &Container{}
+	enc := &dbv1alpha1.Encryption{
+		Recipients: []string{identity.Recipient().String()},
+		Identities: &dbv1alpha1.Credential{Value: "# backup key\n" + identity.String()},
+	}
+	if recipients, err := p.recipients(enc); err != nil || len(recipients) != 1 {
+		t.Errorf("Recipients not parsed: %v %v", recipients, err)
+	}
+	if identities, err := p.identities(enc); err != nil || len(identities) != 1 {
+		t.Errorf("Identities not parsed: %v %v", identities, err)
+	}
+	enc.Passphrase = &dbv1alpha1.Credential{Value: "secret"}
+	if _, err := p.recipients(enc); err == nil {
+		t.Errorf("Expected error with both recipients and passphrase")
+	}
+	if _, err := p.recipients(&dbv1alpha1.Encryption{}); err == nil {
+		t.Errorf("Expected error with no recipients or passphrase")
+	}
+}
+
+// TestEncryptedBackupAndRestore backs up with compression and a passphrase,
+// and restores using the codecs recorded on the backup
+func TestEncryptedBackupAndRestore(t *testing.T) {
+	f := &fakeDriver{}
+	sink := &memorySink{objects: map[string]string{}}
+	database := testDatabase(dbv1alpha1.Created)
+	database.Spec.BackupTo.Compression = dbv1alpha1.ZstdCompression
+	database.Spec.BackupTo.Encryption = &dbv1alpha1.Encryption{
+		Passphrase: &dbv1alpha1.Credential{Value: "correct horse battery staple"},
+	}
+
+	p := fakeContainer(f, util.BackupOperation, database, testBackup())
+	p.Database = ""
+	p.Backup = "testbackup"
+	p.sinkFor = func(*dbv1alpha1.Database) (Sink, error) { return sink, nil }
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("Backup threw unexpected error: %s", err)
+	}
+	backup := storedBackup(t, p)
+	if backup.Status.Compression != dbv1alpha1.ZstdCompression || backup.Status.Encryption != dbv1alpha1.AgeEncryption {
+		t.Errorf("Codecs not recorded: %v", backup.Status)
+	}
+	if strings.Contains(sink.objects["testdb/testbackup"], "dump of") {
+		t.Fatalf("Backup stored in plaintext")
+	}
+
+	restore := &dbv1alpha1.Restore{
+		ObjectMeta: metav1.ObjectMeta{Name: "testrestore", Namespace: "testns"},
+		Spec:       dbv1alpha1.RestoreSpec{Backup: "testbackup", Database: "testdb"},
+	}
+	p = fakeContainer(f, util.RestoreOperation, database, backup, restore)
+	p.Database = ""
+	p.Restore = "testrestore"
+	p.sinkHolding = func(string, *dbv1alpha1.Database) (Sink, string, error) {
+		return sink, "testdb/testbackup", nil
+	}
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("Restore threw unexpected error: %s", err)
+	}
+	if fmt.Sprint(f.calls) != "[backup restore dump of testdb]" {
+		t.Errorf("Unexpected driver calls %v", f.calls)
+	}
+}
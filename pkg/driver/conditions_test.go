@@ -0,0 +1,56 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func storedCondition(t *testing.T, p *Container, c dbv1alpha1.ConditionType) *dbv1alpha1.Condition {
+	db := &dbv1alpha1.Database{}
+	key := types.NamespacedName{Namespace: "testns", Name: "testdb"}
+	if err := p.k8sclient.Get(context.TODO(), key, db); err != nil {
+		t.Fatalf("Unable to get database: %s", err)
+	}
+	return util.FindCondition(db.Status.Conditions, c)
+}
+
+func TestCreateSetsConditions(t *testing.T) {
+	p := fakeContainer(&fakeDriver{}, util.CreateOperation, testDatabase(""))
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("reconcile threw unexpected error: %s", err)
+	}
+	ready := storedCondition(t, p, dbv1alpha1.ConditionReady)
+	progressing := storedCondition(t, p, dbv1alpha1.ConditionProgressing)
+	if ready == nil || ready.Status != dbv1alpha1.ConditionTrue {
+		t.Errorf("Database not Ready: %v", ready)
+	}
+	if progressing == nil || progressing.Status != dbv1alpha1.ConditionFalse {
+		t.Errorf("Database still Progressing: %v", progressing)
+	}
+}
+
+func TestRecordResult(t *testing.T) {
+	p := fakeContainer(&fakeDriver{}, util.CreateOperation, testDatabase(dbv1alpha1.Creating))
+	if err := p.recordResult(fmt.Errorf("Connection refused")); err != nil {
+		t.Fatalf("recordResult threw unexpected error: %s", err)
+	}
+	degraded := storedCondition(t, p, dbv1alpha1.ConditionDegraded)
+	if degraded == nil || degraded.Status != dbv1alpha1.ConditionTrue || degraded.Message != "Connection refused" {
+		t.Fatalf("Failure not recorded: %v", degraded)
+	}
+	if err := p.recordResult(nil); err != nil {
+		t.Fatalf("recordResult threw unexpected error: %s", err)
+	}
+	degraded = storedCondition(t, p, dbv1alpha1.ConditionDegraded)
+	if degraded.Status != dbv1alpha1.ConditionFalse {
+		t.Errorf("Failure not cleared: %v", degraded)
+	}
+}
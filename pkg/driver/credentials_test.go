@@ -0,0 +1,78 @@
+package driver
+
+import (
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testSecret(namespace, allow string) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "dbpassword", Namespace: namespace},
+		Data: map[string][]byte{
+			"password": []byte("hunter2"),
+		},
+	}
+	if allow != "" {
+		secret.Annotations = map[string]string{dbv1alpha1.AllowNamespacesAnnotation: allow}
+	}
+	return secret
+}
+
+func secretCredential(namespace, key string) dbv1alpha1.Credential {
+	return dbv1alpha1.Credential{
+		ValueFrom: dbv1alpha1.ValueFrom{
+			SecretKeyRef: dbv1alpha1.SecretKeyRef{Name: "dbpassword", Key: key, Namespace: namespace},
+		},
+	}
+}
+
+func TestGetCredentialValue(t *testing.T) {
+	p := fakeContainer(&fakeDriver{}, util.CreateOperation, testDatabase(""))
+	value, err := p.getCredential(dbv1alpha1.Credential{Value: "postgres"})
+	if err != nil || value != "postgres" {
+		t.Errorf("Literal value not returned, got %q, %v", value, err)
+	}
+}
+
+func TestGetCredentialNone(t *testing.T) {
+	p := fakeContainer(&fakeDriver{}, util.CreateOperation, testDatabase(""))
+	if _, err := p.getCredential(dbv1alpha1.Credential{}); err == nil {
+		t.Errorf("No error for an empty credential")
+	}
+}
+
+func TestGetCredentialSecret(t *testing.T) {
+	cases := []struct {
+		name      string
+		secret    *corev1.Secret
+		cred      dbv1alpha1.Credential
+		value     string
+		shouldErr bool
+	}{
+		{"same namespace", testSecret("testns", ""), secretCredential("", "password"), "hunter2", false},
+		{"explicit same namespace", testSecret("testns", ""), secretCredential("testns", "password"), "hunter2", false},
+		{"missing key", testSecret("testns", ""), secretCredential("", "username"), "", true},
+		{"missing secret", testSecret("otherns", ""), secretCredential("", "password"), "", true},
+		{"cross namespace denied", testSecret("otherns", ""), secretCredential("otherns", "password"), "", true},
+		{"cross namespace other allowed", testSecret("otherns", "thirdns"), secretCredential("otherns", "password"), "", true},
+		{"cross namespace allowed", testSecret("otherns", "thirdns, testns"), secretCredential("otherns", "password"), "hunter2", false},
+		{"cross namespace wildcard", testSecret("otherns", "*"), secretCredential("otherns", "password"), "hunter2", false},
+	}
+	for _, c := range cases {
+		p := fakeContainer(&fakeDriver{}, util.CreateOperation, testDatabase(""), c.secret)
+		value, err := p.getCredential(c.cred)
+		if c.shouldErr && err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+		if !c.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+		}
+		if value != c.value {
+			t.Errorf("%s: expected %q, got %q", c.name, c.value, value)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEventsCredentialsFailed(t *testing.T) {
+	db := testDatabase(dbv1alpha1.Creating)
+	db.Spec.Credentials.Password = dbv1alpha1.Credential{
+		ValueFrom: dbv1alpha1.ValueFrom{SecretKeyRef: dbv1alpha1.SecretKeyRef{Name: "missing", Key: "password"}},
+	}
+	p := fakeContainer(&fakeDriver{}, util.CreateOperation, db)
+	recorder := record.NewFakeRecorder(10)
+	p.recorder = recorder
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcile(); err == nil {
+		t.Fatalf("Expected error reading a missing secret")
+	}
+	if e := <-recorder.Events; !strings.HasPrefix(e, "Warning CredentialsFailed ") {
+		t.Errorf("Expected a CredentialsFailed warning, got %s", e)
+	}
+}
+
+func TestEventsDriverError(t *testing.T) {
+	p := fakeContainer(&fakeDriver{}, util.CreateOperation, testDatabase(dbv1alpha1.Creating))
+	recorder := record.NewFakeRecorder(10)
+	p.recorder = recorder
+	if err := p.recordResult(fmt.Errorf("Connection refused")); err != nil {
+		t.Fatalf("recordResult threw unexpected error: %s", err)
+	}
+	if e := <-recorder.Events; e != "Warning DriverError Driver create failed: Connection refused" {
+		t.Errorf("Expected a DriverError warning, got %s", e)
+	}
+}
+
+func TestEventsPhaseChanged(t *testing.T) {
+	p := fakeContainer(&fakeDriver{}, util.CreateOperation, testDatabase(dbv1alpha1.Creating))
+	recorder := record.NewFakeRecorder(10)
+	p.recorder = recorder
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("reconcile threw unexpected error: %s", err)
+	}
+	if e := <-recorder.Events; e != "Normal Created Database is Created" {
+		t.Errorf("Expected a Created event, got %s", e)
+	}
+}
@@ -0,0 +1,89 @@
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+)
+
+// FileSink writes backups to files on a PersistentVolumeClaim mounted into
+// the driver job
+type FileSink struct {
+	Claim string
+	// Root is where the claim is mounted
+	Root   string
+	Prefix string
+}
+
+// NewFileSink returns a sink writing to the claim described by dest, with
+// its sub path rendered for the database
+func NewFileSink(dest dbv1alpha1.PVCBackup, database *dbv1alpha1.Database) (*FileSink, error) {
+	tmpl, err := template.New("subPath").Option("missingkey=error").Parse(dest.SubPath)
+	if err != nil {
+		return nil, err
+	}
+	prefix := &bytes.Buffer{}
+	err = tmpl.Execute(prefix, struct{ Namespace, Database string }{database.Namespace, database.Name})
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{
+		Claim:  dest.ClaimName,
+		Root:   util.ClaimPath(dest.ClaimName),
+		Prefix: prefix.String(),
+	}, nil
+}
+
+// path returns where the named backup is stored on the filesystem
+func (s *FileSink) path(name string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(s.Prefix+name))
+}
+
+// Location returns the pvc:// URI of the named backup
+func (s *FileSink) Location(name string) string {
+	return fmt.Sprintf("pvc://%s/%s%s", s.Claim, s.Prefix, name)
+}
+
+// Upload streams r to a temporary file, which is moved into place once it
+// has been written and synced, so an interrupted backup does not leave a
+// partial file behind
+func (s *FileSink) Upload(name string, r io.Reader) error {
+	path := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Open returns the file holding the named backup
+func (s *FileSink) Open(name string) (io.ReadCloser, error) {
+	return os.Open(s.path(name))
+}
+
+// Delete removes the file holding the named backup
+func (s *FileSink) Delete(name string) error {
+	return os.Remove(s.path(name))
+}
@@ -0,0 +1,88 @@
+package driver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewFileSink(t *testing.T) {
+	database := &dbv1alpha1.Database{ObjectMeta: metav1.ObjectMeta{Name: "testdb", Namespace: "testns"}}
+	sink, err := NewFileSink(dbv1alpha1.PVCBackup{ClaimName: "backups", SubPath: "{{.Namespace}}/"}, database)
+	if err != nil {
+		t.Fatalf("Unable to create sink: %s", err)
+	}
+	if sink.Root != "/var/backups/db-operator/backups" {
+		t.Errorf("Root incorrect: %s", sink.Root)
+	}
+	if location := sink.Location("testdb/testbackup"); location != "pvc://backups/testns/testdb/testbackup" {
+		t.Errorf("Location incorrect: %s", location)
+	}
+	if _, err := NewFileSink(dbv1alpha1.PVCBackup{ClaimName: "backups", SubPath: "{{.Unknown}}"}, database); err == nil {
+		t.Errorf("Expected error for unknown template field")
+	}
+}
+
+// TestFileBackupAndRestore backs up a database to a directory, and restores
+// it from the location recorded, without any external store
+func TestFileBackupAndRestore(t *testing.T) {
+	root, err := ioutil.TempDir("", "backups")
+	if err != nil {
+		t.Fatalf("Unable to create directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+	f := &fakeDriver{}
+	sink := &FileSink{Claim: "backups", Root: root, Prefix: "testns/"}
+
+	p := fakeContainer(f, util.BackupOperation, testDatabase(dbv1alpha1.Created), testBackup())
+	p.Database = ""
+	p.Backup = "testbackup"
+	p.sinkFor = func(*dbv1alpha1.Database) (Sink, error) { return sink, nil }
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("Backup threw unexpected error: %s", err)
+	}
+	backup := storedBackup(t, p)
+	if backup.Status.Destination != "pvc://backups/testns/testdb/testbackup" {
+		t.Fatalf("Destination incorrect: %s", backup.Status.Destination)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(root, "testns", "testdb", "testbackup"))
+	if err != nil || string(b) != "dump of testdb" {
+		t.Fatalf("Backup not written: %q %v", b, err)
+	}
+
+	restore := &dbv1alpha1.Restore{
+		ObjectMeta: metav1.ObjectMeta{Name: "testrestore", Namespace: "testns"},
+		Spec:       dbv1alpha1.RestoreSpec{Backup: "testbackup", Database: "testdb"},
+	}
+	p = fakeContainer(f, util.RestoreOperation, testDatabase(dbv1alpha1.Created), backup, restore)
+	p.Database = ""
+	p.Restore = "testrestore"
+	p.sinkHolding = func(location string, db *dbv1alpha1.Database) (Sink, string, error) {
+		located, name, err := p.locateBackup(location, db)
+		if err != nil {
+			return nil, "", err
+		}
+		if located.(*FileSink).Root != util.ClaimPath("backups") {
+			return nil, "", fmt.Errorf("Unexpected root %s", located.(*FileSink).Root)
+		}
+		return &FileSink{Claim: "backups", Root: root}, name, nil
+	}
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("Restore threw unexpected error: %s", err)
+	}
+	if fmt.Sprint(f.calls) != "[backup restore dump of testdb]" {
+		t.Errorf("Unexpected driver calls %v", f.calls)
+	}
+}
@@ -0,0 +1,137 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	gcsEndpoint = "https://storage.googleapis.com"
+	gcsScope    = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// GCSSink streams backups to a Google Cloud Storage bucket using its JSON
+// API
+type GCSSink struct {
+	Bucket   string
+	Prefix   string
+	Endpoint string
+	client   *http.Client
+}
+
+// NewGCSSink returns a sink writing to the bucket described by dest. The
+// service account key is used if given, and otherwise the default Google
+// credentials. No credentials are used with an emulator Endpoint
+func NewGCSSink(dest dbv1alpha1.GCSBackup, key string) (*GCSSink, error) {
+	ctx := context.Background()
+	sink := &GCSSink{
+		Bucket:   dest.Bucket,
+		Prefix:   dest.Prefix,
+		Endpoint: strings.TrimSuffix(dest.Endpoint, "/"),
+		client:   http.DefaultClient,
+	}
+	switch {
+	case key != "":
+		creds, err := google.CredentialsFromJSON(ctx, []byte(key), gcsScope)
+		if err != nil {
+			return nil, err
+		}
+		sink.client = oauth2.NewClient(ctx, creds.TokenSource)
+	case sink.Endpoint == "":
+		client, err := google.DefaultClient(ctx, gcsScope)
+		if err != nil {
+			return nil, err
+		}
+		sink.client = client
+	}
+	if sink.Endpoint == "" {
+		sink.Endpoint = gcsEndpoint
+	}
+	return sink, nil
+}
+
+// newGCSSink returns a sink for dest, reading its service account key
+func (p *Container) newGCSSink(dest dbv1alpha1.GCSBackup) (*GCSSink, error) {
+	key := ""
+	if dest.ServiceAccountKey != nil {
+		var err error
+		if key, err = p.getBackupCredential(*dest.ServiceAccountKey); err != nil {
+			return nil, err
+		}
+	}
+	return NewGCSSink(dest, key)
+}
+
+// objectURL returns the URL of the named backup's metadata
+func (s *GCSSink) objectURL(name string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", s.Endpoint, url.PathEscape(s.Bucket), url.PathEscape(s.Prefix+name))
+}
+
+// do sends the request, returning an error if it did not succeed
+func (s *GCSSink) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GCS %s %s returned %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return resp, nil
+}
+
+// Location returns the gs:// URI of the named backup
+func (s *GCSSink) Location(name string) string {
+	return fmt.Sprintf("gs://%s/%s%s", s.Bucket, s.Prefix, name)
+}
+
+// Upload streams r to the named backup in a single request, so the backup
+// does not need to fit in memory or on disk
+func (s *GCSSink) Upload(name string, r io.Reader) error {
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+		s.Endpoint, url.PathEscape(s.Bucket), url.QueryEscape(s.Prefix+name))
+	req, err := http.NewRequest("POST", u, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Open returns the body of the named backup, which is streamed as it is read
+func (s *GCSSink) Open(name string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", s.objectURL(name)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes the named backup from the bucket
+func (s *GCSSink) Delete(name string) error {
+	req, err := http.NewRequest("DELETE", s.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,84 @@
+package driver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+)
+
+// fakeGCS emulates the parts of the GCS JSON API used by GCSSink
+func fakeGCS(t *testing.T, objects map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.EscapedPath()
+		switch {
+		case r.Method == "POST" && path == "/upload/storage/v1/b/testbucket/o":
+			if r.URL.Query().Get("uploadType") != "media" {
+				t.Errorf("Unexpected upload type %s", r.URL.Query().Get("uploadType"))
+			}
+			b, _ := ioutil.ReadAll(r.Body)
+			objects[r.URL.Query().Get("name")] = string(b)
+		case strings.HasPrefix(path, "/storage/v1/b/testbucket/o/"):
+			name, _ := url.PathUnescape(strings.TrimPrefix(path, "/storage/v1/b/testbucket/o/"))
+			object, ok := objects[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			switch r.Method {
+			case "GET":
+				w.Write([]byte(object))
+			case "DELETE":
+				delete(objects, name)
+				w.WriteHeader(http.StatusNoContent)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestGCSSink(t *testing.T) {
+	objects := map[string]string{}
+	server := fakeGCS(t, objects)
+	defer server.Close()
+	sink, err := NewGCSSink(dbv1alpha1.GCSBackup{
+		Bucket:   "testbucket",
+		Prefix:   "backups/",
+		Endpoint: server.URL,
+	}, "")
+	if err != nil {
+		t.Fatalf("Unable to create sink: %s", err)
+	}
+	if location := sink.Location("testdb/testbackup"); location != "gs://testbucket/backups/testdb/testbackup" {
+		t.Errorf("Location incorrect: %s", location)
+	}
+	if err := sink.Upload("testdb/testbackup", strings.NewReader("dump")); err != nil {
+		t.Fatalf("Upload threw unexpected error: %s", err)
+	}
+	if objects["backups/testdb/testbackup"] != "dump" {
+		t.Fatalf("Backup not uploaded: %v", objects)
+	}
+	r, err := sink.Open("testdb/testbackup")
+	if err != nil {
+		t.Fatalf("Open threw unexpected error: %s", err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(b) != "dump" {
+		t.Errorf("Backup read incorrectly: %s", b)
+	}
+	if err := sink.Delete("testdb/testbackup"); err != nil {
+		t.Fatalf("Delete threw unexpected error: %s", err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("Backup not deleted: %v", objects)
+	}
+	if _, err := sink.Open("testdb/testbackup"); err == nil {
+		t.Errorf("Expected error opening missing backup")
+	}
+}
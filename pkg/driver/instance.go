@@ -0,0 +1,47 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// reconcileCheck connects to the server of the instance, and records
+// whether it was reached, and its version, in the status of the instance.
+// An unreachable server is recorded, rather than failing the job, which is
+// reserved for being unable to run the check at all
+func (p *Container) reconcileCheck() error {
+	if p.Operation != util.CheckOperation {
+		return fmt.Errorf("Unable to %s instance %s", p.Operation, p.Instance)
+	}
+	driver, err := p.getDriver()
+	if err != nil {
+		return err
+	}
+	ready := dbv1alpha1.Condition{
+		Type:               dbv1alpha1.ConditionReady,
+		ObservedGeneration: p.instance.Generation,
+	}
+	if driver.ServerVersion == nil {
+		ready.Status = dbv1alpha1.ConditionUnknown
+		ready.Reason = "NotChecked"
+		ready.Message = fmt.Sprintf("Driver %s cannot report the server version", driver.Name)
+	} else if version, err := driver.ServerVersion(driver); err != nil {
+		ready.Status = dbv1alpha1.ConditionFalse
+		ready.Reason = "Unreachable"
+		ready.Message = err.Error()
+	} else {
+		ready.Status = dbv1alpha1.ConditionTrue
+		ready.Reason = "Reachable"
+		ready.Message = fmt.Sprintf("Server version %s", version)
+		p.instance.Status.ServerVersion = version
+	}
+	log.Info("Checked instance", "Ready", ready.Status, "Reason", ready.Reason)
+	now := metav1.Now()
+	p.instance.Status.CheckedTime = &now
+	util.SetCondition(&p.instance.Status.Conditions, ready)
+	return p.k8sclient.Status().Update(context.TODO(), p.instance)
+}
@@ -0,0 +1,138 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// testInstance returns an instance in the dbas namespace, whose master
+// password is in a secret there
+func testInstance(allow string) *dbv1alpha1.DatabaseInstance {
+	instance := &dbv1alpha1.DatabaseInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: "testinstance", Namespace: "dbas"},
+		Spec: dbv1alpha1.DatabaseInstanceSpec{
+			Provider: "fake",
+			Connect:  ConnectionDetails{"host": "db.example.com"},
+			Credentials: dbv1alpha1.Credentials{
+				Username: dbv1alpha1.Credential{Value: "master"},
+				Password: secretCredential("", "password"),
+			},
+		},
+	}
+	if allow != "" {
+		instance.Annotations = map[string]string{dbv1alpha1.AllowNamespacesAnnotation: allow}
+	}
+	return instance
+}
+
+func databaseOnInstance() *dbv1alpha1.Database {
+	db := testDatabase(dbv1alpha1.Created)
+	db.Spec.Provider = ""
+	db.Spec.Credentials = dbv1alpha1.Credentials{}
+	db.Spec.Instance = &dbv1alpha1.InstanceRef{Name: "testinstance", Namespace: "dbas"}
+	return db
+}
+
+func TestLoadInstance(t *testing.T) {
+	p := fakeContainer(&fakeDriver{}, util.CreateOperation, databaseOnInstance(), testInstance("testns"), testSecret("dbas", ""))
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	driver, err := p.getDriver()
+	if err != nil {
+		t.Fatalf("getDriver threw unexpected error: %s", err)
+	}
+	if driver.Connect["host"] != "db.example.com" {
+		t.Errorf("Connection details not taken from the instance: %v", driver.Connect)
+	}
+	if driver.Master.Username != "master" || driver.Master.Password != "hunter2" {
+		t.Errorf("Master credentials not read in the namespace of the instance: %v", driver.Master)
+	}
+}
+
+func TestLoadInstanceBackupCredentials(t *testing.T) {
+	// The app team's secret of the same name is not used for the
+	// destination of the instance
+	tenant := testSecret("testns", "")
+	tenant.Data["password"] = []byte("swapped")
+	passphrase := secretCredential("", "password")
+	instance := testInstance("testns")
+	instance.Spec.BackupTo.Encryption = &dbv1alpha1.Encryption{Passphrase: &passphrase}
+	p := fakeContainer(&fakeDriver{}, util.BackupOperation, databaseOnInstance(), instance, testSecret("dbas", ""), tenant)
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	value, err := p.getBackupCredential(*p.database.Spec.BackupTo.Encryption.Passphrase)
+	if err != nil {
+		t.Fatalf("getBackupCredential threw unexpected error: %s", err)
+	}
+	if value != "hunter2" {
+		t.Errorf("Passphrase of the instance not read in its namespace, got %q", value)
+	}
+
+	// A database's own destination is read in its namespace
+	db := databaseOnInstance()
+	db.Spec.BackupTo.S3.Bucket = "app"
+	db.Spec.BackupTo.Encryption = &dbv1alpha1.Encryption{Passphrase: &passphrase}
+	p = fakeContainer(&fakeDriver{}, util.BackupOperation, db, instance, testSecret("dbas", ""), tenant)
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if value, _ := p.getBackupCredential(passphrase); value != "swapped" {
+		t.Errorf("Passphrase of the database not read in its namespace, got %q", value)
+	}
+}
+
+func TestLoadInstanceNotAllowed(t *testing.T) {
+	p := fakeContainer(&fakeDriver{}, util.CreateOperation, databaseOnInstance(), testInstance(""), testSecret("dbas", ""))
+	if err := p.load(); err == nil {
+		t.Errorf("Expected an error for an instance that does not allow the namespace")
+	}
+}
+
+func TestReconcileCheck(t *testing.T) {
+	cases := []struct {
+		version func(*Driver) (string, error)
+		status  dbv1alpha1.ConditionStatus
+		reason  string
+		server  string
+	}{
+		{func(*Driver) (string, error) { return "fake 9.6", nil }, dbv1alpha1.ConditionTrue, "Reachable", "fake 9.6"},
+		{func(*Driver) (string, error) { return "", fmt.Errorf("Connection refused") }, dbv1alpha1.ConditionFalse, "Unreachable", ""},
+		{nil, dbv1alpha1.ConditionUnknown, "NotChecked", ""},
+	}
+	for _, c := range cases {
+		p := fakeContainer(&fakeDriver{}, util.CheckOperation, testInstance(""), testSecret("dbas", ""))
+		p.Namespace = "dbas"
+		p.Database = ""
+		p.Instance = "testinstance"
+		p.drivers["fake"].ServerVersion = c.version
+		if err := p.load(); err != nil {
+			t.Fatalf("Unable to load resources: %s", err)
+		}
+		if err := p.reconcile(); err != nil {
+			t.Fatalf("reconcile threw unexpected error: %s", err)
+		}
+		stored := &dbv1alpha1.DatabaseInstance{}
+		key := types.NamespacedName{Namespace: "dbas", Name: "testinstance"}
+		if err := p.k8sclient.Get(context.TODO(), key, stored); err != nil {
+			t.Fatalf("Unable to get instance: %s", err)
+		}
+		ready := util.FindCondition(stored.Status.Conditions, dbv1alpha1.ConditionReady)
+		if ready == nil || ready.Status != c.status || ready.Reason != c.reason {
+			t.Errorf("Expected Ready %s %s, got %v", c.status, c.reason, ready)
+		}
+		if stored.Status.ServerVersion != c.server {
+			t.Errorf("Expected server version %q, got %q", c.server, stored.Status.ServerVersion)
+		}
+		if stored.Status.CheckedTime == nil {
+			t.Errorf("Check time not recorded")
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package driver
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultPasswordLength  = 32
+	defaultPasswordCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// generatePassword returns a random password following the policy
+func generatePassword(policy dbv1alpha1.PasswordPolicy) (string, error) {
+	length, charset := policy.Length, []rune(policy.Charset)
+	if length == 0 {
+		length = defaultPasswordLength
+	}
+	if len(charset) == 0 {
+		charset = []rune(defaultPasswordCharset)
+	}
+	if length < 0 {
+		return "", fmt.Errorf("Invalid password length %d", length)
+	}
+	max := big.NewInt(int64(len(charset)))
+	password := make([]rune, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		password[i] = charset[n.Int64()]
+	}
+	return string(password), nil
+}
+
+// connectionSecretData returns the contents of the connection secret for
+// the database user with the password
+func (p *Container) connectionSecretData(driver *Driver, password string) map[string][]byte {
+	return map[string][]byte{
+		"host":     []byte(p.database.Spec.Connect["host"]),
+		"port":     []byte(p.database.Spec.Connect["port"]),
+		"database": []byte(p.database.Spec.Name),
+		"username": []byte(driver.Database.Username),
+		"password": []byte(password),
+	}
+}
+
+// ensureSecret makes sure the connection secret, with the same name and
+// namespace as the database, exists and holds the password of the database
+// user, generating a password if it does not. This happens before the
+// database is created, so a rerun uses the same password
+func (p *Container) ensureSecret(driver *Driver) error {
+	if password, ok := p.secret.Data["password"]; ok {
+		driver.Database.Password = string(password)
+		return nil
+	}
+	password, err := generatePassword(p.database.Spec.PasswordPolicy)
+	if err != nil {
+		return err
+	}
+	exists := p.secret.Name != ""
+	if !exists {
+		p.secret = corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      p.database.Name,
+				Namespace: p.database.Namespace,
+				Labels: map[string]string{
+					"app": p.database.Name,
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(&p.database, dbv1alpha1.SchemeGroupVersion.WithKind("Database")),
+				},
+			},
+		}
+	}
+	p.secret.Data = p.connectionSecretData(driver, password)
+	log.Info("Storing connection secret", "Secret.Name", p.secret.Name)
+	if exists {
+		err = p.k8sclient.Update(context.TODO(), &p.secret)
+	} else {
+		err = p.k8sclient.Create(context.TODO(), &p.secret)
+	}
+	if err != nil {
+		return err
+	}
+	driver.Database.Password = password
+	return nil
+}
+
+// pendingPassword returns the password being rotated to, generating and
+// storing one in the secret if a rotation has not already begun
+func (p *Container) pendingPassword() (string, error) {
+	if password, ok := p.secret.Data["pendingPassword"]; ok {
+		return string(password), nil
+	}
+	password, err := generatePassword(p.database.Spec.PasswordPolicy)
+	if err != nil {
+		return "", err
+	}
+	p.secret.Data["pendingPassword"] = []byte(password)
+	if err := p.k8sclient.Update(context.TODO(), &p.secret); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// rotate changes the password of the database user. The new password is
+// stored in the secret before the driver is called, so that if we are
+// terminated part way through a rerun uses the same password
+func (p *Container) rotate(driver *Driver) error {
+	if driver.Rotate == nil {
+		return fmt.Errorf("Driver %s does not support password rotation", driver.Name)
+	}
+	if p.secret.Data == nil {
+		return fmt.Errorf("Connection secret for database %s not found", p.database.Name)
+	}
+	if p.database.Status.Phase != dbv1alpha1.RotationInProgress {
+		if err := p.updateDatabasePhase(dbv1alpha1.RotationInProgress); err != nil {
+			return err
+		}
+	}
+	password, err := p.pendingPassword()
+	if err != nil {
+		return err
+	}
+	driver.Database.Password = password
+	if err := driver.Rotate(driver); err != nil {
+		return err
+	}
+	p.secret.Data["password"] = []byte(password)
+	delete(p.secret.Data, "pendingPassword")
+	if err := p.k8sclient.Update(context.TODO(), &p.secret); err != nil {
+		return err
+	}
+	now := metav1.Now()
+	p.database.Status.LastRotated = &now
+	return p.updateDatabasePhase(dbv1alpha1.Created)
+}
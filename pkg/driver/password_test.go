@@ -0,0 +1,176 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	password, err := generatePassword(dbv1alpha1.PasswordPolicy{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(password) != defaultPasswordLength {
+		t.Errorf("Expected a password of length %d, got %d", defaultPasswordLength, len(password))
+	}
+	other, _ := generatePassword(dbv1alpha1.PasswordPolicy{})
+	if password == other {
+		t.Errorf("Generated the same password twice")
+	}
+	password, err = generatePassword(dbv1alpha1.PasswordPolicy{Length: 12, Charset: "ab"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(password) != 12 || strings.Trim(password, "ab") != "" {
+		t.Errorf("Password does not follow the policy: %q", password)
+	}
+	if _, err := generatePassword(dbv1alpha1.PasswordPolicy{Length: -1}); err == nil {
+		t.Errorf("Expected an error for a negative length")
+	}
+}
+
+func storedSecret(t *testing.T, p *Container) *corev1.Secret {
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: "testns", Name: "testdb"}
+	if err := p.k8sclient.Get(context.TODO(), key, secret); err != nil {
+		t.Fatalf("Unable to get secret: %s", err)
+	}
+	return secret
+}
+
+func TestCreatePublishesSecret(t *testing.T) {
+	db := testDatabase("")
+	db.Spec.Connect = map[string]string{"host": "db.example.com", "port": "5432"}
+	p := fakeContainer(&fakeDriver{}, util.CreateOperation, db)
+	p.drivers["fake"].Create = func(d *Driver) error {
+		// The password must be stored before the database is created
+		if string(storedSecret(t, p).Data["password"]) != d.Database.Password || d.Database.Password == "" {
+			t.Errorf("Password not stored before Create")
+		}
+		return nil
+	}
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcileDatabase(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	secret := storedSecret(t, p)
+	expected := map[string]string{
+		"host":     "db.example.com",
+		"port":     "5432",
+		"database": "testdb",
+		"username": "testdb",
+	}
+	for k, v := range expected {
+		if string(secret.Data[k]) != v {
+			t.Errorf("Expected %s of %q, got %q", k, v, secret.Data[k])
+		}
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].Name != "testdb" {
+		t.Errorf("Secret is not owned by the database")
+	}
+}
+
+func TestCreateReusesPassword(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "testdb", Namespace: "testns"},
+		Data:       map[string][]byte{"password": []byte("existing")},
+	}
+	p := fakeContainer(&fakeDriver{}, util.CreateOperation, testDatabase(dbv1alpha1.Creating), secret)
+	var password string
+	p.drivers["fake"].Create = func(d *Driver) error {
+		password = d.Database.Password
+		return nil
+	}
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcileDatabase(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if password != "existing" {
+		t.Errorf("Existing password not reused, got %q", password)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "testdb", Namespace: "testns"},
+		Data:       map[string][]byte{"password": []byte("old")},
+	}
+	p := fakeContainer(&fakeDriver{}, util.RotateOperation, testDatabase(dbv1alpha1.RotationRequested), secret)
+	var rotated []string
+	p.drivers["fake"].Rotate = func(d *Driver) error {
+		rotated = append(rotated, d.Database.Password)
+		// The new password is kept aside until the rotation succeeds
+		if string(storedSecret(t, p).Data["pendingPassword"]) != d.Database.Password {
+			t.Errorf("Pending password not stored before Rotate")
+		}
+		return nil
+	}
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcileDatabase(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(rotated) != 1 || rotated[0] == "old" {
+		t.Fatalf("Rotate not called with a new password: %v", rotated)
+	}
+	stored := storedSecret(t, p)
+	if string(stored.Data["password"]) != rotated[0] {
+		t.Errorf("Secret not updated with the new password")
+	}
+	if _, ok := stored.Data["pendingPassword"]; ok {
+		t.Errorf("Pending password left in the secret")
+	}
+	if phase := storedPhase(t, p); phase != dbv1alpha1.Created {
+		t.Errorf("Expected phase Created, got %s", phase)
+	}
+	if p.database.Status.LastRotated == nil {
+		t.Errorf("LastRotated not recorded")
+	}
+}
+
+func TestRotateResumes(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "testdb", Namespace: "testns"},
+		Data: map[string][]byte{
+			"password":        []byte("old"),
+			"pendingPassword": []byte("new"),
+		},
+	}
+	p := fakeContainer(&fakeDriver{}, util.RotateOperation, testDatabase(dbv1alpha1.RotationInProgress), secret)
+	var password string
+	p.drivers["fake"].Rotate = func(d *Driver) error {
+		password = d.Database.Password
+		return nil
+	}
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcileDatabase(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if password != "new" {
+		t.Errorf("Pending password not reused, got %q", password)
+	}
+}
+
+func TestRotateUnsupported(t *testing.T) {
+	p := fakeContainer(&fakeDriver{}, util.RotateOperation, testDatabase(dbv1alpha1.RotationRequested))
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcileDatabase(); err == nil {
+		t.Errorf("Expected an error from a driver without Rotate")
+	}
+}
@@ -0,0 +1,65 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+)
+
+// capabilities returns the operations the driver implements. Every driver
+// can be cloned, by piping a backup into a restore if it has no Clone
+func capabilities(d *Driver) []string {
+	var found []string
+	for _, c := range []struct {
+		op          util.Operation
+		implemented bool
+	}{
+		{util.CreateOperation, d.Create != nil},
+		{util.DropOperation, d.Drop != nil},
+		{util.BackupOperation, d.Backup != nil},
+		{util.RestoreOperation, d.Restore != nil},
+		{util.RotateOperation, d.Rotate != nil},
+		{util.CloneOperation, d.Clone != nil},
+		{util.VerifyOperation, d.Verify != nil},
+	} {
+		if c.implemented {
+			found = append(found, string(c.op))
+		}
+	}
+	return found
+}
+
+// reconcileProbe records the drivers registered by this image, and what
+// they are capable of, in the status of the provider
+func (p *Container) reconcileProbe() error {
+	if p.Operation != util.ProbeOperation {
+		return fmt.Errorf("Unable to %s provider %s", p.Operation, p.Provider)
+	}
+	var drivers []dbv1alpha1.DriverInfo
+	var names []string
+	for _, d := range p.drivers {
+		drivers = append(drivers, dbv1alpha1.DriverInfo{
+			Name:         d.Name,
+			Version:      d.Version,
+			Capabilities: capabilities(d),
+		})
+		names = append(names, d.Name)
+	}
+	sort.Slice(drivers, func(i, j int) bool { return drivers[i].Name < drivers[j].Name })
+	sort.Strings(names)
+	log.Info("Probed drivers", "Drivers", names)
+	p.provider.Status.Drivers = drivers
+	p.provider.Status.ProbedGeneration = p.provider.Generation
+	util.SetCondition(&p.provider.Status.Conditions, dbv1alpha1.Condition{
+		Type:               dbv1alpha1.ProviderProbed,
+		Status:             dbv1alpha1.ConditionTrue,
+		ObservedGeneration: p.provider.Generation,
+		Reason:             "Probed",
+		Message:            fmt.Sprintf("Registers drivers: %s", strings.Join(names, ", ")),
+	})
+	return p.k8sclient.Status().Update(context.TODO(), &p.provider)
+}
@@ -0,0 +1,60 @@
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestReconcileProbe(t *testing.T) {
+	provider := &dbv1alpha1.Provider{
+		ObjectMeta: metav1.ObjectMeta{Name: "testprovider", Namespace: "testns", Generation: 3},
+		Spec:       dbv1alpha1.ProviderSpec{Name: "fake", Image: "isotoma/db-operator-fake", Probe: true},
+	}
+	p := fakeContainer(&fakeDriver{}, util.ProbeOperation, provider)
+	p.Database = ""
+	p.Provider = "testprovider"
+	p.RegisterDriver(&Driver{Name: "another", Clone: func(target, source *Driver) error { return nil }})
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("reconcile threw unexpected error: %s", err)
+	}
+	stored := &dbv1alpha1.Provider{}
+	key := types.NamespacedName{Namespace: "testns", Name: "testprovider"}
+	if err := p.k8sclient.Get(context.TODO(), key, stored); err != nil {
+		t.Fatalf("Unable to get provider: %s", err)
+	}
+	expected := []dbv1alpha1.DriverInfo{
+		{Name: "another", Capabilities: []string{"clone"}},
+		{Name: "fake", Version: "1.0", Capabilities: []string{"create", "drop", "backup", "restore"}},
+	}
+	if !reflect.DeepEqual(stored.Status.Drivers, expected) {
+		t.Errorf("Expected drivers %v, got %v", expected, stored.Status.Drivers)
+	}
+	if stored.Status.ProbedGeneration != 3 {
+		t.Errorf("Expected probed generation 3, got %d", stored.Status.ProbedGeneration)
+	}
+	probed := util.FindCondition(stored.Status.Conditions, dbv1alpha1.ProviderProbed)
+	if probed == nil || probed.Status != dbv1alpha1.ConditionTrue || probed.Message != "Registers drivers: another, fake" {
+		t.Errorf("Unexpected Probed condition %v", probed)
+	}
+}
+
+func TestGetDriverNotRegistered(t *testing.T) {
+	db := testDatabase(dbv1alpha1.Created)
+	db.Spec.Provider = "missing"
+	p := fakeContainer(&fakeDriver{}, util.DropOperation, db)
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if _, err := p.getDriver(); err == nil {
+		t.Errorf("Expected an error for a driver that is not registered")
+	}
+}
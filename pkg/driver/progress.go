@@ -0,0 +1,121 @@
+package driver
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultProgressInterval is how often progress is written to the status
+// of a resource, at most
+const DefaultProgressInterval = 10 * time.Second
+
+// Progress is reported by drivers during long running operations
+type Progress struct {
+	// Bytes processed so far. During backups the container counts the
+	// bytes the driver writes, before they are compressed or encrypted,
+	// and during restores the bytes of the stored backup read, and this is
+	// ignored
+	Bytes int64
+	// Total bytes expected, if known. For backups this is the size of the
+	// dump the driver writes, not of the backup as stored
+	Total int64
+	// Percent complete, if known. It is worked out from Bytes and Total
+	// if not given
+	Percent int32
+	// Stage is a description of what the driver is doing
+	Stage string
+}
+
+// ReportProgress records the progress of the operation being performed.
+// It may be called as often as is convenient, as status updates are
+// throttled, but not after the driver method has returned
+func (d *Driver) ReportProgress(progress Progress) {
+	if d.progress != nil {
+		d.progress.report(progress)
+	}
+}
+
+// progressReporter throttles progress reports, writing the latest to the
+// status of the resource being reconciled
+type progressReporter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Time
+	latest   Progress
+	// counting is set when the container counts bytes itself
+	counting bool
+	// update writes progress to the status of the resource
+	update func(*dbv1alpha1.Progress) error
+}
+
+func newProgressReporter(interval time.Duration, update func(*dbv1alpha1.Progress) error) *progressReporter {
+	return &progressReporter{interval: interval, update: update}
+}
+
+// report records progress reported by the driver
+func (r *progressReporter) report(progress Progress) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.counting {
+		progress.Bytes = r.latest.Bytes
+		if progress.Total == 0 {
+			progress.Total = r.latest.Total
+		}
+	}
+	r.latest = progress
+	r.flush()
+}
+
+// count records the bytes counted by the container, out of total if known
+func (r *progressReporter) count(bytes, total int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counting = true
+	r.latest.Bytes = bytes
+	if total > 0 {
+		r.latest.Total = total
+	}
+	r.flush()
+}
+
+// flush writes the latest progress, unless it was written too recently.
+// Failing to write progress does not fail the operation
+func (r *progressReporter) flush() {
+	now := time.Now()
+	if !r.last.IsZero() && now.Sub(r.last) < r.interval {
+		return
+	}
+	r.last = now
+	status := &dbv1alpha1.Progress{
+		Bytes:      r.latest.Bytes,
+		Total:      r.latest.Total,
+		Percent:    r.latest.Percent,
+		Stage:      r.latest.Stage,
+		UpdateTime: metav1.NewTime(now),
+	}
+	if status.Percent == 0 && status.Total > 0 {
+		status.Percent = int32(status.Bytes * 100 / status.Total)
+	}
+	if err := r.update(status); err != nil {
+		log.Error(err, "Unable to update progress")
+	}
+}
+
+// countingWriter counts everything written through it
+type countingWriter struct {
+	w    io.Writer
+	size int64
+	// onWrite is called with the size written so far
+	onWrite func(size int64)
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.size += int64(n)
+	c.onWrite(c.size)
+	return n, err
+}
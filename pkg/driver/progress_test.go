@@ -0,0 +1,88 @@
+package driver
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+)
+
+func TestProgressThrottled(t *testing.T) {
+	updates := []*dbv1alpha1.Progress{}
+	r := newProgressReporter(time.Hour, func(progress *dbv1alpha1.Progress) error {
+		updates = append(updates, progress)
+		return nil
+	})
+	r.report(Progress{Stage: "dumping schema"})
+	r.report(Progress{Stage: "dumping data"})
+	if len(updates) != 1 || updates[0].Stage != "dumping schema" {
+		t.Errorf("Expected a single update, got %v", updates)
+	}
+}
+
+func TestProgressCounted(t *testing.T) {
+	var latest *dbv1alpha1.Progress
+	r := newProgressReporter(0, func(progress *dbv1alpha1.Progress) error {
+		latest = progress
+		return nil
+	})
+	r.count(250, 1000)
+	if latest.Bytes != 250 || latest.Percent != 25 {
+		t.Errorf("Expected 25%% of 1000 bytes, got %v", latest)
+	}
+	// Bytes counted by the container are kept over those of the driver
+	r.report(Progress{Bytes: 10, Stage: "loading data"})
+	if latest.Bytes != 250 || latest.Total != 1000 || latest.Stage != "loading data" {
+		t.Errorf("Driver progress not merged, got %v", latest)
+	}
+}
+
+func TestBackupProgress(t *testing.T) {
+	f := &fakeDriver{}
+	sink := &memorySink{objects: map[string]string{}}
+	p := backupContainer(t, f, sink, dbv1alpha1.Created)
+	var reported *dbv1alpha1.Progress
+	p.drivers["fake"].Backup = func(d *Driver, w *io.Writer) error {
+		d.ReportProgress(Progress{Stage: "dumping data"})
+		reported = storedBackup(t, p).Status.Progress
+		_, err := io.WriteString(*w, "dump")
+		return err
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if reported == nil || reported.Stage != "dumping data" {
+		t.Errorf("Progress not written while backing up, got %v", reported)
+	}
+	if progress := storedBackup(t, p).Status.Progress; progress != nil {
+		t.Errorf("Progress not cleared once completed, got %v", progress)
+	}
+}
+
+func TestBackupProgressCompressed(t *testing.T) {
+	f := &fakeDriver{}
+	sink := &memorySink{objects: map[string]string{}}
+	p := backupContainer(t, f, sink, dbv1alpha1.Created)
+	p.database.Spec.BackupTo.Compression = dbv1alpha1.GzipCompression
+	dump := bytes.Repeat([]byte("row\n"), 10000)
+	var reported *dbv1alpha1.Progress
+	p.drivers["fake"].Backup = func(d *Driver, w *io.Writer) error {
+		d.ReportProgress(Progress{Total: int64(len(dump)), Stage: "dumping data"})
+		if _, err := (*w).Write(dump); err != nil {
+			return err
+		}
+		reported = storedBackup(t, p).Status.Progress
+		return nil
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if reported == nil || reported.Bytes != int64(len(dump)) || reported.Percent != 100 {
+		t.Errorf("Expected the whole dump to be counted before compression, got %v", reported)
+	}
+	if size := storedBackup(t, p).Status.Size; size >= int64(len(dump)) {
+		t.Errorf("Backup not compressed, stored %d bytes", size)
+	}
+}
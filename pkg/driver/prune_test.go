@@ -0,0 +1,70 @@
+package driver
+
+import (
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func pruneContainer(t *testing.T, sink *memorySink, phase dbv1alpha1.BackupPhase, objs ...runtime.Object) *Container {
+	backup := testBackup()
+	backup.Status.Phase = phase
+	backup.Status.Destination = "memory://testdb/testbackup"
+	if objs == nil {
+		objs = []runtime.Object{testDatabase(dbv1alpha1.Created)}
+	}
+	p := fakeContainer(&fakeDriver{}, util.PruneOperation, append(objs, backup)...)
+	p.Database = ""
+	p.Backup = "testbackup"
+	p.sinkHolding = func(location string, db *dbv1alpha1.Database) (Sink, string, error) {
+		return sink, "testdb/testbackup", nil
+	}
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	return p
+}
+
+func TestReconcilePrune(t *testing.T) {
+	sink := &memorySink{objects: map[string]string{"testdb/testbackup": "dump"}}
+	p := pruneContainer(t, sink, dbv1alpha1.Pruning)
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("reconcile threw unexpected error: %s", err)
+	}
+	if _, ok := sink.objects["testdb/testbackup"]; ok {
+		t.Errorf("Stored backup not deleted")
+	}
+}
+
+func TestReconcilePruneNotPruning(t *testing.T) {
+	sink := &memorySink{objects: map[string]string{"testdb/testbackup": "dump"}}
+	p := pruneContainer(t, sink, dbv1alpha1.Completed)
+	if err := p.reconcile(); err == nil {
+		t.Errorf("Expected error pruning a backup that is not Pruning")
+	}
+	if _, ok := sink.objects["testdb/testbackup"]; !ok {
+		t.Errorf("Stored backup deleted")
+	}
+}
+
+func TestReconcilePruneDatabaseDeleted(t *testing.T) {
+	sink := &memorySink{objects: map[string]string{"testdb/testbackup": "dump"}}
+	p := pruneContainer(t, sink, dbv1alpha1.Pruning, []runtime.Object{}...)
+	p.backup.Status.BackupTo = &dbv1alpha1.BackupTo{S3: dbv1alpha1.S3Backup{Bucket: "recorded"}}
+	var bucket string
+	p.sinkHolding = func(location string, db *dbv1alpha1.Database) (Sink, string, error) {
+		bucket = db.Spec.BackupTo.S3.Bucket
+		return sink, "testdb/testbackup", nil
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("reconcile threw unexpected error: %s", err)
+	}
+	if _, ok := sink.objects["testdb/testbackup"]; ok {
+		t.Errorf("Stored backup not deleted")
+	}
+	if bucket != "recorded" {
+		t.Errorf("Expected the recorded destination to be used, got bucket %q", bucket)
+	}
+}
@@ -0,0 +1,115 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func restoreContainer(t *testing.T, f *fakeDriver, sink *memorySink, drop bool) *Container {
+	backup := testBackup()
+	backup.Status.Phase = dbv1alpha1.Completed
+	backup.Status.Destination = "memory://olddb/testbackup"
+	restore := &dbv1alpha1.Restore{
+		ObjectMeta: metav1.ObjectMeta{Name: "testrestore", Namespace: "testns"},
+		Spec: dbv1alpha1.RestoreSpec{
+			Backup:          "testbackup",
+			Database:        "testdb",
+			DropAndRecreate: drop,
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "testdb", Namespace: "testns"},
+		Data:       map[string][]byte{"password": []byte("existing")},
+	}
+	p := fakeContainer(f, util.RestoreOperation, testDatabase(dbv1alpha1.Created), backup, restore, secret)
+	p.Database = ""
+	p.Restore = "testrestore"
+	p.sinkHolding = func(location string, db *dbv1alpha1.Database) (Sink, string, error) {
+		if location != "memory://olddb/testbackup" {
+			return nil, "", fmt.Errorf("Unexpected location %s", location)
+		}
+		return sink, "olddb/testbackup", nil
+	}
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	return p
+}
+
+func storedRestorePhase(t *testing.T, p *Container) dbv1alpha1.RestorePhase {
+	restore := &dbv1alpha1.Restore{}
+	key := types.NamespacedName{Namespace: "testns", Name: "testrestore"}
+	if err := p.k8sclient.Get(context.TODO(), key, restore); err != nil {
+		t.Fatalf("Unable to get restore: %s", err)
+	}
+	return restore.Status.Phase
+}
+
+func TestReconcileRestore(t *testing.T) {
+	f := &fakeDriver{}
+	sink := &memorySink{objects: map[string]string{"olddb/testbackup": "dump of olddb"}}
+	p := restoreContainer(t, f, sink, false)
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if fmt.Sprint(f.calls) != "[restore dump of olddb]" {
+		t.Errorf("Unexpected driver calls %v", f.calls)
+	}
+	if phase := storedRestorePhase(t, p); phase != dbv1alpha1.RestoreCompleted {
+		t.Errorf("Expected restore Completed, got %s", phase)
+	}
+}
+
+func TestReconcileRestoreDropAndRecreate(t *testing.T) {
+	f := &fakeDriver{}
+	sink := &memorySink{objects: map[string]string{"olddb/testbackup": "dump of olddb"}}
+	p := restoreContainer(t, f, sink, true)
+	var password string
+	p.drivers["fake"].Create = func(d *Driver) error {
+		password = d.Database.Password
+		f.calls = append(f.calls, "create")
+		return nil
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if fmt.Sprint(f.calls) != "[drop create restore dump of olddb]" {
+		t.Errorf("Unexpected driver calls %v", f.calls)
+	}
+	if password != "existing" {
+		t.Errorf("Database recreated with a new password %q", password)
+	}
+}
+
+func TestReconcileRestoreMissingObject(t *testing.T) {
+	f := &fakeDriver{}
+	sink := &memorySink{objects: map[string]string{}}
+	p := restoreContainer(t, f, sink, false)
+	if err := p.reconcile(); err == nil {
+		t.Fatalf("Expected an error for a missing backup object")
+	}
+	if phase := storedRestorePhase(t, p); phase != dbv1alpha1.Restoring {
+		t.Errorf("Expected restore to remain Restoring, got %s", phase)
+	}
+}
+
+func TestReconcileRestoreChecksumMismatch(t *testing.T) {
+	f := &fakeDriver{}
+	sink := &memorySink{objects: map[string]string{"olddb/testbackup": "tampered dump"}}
+	p := restoreContainer(t, f, sink, true)
+	p.backup.Status.Size = 13
+	p.backup.Status.Checksum = "sha256:0000"
+	if err := p.reconcile(); err == nil {
+		t.Fatalf("Expected an error for a backup that does not match its checksum")
+	}
+	if len(f.calls) != 0 {
+		t.Errorf("Database changed despite checksum mismatch: %v", f.calls)
+	}
+}
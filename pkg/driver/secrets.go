@@ -0,0 +1,129 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SecretBackend reads credentials from a store of secrets
+type SecretBackend interface {
+	// Handles returns true if the reference is to a secret in this backend
+	Handles(ref dbv1alpha1.ValueFrom) bool
+	// Read returns the referenced secret value
+	Read(ref dbv1alpha1.ValueFrom) (string, error)
+}
+
+// KubernetesSecretBackend reads credentials from Kubernetes secrets
+type KubernetesSecretBackend struct {
+	Client client.Client
+	// Namespace of the database. Secrets in other namespaces must allow it
+	Namespace string
+}
+
+// Handles returns true for references to a Kubernetes secret
+func (k *KubernetesSecretBackend) Handles(ref dbv1alpha1.ValueFrom) bool {
+	return ref.SecretKeyRef.Name != ""
+}
+
+// Read returns the value of the key in the referenced secret
+func (k *KubernetesSecretBackend) Read(ref dbv1alpha1.ValueFrom) (string, error) {
+	s := ref.SecretKeyRef
+	namespace := s.Namespace
+	if namespace == "" {
+		namespace = k.Namespace
+	}
+	secret := &corev1.Secret{}
+	err := k.Client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: s.Name}, secret)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", fmt.Errorf("Secret %s/%s not found", namespace, s.Name)
+		}
+		return "", err
+	}
+	if !util.AllowsNamespace(secret, k.Namespace) {
+		return "", fmt.Errorf("Secret %s/%s does not allow access from namespace %s", namespace, s.Name, k.Namespace)
+	}
+	value, ok := secret.Data[s.Key]
+	if !ok {
+		return "", fmt.Errorf("Key %s not found in secret %s/%s", s.Key, namespace, s.Name)
+	}
+	return string(value), nil
+}
+
+// AwsSecretBackend reads credentials from AWS Secrets Manager
+type AwsSecretBackend struct {
+	Credentials dbv1alpha1.AwsCredentials
+	// NewClient returns a Secrets Manager client for the region. It may be
+	// replaced to use a fake Secrets Manager
+	NewClient func(creds dbv1alpha1.AwsCredentials, region string) (secretsmanageriface.SecretsManagerAPI, error)
+}
+
+// NewAwsSecretBackend returns a backend using the literal credentials if
+// they are provided, and the ambient credential chain otherwise
+func NewAwsSecretBackend(creds dbv1alpha1.AwsCredentials) *AwsSecretBackend {
+	return &AwsSecretBackend{
+		Credentials: creds,
+		NewClient: func(creds dbv1alpha1.AwsCredentials, region string) (secretsmanageriface.SecretsManagerAPI, error) {
+			sess, err := awsSession(creds, region)
+			if err != nil {
+				return nil, err
+			}
+			return secretsmanager.New(sess), nil
+		},
+	}
+}
+
+// Handles returns true for references to an AWS secret
+func (a *AwsSecretBackend) Handles(ref dbv1alpha1.ValueFrom) bool {
+	return ref.AwsSecretKeyRef.ARN != ""
+}
+
+// Read returns the referenced secret. If a key is provided the secret is
+// parsed as a JSON object and the value of the key is returned
+func (a *AwsSecretBackend) Read(ref dbv1alpha1.ValueFrom) (string, error) {
+	s := ref.AwsSecretKeyRef
+	// The secret may be in a different region to the one configured
+	parsed, err := arn.Parse(s.ARN)
+	if err != nil {
+		return "", fmt.Errorf("Invalid secret ARN %s: %s", s.ARN, err)
+	}
+	sm, err := a.NewClient(a.Credentials, parsed.Region)
+	if err != nil {
+		return "", err
+	}
+	out, err := sm.GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(s.ARN),
+	})
+	if err != nil {
+		return "", err
+	}
+	value := aws.StringValue(out.SecretString)
+	if s.Key == "" {
+		return value, nil
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("Secret %s is not a JSON object: %s", s.ARN, err)
+	}
+	field, ok := fields[s.Key]
+	if !ok {
+		return "", fmt.Errorf("Key %s not found in secret %s", s.Key, s.ARN)
+	}
+	if str, ok := field.(string); ok {
+		return str, nil
+	}
+	// Numbers such as ports are not quoted in the JSON
+	return fmt.Sprint(field), nil
+}
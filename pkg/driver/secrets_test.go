@@ -0,0 +1,106 @@
+package driver
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+)
+
+const testARN = "arn:aws:secretsmanager:eu-west-2:123456789012:secret:dbmaster-AbCdEf"
+
+// fakeSecretsManager serves secret strings keyed by ARN
+type fakeSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	secrets map[string]string
+}
+
+func (f *fakeSecretsManager) GetSecretValue(in *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	value, ok := f.secrets[aws.StringValue(in.SecretId)]
+	if !ok {
+		return nil, fmt.Errorf("ResourceNotFoundException")
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(value)}, nil
+}
+
+func fakeAwsBackend(secret string, region *string) *AwsSecretBackend {
+	sm := &fakeSecretsManager{secrets: map[string]string{testARN: secret}}
+	return &AwsSecretBackend{
+		NewClient: func(creds dbv1alpha1.AwsCredentials, r string) (secretsmanageriface.SecretsManagerAPI, error) {
+			*region = r
+			return sm, nil
+		},
+	}
+}
+
+func awsRef(arn, key string) dbv1alpha1.ValueFrom {
+	return dbv1alpha1.ValueFrom{AwsSecretKeyRef: dbv1alpha1.AwsSecretRef{ARN: arn, Key: key}}
+}
+
+func TestAwsSecretBackend(t *testing.T) {
+	secret := `{"username": "master", "password": "hunter2", "port": 5432}`
+	cases := []struct {
+		name      string
+		ref       dbv1alpha1.ValueFrom
+		value     string
+		shouldErr bool
+	}{
+		{"string key", awsRef(testARN, "password"), "hunter2", false},
+		{"numeric key", awsRef(testARN, "port"), "5432", false},
+		{"whole secret", awsRef(testARN, ""), secret, false},
+		{"missing key", awsRef(testARN, "host"), "", true},
+		{"missing secret", awsRef("arn:aws:secretsmanager:eu-west-2:123456789012:secret:other", "password"), "", true},
+		{"invalid arn", awsRef("dbmaster", "password"), "", true},
+	}
+	for _, c := range cases {
+		var region string
+		b := fakeAwsBackend(secret, &region)
+		value, err := b.Read(c.ref)
+		if c.shouldErr && err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+		if !c.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+		}
+		if value != c.value {
+			t.Errorf("%s: expected %q, got %q", c.name, c.value, value)
+		}
+		if !c.shouldErr && region != "eu-west-2" {
+			t.Errorf("%s: client created for region %q", c.name, region)
+		}
+	}
+}
+
+func TestAwsSecretBackendNotJSON(t *testing.T) {
+	var region string
+	b := fakeAwsBackend("hunter2", &region)
+	if _, err := b.Read(awsRef(testARN, "password")); err == nil {
+		t.Errorf("Expected an error reading a key from a plain secret")
+	}
+}
+
+// fakeSecretBackend serves every reference with a fixed value
+type fakeSecretBackend struct {
+	value string
+}
+
+func (f *fakeSecretBackend) Handles(ref dbv1alpha1.ValueFrom) bool {
+	return true
+}
+
+func (f *fakeSecretBackend) Read(ref dbv1alpha1.ValueFrom) (string, error) {
+	return f.value, nil
+}
+
+func TestRegisterSecretBackend(t *testing.T) {
+	p := fakeContainer(&fakeDriver{}, util.CreateOperation, testDatabase(""))
+	p.RegisterSecretBackend(&fakeSecretBackend{value: "from fake"})
+	value, err := p.getCredential(dbv1alpha1.Credential{ValueFrom: awsRef(testARN, "password")})
+	if err != nil || value != "from fake" {
+		t.Errorf("Registered backend not used, got %q, %v", value, err)
+	}
+}
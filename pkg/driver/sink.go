@@ -0,0 +1,197 @@
+package driver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+)
+
+// Sink is a destination that backups are streamed to
+type Sink interface {
+	// Location returns a URI describing where the named backup is stored
+	Location(name string) string
+	// Upload streams everything read from r to the named backup, returning
+	// only once the upload has been confirmed
+	Upload(name string, r io.Reader) error
+	// Open returns a reader streaming the named backup
+	Open(name string) (io.ReadCloser, error)
+	// Delete removes the named backup
+	Delete(name string) error
+}
+
+// S3Sink streams backups to an S3 bucket
+type S3Sink struct {
+	Bucket   string
+	Prefix   string
+	client   s3iface.S3API
+	uploader *s3manager.Uploader
+}
+
+// awsSession returns an AWS session using the literal credentials if they
+// are provided, and the ambient credential chain otherwise
+func awsSession(creds dbv1alpha1.AwsCredentials, region string) (*session.Session, error) {
+	cfg := aws.NewConfig()
+	if region == "" {
+		region = creds.Region
+	}
+	if region != "" {
+		cfg = cfg.WithRegion(region)
+	}
+	if creds.AccessKeyID != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(creds.AccessKeyID, creds.SecretAccessKey, ""))
+	}
+	return session.NewSession(cfg)
+}
+
+// tlsClient returns an HTTP client verifying certificates as configured
+func tlsClient(cfg dbv1alpha1.TLSConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(cfg.CABundle)) {
+			return nil, fmt.Errorf("No certificates found in CA bundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}}, nil
+}
+
+// NewS3Sink returns a sink writing to the bucket described by dest
+func NewS3Sink(dest dbv1alpha1.S3Backup, creds dbv1alpha1.AwsCredentials) (*S3Sink, error) {
+	sess, err := awsSession(creds, dest.Region)
+	if err != nil {
+		return nil, err
+	}
+	cfg := aws.NewConfig()
+	if dest.Endpoint != "" {
+		cfg = cfg.WithEndpoint(dest.Endpoint)
+	}
+	if dest.ForcePathStyle {
+		cfg = cfg.WithS3ForcePathStyle(true)
+	}
+	if dest.TLS != (dbv1alpha1.TLSConfig{}) {
+		client, err := tlsClient(dest.TLS)
+		if err != nil {
+			return nil, err
+		}
+		cfg = cfg.WithHTTPClient(client)
+	}
+	client := s3.New(sess, cfg)
+	return &S3Sink{
+		Bucket:   dest.Bucket,
+		Prefix:   dest.Prefix,
+		client:   client,
+		uploader: s3manager.NewUploaderWithClient(client),
+	}, nil
+}
+
+// Location returns the s3:// URI of the named backup
+func (s *S3Sink) Location(name string) string {
+	return fmt.Sprintf("s3://%s/%s%s", s.Bucket, s.Prefix, name)
+}
+
+// Upload streams r to the named backup using a multipart upload, so the
+// backup does not need to fit in memory or on disk
+func (s *S3Sink) Upload(name string, r io.Reader) error {
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Prefix + name),
+		Body:   r,
+	})
+	return err
+}
+
+// Open returns the body of the named backup, which is streamed as it is read
+func (s *S3Sink) Open(name string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Prefix + name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// Delete removes the named backup from the bucket
+func (s *S3Sink) Delete(name string) error {
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Prefix + name),
+	})
+	return err
+}
+
+// locateBackup returns the sink holding the backup at the location recorded
+// in its status, and the name of the backup within it. The database being
+// restored into, or that was backed up, provides the credentials and any
+// other settings of the store
+func (p *Container) locateBackup(location string, database *dbv1alpha1.Database) (Sink, string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, "", err
+	}
+	dest := database.Spec.BackupTo
+	name := strings.TrimPrefix(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		dest.S3.Bucket = u.Host
+		dest.S3.Prefix = ""
+		sink, err := NewS3Sink(dest.S3, database.Spec.AwsCredentials)
+		return sink, name, err
+	case "gs":
+		dest.GCS.Bucket = u.Host
+		dest.GCS.Prefix = ""
+		sink, err := p.newGCSSink(dest.GCS)
+		return sink, name, err
+	case "azure":
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) != 2 {
+			return nil, "", fmt.Errorf("No container in backup location %s", location)
+		}
+		dest.Azure.Account = u.Host
+		dest.Azure.Container = parts[0]
+		dest.Azure.Prefix = ""
+		sink, err := p.newAzureSink(dest.Azure)
+		return sink, parts[1], err
+	case "pvc":
+		sink := &FileSink{Claim: u.Host, Root: util.ClaimPath(u.Host)}
+		return sink, name, nil
+	}
+	return nil, "", fmt.Errorf("Unsupported backup location %s", location)
+}
+
+// newSink returns the sink for the database's backup destination
+func (p *Container) newSink(database *dbv1alpha1.Database) (Sink, error) {
+	dest := database.Spec.BackupTo
+	switch {
+	case dest.S3.Bucket != "":
+		return NewS3Sink(dest.S3, database.Spec.AwsCredentials)
+	case dest.GCS.Bucket != "":
+		return p.newGCSSink(dest.GCS)
+	case dest.Azure.Container != "":
+		return p.newAzureSink(dest.Azure)
+	case dest.PVC.ClaimName != "":
+		return NewFileSink(dest.PVC, database)
+	}
+	return nil, fmt.Errorf("No backup destination configured for database %s", database.Name)
+}
@@ -0,0 +1,108 @@
+package driver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+)
+
+// fakeS3 emulates an S3 compatible store addressed in path style
+func fakeS3(objects map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/testbucket/") {
+			http.NotFound(w, r)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/testbucket/")
+		switch r.Method {
+		case "PUT":
+			b, _ := ioutil.ReadAll(r.Body)
+			objects[key] = string(b)
+		case "GET":
+			object, ok := objects[key]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(object))
+		case "DELETE":
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+func TestS3CompatibleSink(t *testing.T) {
+	objects := map[string]string{}
+	server := fakeS3(objects)
+	defer server.Close()
+	sink, err := NewS3Sink(dbv1alpha1.S3Backup{
+		Region:         "us-east-1",
+		Bucket:         "testbucket",
+		Endpoint:       server.URL,
+		ForcePathStyle: true,
+	}, dbv1alpha1.AwsCredentials{AccessKeyID: "key", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatalf("Unable to create sink: %s", err)
+	}
+	if err := sink.Upload("testdb/testbackup", strings.NewReader("dump")); err != nil {
+		t.Fatalf("Upload threw unexpected error: %s", err)
+	}
+	if objects["testdb/testbackup"] != "dump" {
+		t.Fatalf("Backup not uploaded: %v", objects)
+	}
+	r, err := sink.Open("testdb/testbackup")
+	if err != nil {
+		t.Fatalf("Open threw unexpected error: %s", err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(b) != "dump" {
+		t.Errorf("Backup read incorrectly: %s", b)
+	}
+	if err := sink.Delete("testdb/testbackup"); err != nil {
+		t.Fatalf("Delete threw unexpected error: %s", err)
+	}
+	if len(objects) != 0 {
+		t.Errorf("Backup not deleted: %v", objects)
+	}
+}
+
+func TestTLSClientInvalidBundle(t *testing.T) {
+	if _, err := tlsClient(dbv1alpha1.TLSConfig{CABundle: "not a certificate"}); err == nil {
+		t.Errorf("Expected error for CA bundle without certificates")
+	}
+}
+
+func TestLocateBackup(t *testing.T) {
+	p := &Container{}
+	database := &dbv1alpha1.Database{
+		Spec: dbv1alpha1.DatabaseSpec{
+			BackupTo: dbv1alpha1.BackupTo{
+				GCS:   dbv1alpha1.GCSBackup{Endpoint: "http://localhost:4443"},
+				Azure: dbv1alpha1.AzureBackup{SASToken: dbv1alpha1.Credential{Value: "sig=secret"}},
+			},
+		},
+	}
+	sink, name, err := p.locateBackup("gs://bucket/backups/testdb/testbackup", database)
+	if err != nil {
+		t.Fatalf("Unable to locate GCS backup: %s", err)
+	}
+	if gcs := sink.(*GCSSink); gcs.Bucket != "bucket" || gcs.Endpoint != "http://localhost:4443" || name != "backups/testdb/testbackup" {
+		t.Errorf("GCS backup located incorrectly: %v %s", gcs, name)
+	}
+	sink, name, err = p.locateBackup("azure://account/container/testdb/testbackup", database)
+	if err != nil {
+		t.Fatalf("Unable to locate Azure backup: %s", err)
+	}
+	if azure := sink.(*AzureSink); azure.Account != "account" || azure.Container != "container" || name != "testdb/testbackup" {
+		t.Errorf("Azure backup located incorrectly: %v %s", azure, name)
+	}
+	if _, _, err := p.locateBackup("ftp://host/testbackup", database); err == nil {
+		t.Errorf("Expected error for unsupported location")
+	}
+}
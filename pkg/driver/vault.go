@@ -0,0 +1,186 @@
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+)
+
+// serviceAccountTokenPath is where the driver job's service account token
+// is mounted
+const serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// VaultSecretBackend reads credentials from HashiCorp Vault, logging in
+// with the Kubernetes auth method as the driver job's service account.
+// The service account token is only sent to the Vault servers configured
+// in the driver job, as the address in a reference is chosen by whoever
+// can edit the database
+type VaultSecretBackend struct {
+	// Address of the Vault server, if not given in the reference
+	Address string
+	// AllowedAddresses are the other Vault servers a reference may name
+	AllowedAddresses []string
+	// TokenPath is the service account token used to log in
+	TokenPath string
+	Client    *http.Client
+	// tokens caches the Vault token for each address and role
+	tokens map[string]string
+}
+
+// NewVaultSecretBackend returns a backend for the Vault server in
+// VAULT_ADDR, also allowing the comma separated servers in
+// VAULT_ALLOWED_ADDRS
+func NewVaultSecretBackend() *VaultSecretBackend {
+	var allowed []string
+	for _, address := range strings.Split(os.Getenv("VAULT_ALLOWED_ADDRS"), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			allowed = append(allowed, address)
+		}
+	}
+	return &VaultSecretBackend{
+		Address:          os.Getenv("VAULT_ADDR"),
+		AllowedAddresses: allowed,
+		TokenPath:        serviceAccountTokenPath,
+		Client:           http.DefaultClient,
+	}
+}
+
+// Handles returns true for references to a Vault secret
+func (v *VaultSecretBackend) Handles(ref dbv1alpha1.ValueFrom) bool {
+	return ref.VaultSecretRef.Path != ""
+}
+
+// allowed returns true if the service account token may be sent to the
+// Vault server at address
+func (v *VaultSecretBackend) allowed(address string) bool {
+	for _, a := range append([]string{v.Address}, v.AllowedAddresses...) {
+		if a != "" && strings.TrimSuffix(a, "/") == address {
+			return true
+		}
+	}
+	return false
+}
+
+// request makes a request to the Vault API and decodes the response into out
+func (v *VaultSecretBackend) request(method, url, token string, body interface{}, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Vault returned %s for %s: %s", resp.Status, url, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// login returns a Vault token for the role, logging in if we have not
+// already done so
+func (v *VaultSecretBackend) login(address, authPath, role string) (string, error) {
+	cacheKey := address + "/" + authPath + "/" + role
+	if token, ok := v.tokens[cacheKey]; ok {
+		return token, nil
+	}
+	jwt, err := ioutil.ReadFile(v.TokenPath)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read service account token: %s", err)
+	}
+	body := map[string]string{
+		"role": role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	}
+	resp := struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}{}
+	url := fmt.Sprintf("%s/v1/auth/%s/login", address, authPath)
+	if err := v.request("POST", url, "", body, &resp); err != nil {
+		return "", err
+	}
+	if v.tokens == nil {
+		v.tokens = make(map[string]string)
+	}
+	v.tokens[cacheKey] = resp.Auth.ClientToken
+	return resp.Auth.ClientToken, nil
+}
+
+// Read returns the value of the key in the referenced secret
+func (v *VaultSecretBackend) Read(ref dbv1alpha1.ValueFrom) (string, error) {
+	s := ref.VaultSecretRef
+	address := s.Address
+	if address == "" {
+		address = v.Address
+	}
+	if address == "" {
+		return "", fmt.Errorf("No Vault address provided for secret %s", s.Path)
+	}
+	address = strings.TrimSuffix(address, "/")
+	if !v.allowed(address) {
+		return "", fmt.Errorf("Vault address %s for secret %s is not allowed", address, s.Path)
+	}
+	mount, authPath := s.Mount, s.AuthPath
+	if mount == "" {
+		mount = "secret"
+	}
+	if authPath == "" {
+		authPath = "kubernetes"
+	}
+	token, err := v.login(address, authPath, s.Role)
+	if err != nil {
+		return "", err
+	}
+	path := strings.Trim(s.Path, "/")
+	var data map[string]interface{}
+	switch s.KVVersion {
+	case 1:
+		resp := struct {
+			Data map[string]interface{} `json:"data"`
+		}{}
+		if err := v.request("GET", fmt.Sprintf("%s/v1/%s/%s", address, mount, path), token, nil, &resp); err != nil {
+			return "", err
+		}
+		data = resp.Data
+	case 0, 2:
+		resp := struct {
+			Data struct {
+				Data map[string]interface{} `json:"data"`
+			} `json:"data"`
+		}{}
+		if err := v.request("GET", fmt.Sprintf("%s/v1/%s/data/%s", address, mount, path), token, nil, &resp); err != nil {
+			return "", err
+		}
+		data = resp.Data.Data
+	default:
+		return "", fmt.Errorf("Unsupported KV version %d for secret %s", s.KVVersion, s.Path)
+	}
+	value, ok := data[s.Key]
+	if !ok {
+		return "", fmt.Errorf("Key %s not found in Vault secret %s/%s", s.Key, mount, path)
+	}
+	if str, ok := value.(string); ok {
+		return str, nil
+	}
+	return fmt.Sprint(value), nil
+}
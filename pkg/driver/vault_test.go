@@ -0,0 +1,141 @@
+package driver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+)
+
+// vaultStub serves the Kubernetes auth login and KV v1 and v2 reads
+func vaultStub(logins *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			body := map[string]string{}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["jwt"] != "sa-token" || body["role"] != "db-operator" {
+				http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+				return
+			}
+			*logins++
+			w.Write([]byte(`{"auth": {"client_token": "s.vaulttoken"}}`))
+			return
+		}
+		if r.Header.Get("X-Vault-Token") != "s.vaulttoken" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv1/db/master":
+			w.Write([]byte(`{"data": {"password": "v1secret"}}`))
+		case "/v1/secret/data/db/master":
+			w.Write([]byte(`{"data": {"data": {"password": "v2secret", "port": 5432}}}`))
+		default:
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		}
+	}))
+}
+
+func vaultBackend(t *testing.T, address string) *VaultSecretBackend {
+	f, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("sa-token\n")
+	f.Close()
+	return &VaultSecretBackend{Address: address, TokenPath: f.Name(), Client: http.DefaultClient}
+}
+
+func vaultRef(ref dbv1alpha1.VaultSecretRef) dbv1alpha1.ValueFrom {
+	if ref.Role == "" {
+		ref.Role = "db-operator"
+	}
+	return dbv1alpha1.ValueFrom{VaultSecretRef: ref}
+}
+
+func TestVaultSecretBackend(t *testing.T) {
+	logins := 0
+	server := vaultStub(&logins)
+	defer server.Close()
+	cases := []struct {
+		name      string
+		ref       dbv1alpha1.ValueFrom
+		value     string
+		shouldErr bool
+	}{
+		{"kv2 default", vaultRef(dbv1alpha1.VaultSecretRef{Path: "db/master", Key: "password"}), "v2secret", false},
+		{"kv2 numeric", vaultRef(dbv1alpha1.VaultSecretRef{Path: "db/master", Key: "port", KVVersion: 2}), "5432", false},
+		{"kv1", vaultRef(dbv1alpha1.VaultSecretRef{Mount: "kv1", Path: "db/master", Key: "password", KVVersion: 1}), "v1secret", false},
+		{"missing key", vaultRef(dbv1alpha1.VaultSecretRef{Path: "db/master", Key: "username"}), "", true},
+		{"missing secret", vaultRef(dbv1alpha1.VaultSecretRef{Path: "db/other", Key: "password"}), "", true},
+		{"bad kv version", vaultRef(dbv1alpha1.VaultSecretRef{Path: "db/master", Key: "password", KVVersion: 3}), "", true},
+		{"wrong role", vaultRef(dbv1alpha1.VaultSecretRef{Path: "db/master", Key: "password", Role: "other"}), "", true},
+	}
+	b := vaultBackend(t, server.URL)
+	defer os.Remove(b.TokenPath)
+	for _, c := range cases {
+		value, err := b.Read(c.ref)
+		if c.shouldErr && err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+		if !c.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+		}
+		if value != c.value {
+			t.Errorf("%s: expected %q, got %q", c.name, c.value, value)
+		}
+	}
+	if logins != 1 {
+		t.Errorf("Expected a single login, got %d", logins)
+	}
+}
+
+func TestVaultSecretBackendAddress(t *testing.T) {
+	logins := 0
+	server := vaultStub(&logins)
+	defer server.Close()
+	b := vaultBackend(t, "")
+	defer os.Remove(b.TokenPath)
+	ref := vaultRef(dbv1alpha1.VaultSecretRef{Path: "db/master", Key: "password"})
+	if _, err := b.Read(ref); err == nil {
+		t.Errorf("Expected an error without an address")
+	}
+	// The token is never sent to an address that is not allowed
+	ref.VaultSecretRef.Address = server.URL + "/"
+	if _, err := b.Read(ref); err == nil {
+		t.Errorf("Expected an error for an address that is not allowed")
+	}
+	if logins != 0 {
+		t.Errorf("Logged in to an address that is not allowed")
+	}
+	b.AllowedAddresses = []string{"https://vault.example.com", server.URL}
+	if value, err := b.Read(ref); err != nil || value != "v2secret" {
+		t.Errorf("Allowed address from reference not used, got %q, %v", value, err)
+	}
+}
+
+func TestVaultSecretBackendOtherAddress(t *testing.T) {
+	logins := 0
+	server := vaultStub(&logins)
+	defer server.Close()
+	other := vaultStub(&logins)
+	defer other.Close()
+	b := vaultBackend(t, server.URL)
+	defer os.Remove(b.TokenPath)
+	ref := vaultRef(dbv1alpha1.VaultSecretRef{Path: "db/master", Key: "password", Address: other.URL})
+	if _, err := b.Read(ref); err == nil {
+		t.Errorf("Expected an error for an address other than VAULT_ADDR")
+	}
+	ref.VaultSecretRef.Address = server.URL
+	if value, err := b.Read(ref); err != nil || value != "v2secret" {
+		t.Errorf("VAULT_ADDR given in reference not used, got %q, %v", value, err)
+	}
+	if logins != 1 {
+		t.Errorf("Expected a single login, got %d", logins)
+	}
+}
@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func verifyBackup(t *testing.T, check func(*Driver) error) *dbv1alpha1.Condition {
+	backup := testBackup()
+	backup.Status.Phase = dbv1alpha1.Completed
+	f := &fakeDriver{}
+	p := fakeContainer(f, util.VerifyOperation, testDatabase(dbv1alpha1.Created), backup)
+	p.drivers["fake"].Verify = check
+	p.Backup = "testbackup"
+	if err := p.load(); err != nil {
+		t.Fatalf("Unable to load resources: %s", err)
+	}
+	if err := p.reconcile(); err != nil {
+		t.Fatalf("reconcile threw unexpected error: %s", err)
+	}
+	stored := &dbv1alpha1.Backup{}
+	key := types.NamespacedName{Namespace: "testns", Name: "testbackup"}
+	if err := p.k8sclient.Get(context.TODO(), key, stored); err != nil {
+		t.Fatalf("Unable to get backup: %s", err)
+	}
+	return util.FindCondition(stored.Status.Conditions, dbv1alpha1.BackupVerified)
+}
+
+func TestReconcileVerify(t *testing.T) {
+	cases := []struct {
+		check  func(*Driver) error
+		status dbv1alpha1.ConditionStatus
+		reason string
+	}{
+		{nil, dbv1alpha1.ConditionTrue, "Restored"},
+		{func(*Driver) error { return nil }, dbv1alpha1.ConditionTrue, "Verified"},
+		{func(*Driver) error { return fmt.Errorf("Table users is empty") }, dbv1alpha1.ConditionFalse, "CheckFailed"},
+	}
+	for _, c := range cases {
+		cond := verifyBackup(t, c.check)
+		if cond == nil {
+			t.Errorf("Verified condition not set")
+			continue
+		}
+		if cond.Status != c.status || cond.Reason != c.reason {
+			t.Errorf("Expected %s %s, got %s %s", c.status, c.reason, cond.Status, cond.Reason)
+		}
+	}
+}
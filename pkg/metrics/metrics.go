@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"context"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/isotoma/db-operator/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	batchv1 "k8s.io/api/batch/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("metrics")
+
+var (
+	// OperationDuration is how long driver jobs took to succeed
+	OperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_operator_operation_duration_seconds",
+		Help:    "Time taken by driver jobs that succeeded, by operation",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+	}, []string{"namespace", "provider", "operation"})
+
+	// JobFailures counts the driver jobs that failed
+	JobFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_operator_driver_job_failures_total",
+		Help: "Driver jobs that failed, by operation",
+	}, []string{"namespace", "provider", "operation"})
+
+	databasesDesc = prometheus.NewDesc(
+		"db_operator_databases",
+		"Databases by phase",
+		[]string{"namespace", "provider", "phase"}, nil,
+	)
+	backupsDesc = prometheus.NewDesc(
+		"db_operator_backups",
+		"Backups by phase",
+		[]string{"namespace", "provider", "phase"}, nil,
+	)
+	lastBackupDesc = prometheus.NewDesc(
+		"db_operator_last_successful_backup_timestamp",
+		"Time the latest completed backup of the database completed, in seconds since the epoch",
+		[]string{"namespace", "provider", "database"}, nil,
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(OperationDuration, JobFailures)
+}
+
+// ObserveJob records the duration of a driver job that succeeded, or that
+// it failed. The job's labels give the operation and provider
+func ObserveJob(job *batchv1.Job, succeeded bool) {
+	labels := prometheus.Labels{
+		"namespace": job.Namespace,
+		"provider":  job.Labels["provider"],
+		"operation": job.Labels["operation"],
+	}
+	if !succeeded {
+		JobFailures.With(labels).Inc()
+		return
+	}
+	if job.Status.StartTime == nil || job.Status.CompletionTime == nil {
+		return
+	}
+	duration := job.Status.CompletionTime.Sub(job.Status.StartTime.Time)
+	OperationDuration.With(labels).Observe(duration.Seconds())
+}
+
+// Collector exports the number of databases and backups in each phase, and
+// when each database was last backed up. They are read from the client,
+// which is the manager's cache, whenever metrics are scraped
+type Collector struct {
+	Client client.Client
+}
+
+var _ prometheus.Collector = &Collector{}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- databasesDesc
+	ch <- backupsDesc
+	ch <- lastBackupDesc
+}
+
+type phaseKey struct {
+	namespace, provider, phase string
+}
+
+type databaseKey struct {
+	namespace, name string
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	databases := &dbv1alpha1.DatabaseList{}
+	if err := c.Client.List(context.TODO(), &client.ListOptions{}, databases); err != nil {
+		log.Error(err, "Unable to list databases")
+		return
+	}
+	backups := &dbv1alpha1.BackupList{}
+	if err := c.Client.List(context.TODO(), &client.ListOptions{}, backups); err != nil {
+		log.Error(err, "Unable to list backups")
+		return
+	}
+
+	providers := map[databaseKey]string{}
+	counts := map[phaseKey]int{}
+	for i := range databases.Items {
+		db := &databases.Items[i]
+		// Databases on an instance take its provider
+		provider := db.Spec.Provider
+		if resolved, err := util.ResolveDatabase(c.Client, db); err == nil {
+			provider = resolved.Spec.Provider
+		}
+		providers[databaseKey{db.Namespace, db.Name}] = provider
+		counts[phaseKey{db.Namespace, provider, string(db.Status.Phase)}]++
+	}
+	for k, n := range counts {
+		ch <- prometheus.MustNewConstMetric(databasesDesc, prometheus.GaugeValue, float64(n), k.namespace, k.provider, k.phase)
+	}
+
+	counts = map[phaseKey]int{}
+	last := map[databaseKey]float64{}
+	for _, backup := range backups.Items {
+		key := databaseKey{backup.Namespace, backup.Spec.Database}
+		counts[phaseKey{backup.Namespace, providers[key], string(backup.Status.Phase)}]++
+		if backup.Status.Phase != dbv1alpha1.Completed || backup.Status.CompletionTime == nil {
+			continue
+		}
+		if t := float64(backup.Status.CompletionTime.Unix()); t > last[key] {
+			last[key] = t
+		}
+	}
+	for k, n := range counts {
+		ch <- prometheus.MustNewConstMetric(backupsDesc, prometheus.GaugeValue, float64(n), k.namespace, k.provider, k.phase)
+	}
+	for k, t := range last {
+		ch <- prometheus.MustNewConstMetric(lastBackupDesc, prometheus.GaugeValue, t, k.namespace, providers[k], k.name)
+	}
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// gather returns the value of each metric from the collectors, keyed by its
+// name and label values, which are sorted by label name
+func gather(t *testing.T, cs ...prometheus.Collector) map[string]float64 {
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(cs...)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Unable to gather metrics: %s", err)
+	}
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.Metric {
+			key := family.GetName()
+			for _, l := range m.Label {
+				key += " " + l.GetValue()
+			}
+			values[key] = value(m)
+		}
+	}
+	return values
+}
+
+func value(m *dto.Metric) float64 {
+	switch {
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Histogram != nil:
+		return m.Histogram.GetSampleSum()
+	}
+	return 0
+}
+
+func testDatabase(name string, phase dbv1alpha1.DatabasePhase) *dbv1alpha1.Database {
+	return &dbv1alpha1.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "testns"},
+		Spec:       dbv1alpha1.DatabaseSpec{Provider: "postgresql"},
+		Status:     dbv1alpha1.DatabaseStatus{Phase: phase},
+	}
+}
+
+func testBackup(name string, phase dbv1alpha1.BackupPhase, completed int64) *dbv1alpha1.Backup {
+	backup := &dbv1alpha1.Backup{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "testns"},
+		Spec:       dbv1alpha1.BackupSpec{Database: "db1"},
+		Status:     dbv1alpha1.BackupStatus{Phase: phase},
+	}
+	if completed != 0 {
+		t := metav1.NewTime(time.Unix(completed, 0))
+		backup.Status.CompletionTime = &t
+	}
+	return backup
+}
+
+func TestCollector(t *testing.T) {
+	s := scheme.Scheme
+	s.AddKnownTypes(dbv1alpha1.SchemeGroupVersion,
+		&dbv1alpha1.Database{}, &dbv1alpha1.DatabaseList{},
+		&dbv1alpha1.Backup{}, &dbv1alpha1.BackupList{})
+	objs := []runtime.Object{
+		testDatabase("db1", dbv1alpha1.Created),
+		testDatabase("db2", dbv1alpha1.Created),
+		testDatabase("db3", dbv1alpha1.Creating),
+		testBackup("b1", dbv1alpha1.Completed, 1551405600),
+		testBackup("b2", dbv1alpha1.Completed, 1551492000),
+		testBackup("b3", dbv1alpha1.BackingUp, 0),
+	}
+	values := gather(t, &Collector{Client: fake.NewFakeClient(objs...)})
+	expected := map[string]float64{
+		"db_operator_databases testns Created postgresql":                    2,
+		"db_operator_databases testns Creating postgresql":                   1,
+		"db_operator_backups testns Completed postgresql":                    2,
+		"db_operator_backups testns BackingUp postgresql":                    1,
+		"db_operator_last_successful_backup_timestamp db1 testns postgresql": 1551492000,
+	}
+	for k, v := range expected {
+		if values[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, values[k])
+		}
+	}
+	if len(values) != len(expected) {
+		t.Errorf("Expected %d metrics, got %v", len(expected), values)
+	}
+}
+
+func TestObserveJob(t *testing.T) {
+	start := metav1.NewTime(time.Unix(1551405600, 0))
+	end := metav1.NewTime(start.Add(90 * time.Second))
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "db1-create",
+			Namespace: "otherns",
+			Labels:    map[string]string{"operation": "create", "provider": "postgresql"},
+		},
+		Status: batchv1.JobStatus{StartTime: &start, CompletionTime: &end},
+	}
+	ObserveJob(job, true)
+	ObserveJob(job, false)
+	values := gather(t, OperationDuration, JobFailures)
+	if v := values["db_operator_operation_duration_seconds otherns create postgresql"]; v != 90 {
+		t.Errorf("Expected duration of 90s, got %v", v)
+	}
+	if v := values["db_operator_driver_job_failures_total otherns create postgresql"]; v != 1 {
+		t.Errorf("Expected 1 failure, got %v", v)
+	}
+}
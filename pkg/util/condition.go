@@ -0,0 +1,161 @@
+package util
+
+import (
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FindCondition returns the condition of the given type, or nil if it has
+// not been set
+func FindCondition(conditions []dbv1alpha1.Condition, t dbv1alpha1.ConditionType) *dbv1alpha1.Condition {
+	for i := range conditions {
+		if conditions[i].Type == t {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds or replaces the condition of the same type. The
+// transition time is only changed when the status changes
+// returns true if a change was made, and false if there were no changes
+// This allows the calling code to decide whether to update the object
+func SetCondition(conditions *[]dbv1alpha1.Condition, condition dbv1alpha1.Condition) bool {
+	existing := FindCondition(*conditions, condition.Type)
+	if existing == nil {
+		if condition.LastTransitionTime.IsZero() {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		*conditions = append(*conditions, condition)
+		return true
+	}
+	if existing.Status != condition.Status {
+		condition.LastTransitionTime = metav1.Now()
+	} else {
+		condition.LastTransitionTime = existing.LastTransitionTime
+	}
+	if *existing == condition {
+		return false
+	}
+	*existing = condition
+	return true
+}
+
+// RemoveCondition removes the condition of the given type, returning true
+// if it was there
+func RemoveCondition(conditions *[]dbv1alpha1.Condition, t dbv1alpha1.ConditionType) bool {
+	for i := range *conditions {
+		if (*conditions)[i].Type == t {
+			*conditions = append((*conditions)[:i], (*conditions)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Reasons given by conditions and events that are not simply the phase
+const (
+	AsExpectedReason          = "AsExpected"
+	JobFailedReason           = "JobFailed"
+	JobLaunchedReason         = "JobLaunched"
+	DriverErrorReason         = "DriverError"
+	SecretMissingReason       = "SecretMissing"
+	ProviderMissingReason     = "ProviderMissing"
+	ProviderUnhealthyReason   = "ProviderUnhealthy"
+	InstanceUnavailableReason = "InstanceUnavailable"
+	CredentialsFailedReason   = "CredentialsFailed"
+)
+
+// databaseReadyPhases are those in which the database can be used
+var databaseReadyPhases = map[dbv1alpha1.DatabasePhase]bool{
+	dbv1alpha1.Created:            true,
+	dbv1alpha1.BackupRequested:    true,
+	dbv1alpha1.BackupInProgress:   true,
+	dbv1alpha1.BackupCompleted:    true,
+	dbv1alpha1.RotationRequested:  true,
+	dbv1alpha1.RotationInProgress: true,
+}
+
+// phaseReason returns the phase as the reason for a condition
+func phaseReason(phase string) string {
+	if phase == "" {
+		return "Pending"
+	}
+	return phase
+}
+
+// DatabasePhaseConditions returns the Ready and Progressing conditions
+// implied by the phase of the database
+func DatabasePhaseConditions(db *dbv1alpha1.Database) (ready, progressing dbv1alpha1.Condition) {
+	phase := db.Status.Phase
+	reason := phaseReason(string(phase))
+	ready = dbv1alpha1.Condition{
+		Type:               dbv1alpha1.ConditionReady,
+		Status:             dbv1alpha1.ConditionFalse,
+		ObservedGeneration: db.Generation,
+		Reason:             reason,
+		Message:            "Database is " + reason,
+	}
+	if databaseReadyPhases[phase] {
+		ready.Status = dbv1alpha1.ConditionTrue
+	}
+	progressing = dbv1alpha1.Condition{
+		Type:               dbv1alpha1.ConditionProgressing,
+		Status:             dbv1alpha1.ConditionTrue,
+		ObservedGeneration: db.Generation,
+		Reason:             reason,
+		Message:            "Database is " + reason,
+	}
+	if phase == "" || phase == dbv1alpha1.Created || phase == dbv1alpha1.Deleted {
+		progressing.Status = dbv1alpha1.ConditionFalse
+	}
+	return ready, progressing
+}
+
+// BackupPhaseConditions returns the Ready and Progressing conditions
+// implied by the phase of the backup
+func BackupPhaseConditions(backup *dbv1alpha1.Backup) (ready, progressing dbv1alpha1.Condition) {
+	phase := backup.Status.Phase
+	reason := phaseReason(string(phase))
+	ready = dbv1alpha1.Condition{
+		Type:               dbv1alpha1.ConditionReady,
+		Status:             dbv1alpha1.ConditionFalse,
+		ObservedGeneration: backup.Generation,
+		Reason:             reason,
+		Message:            "Backup is " + reason,
+	}
+	if phase == dbv1alpha1.Completed {
+		ready.Status = dbv1alpha1.ConditionTrue
+	}
+	progressing = dbv1alpha1.Condition{
+		Type:               dbv1alpha1.ConditionProgressing,
+		Status:             dbv1alpha1.ConditionFalse,
+		ObservedGeneration: backup.Generation,
+		Reason:             reason,
+		Message:            "Backup is " + reason,
+	}
+	if phase == dbv1alpha1.Starting || phase == dbv1alpha1.BackingUp || phase == dbv1alpha1.Pruning {
+		progressing.Status = dbv1alpha1.ConditionTrue
+	}
+	return ready, progressing
+}
+
+// Degraded returns the Degraded condition of a resource with the given
+// generation. It is False, as expected, if err is nil
+func Degraded(generation int64, reason string, err error) dbv1alpha1.Condition {
+	if err == nil {
+		return dbv1alpha1.Condition{
+			Type:               dbv1alpha1.ConditionDegraded,
+			Status:             dbv1alpha1.ConditionFalse,
+			ObservedGeneration: generation,
+			Reason:             AsExpectedReason,
+		}
+	}
+	return dbv1alpha1.Condition{
+		Type:               dbv1alpha1.ConditionDegraded,
+		Status:             dbv1alpha1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            err.Error(),
+	}
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetCondition_New(t *testing.T) {
+	conditions := []dbv1alpha1.Condition{}
+	b := SetCondition(&conditions, dbv1alpha1.Condition{Type: "Verified", Status: dbv1alpha1.ConditionTrue})
+	if !b || len(conditions) != 1 {
+		t.Errorf("SetCondition did not add a condition")
+	}
+	if conditions[0].LastTransitionTime.IsZero() {
+		t.Errorf("SetCondition did not set the transition time")
+	}
+}
+
+func TestSetCondition_Unchanged(t *testing.T) {
+	then := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	conditions := []dbv1alpha1.Condition{
+		{Type: "Verified", Status: dbv1alpha1.ConditionTrue, Reason: "Restored", LastTransitionTime: then},
+	}
+	b := SetCondition(&conditions, dbv1alpha1.Condition{Type: "Verified", Status: dbv1alpha1.ConditionTrue, Reason: "Restored"})
+	if b {
+		t.Errorf("SetCondition returned true when nothing changed")
+	}
+	b = SetCondition(&conditions, dbv1alpha1.Condition{Type: "Verified", Status: dbv1alpha1.ConditionTrue, Reason: "Checked"})
+	if !b || conditions[0].Reason != "Checked" || !conditions[0].LastTransitionTime.Equal(&then) {
+		t.Errorf("SetCondition changed the transition time without a change of status")
+	}
+}
+
+func TestSetCondition_Transition(t *testing.T) {
+	then := metav1.NewTime(time.Now().Add(-time.Hour))
+	conditions := []dbv1alpha1.Condition{
+		{Type: "Verified", Status: dbv1alpha1.ConditionUnknown, LastTransitionTime: then},
+	}
+	SetCondition(&conditions, dbv1alpha1.Condition{Type: "Verified", Status: dbv1alpha1.ConditionFalse})
+	if conditions[0].Status != dbv1alpha1.ConditionFalse || !conditions[0].LastTransitionTime.After(then.Time) {
+		t.Errorf("SetCondition did not record the transition")
+	}
+}
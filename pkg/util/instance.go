@@ -0,0 +1,179 @@
+package util
+
+import (
+	"context"
+	"fmt"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Labels on the driver jobs that run in the namespace of an instance. Owner
+// references cannot cross namespaces, so these name the instance and the
+// resource the job was launched for instead
+const (
+	InstanceLabel       = "db.isotoma.com/instance"
+	OwnerKindLabel      = "db.isotoma.com/owner-kind"
+	OwnerNamespaceLabel = "db.isotoma.com/owner-namespace"
+	OwnerNameLabel      = "db.isotoma.com/owner-name"
+)
+
+// GetInstance returns the instance the database is on, or nil if it is not
+// on one. An instance in another namespace must allow the database's
+func GetInstance(c client.Client, db *dbv1alpha1.Database) (*dbv1alpha1.DatabaseInstance, error) {
+	ref := db.Spec.Instance
+	if ref == nil {
+		return nil, nil
+	}
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = db.Namespace
+	}
+	instance := &dbv1alpha1.DatabaseInstance{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: ref.Name}, instance); err != nil {
+		return nil, err
+	}
+	if !AllowsNamespace(instance, db.Namespace) {
+		return nil, fmt.Errorf("Instance %s/%s does not allow databases in namespace %s", namespace, ref.Name, db.Namespace)
+	}
+	return instance, nil
+}
+
+// MergeInstance gives the database the provider, connection details and
+// credentials of the instance, and its backup destination and retention
+// unless the database has its own
+func MergeInstance(db *dbv1alpha1.Database, instance *dbv1alpha1.DatabaseInstance) {
+	db.Spec.Provider = instance.Spec.Provider
+	db.Spec.Connect = instance.Spec.Connect
+	db.Spec.Credentials = instance.Spec.Credentials
+	if instance.Spec.AwsCredentials != (dbv1alpha1.AwsCredentials{}) {
+		db.Spec.AwsCredentials = instance.Spec.AwsCredentials
+	}
+	if !db.Spec.BackupTo.Configured() {
+		db.Spec.BackupTo = instance.Spec.BackupTo
+	}
+	if db.Spec.Retention == nil {
+		db.Spec.Retention = instance.Spec.Retention
+	}
+}
+
+// ResolveDatabase returns a copy of the database with the details of the
+// instance it is on filled in. The copy must not be written back, as that
+// would copy the master credentials into the database
+func ResolveDatabase(c client.Client, db *dbv1alpha1.Database) (*dbv1alpha1.Database, error) {
+	resolved := db.DeepCopy()
+	instance, err := GetInstance(c, db)
+	if err != nil {
+		return nil, err
+	}
+	if instance != nil {
+		MergeInstance(resolved, instance)
+	}
+	return resolved, nil
+}
+
+// OnInstance returns the databases that are on the named instance
+func OnInstance(databases []dbv1alpha1.Database, namespace, name string) []dbv1alpha1.Database {
+	var found []dbv1alpha1.Database
+	for _, db := range databases {
+		ref := db.Spec.Instance
+		if ref == nil || ref.Name != name {
+			continue
+		}
+		if ref.Namespace == namespace || ref.Namespace == "" && db.Namespace == namespace {
+			found = append(found, db)
+		}
+	}
+	return found
+}
+
+// InstanceOf returns a reference to the instance the database is on, with
+// its namespace filled in, or nil if it is not on one
+func InstanceOf(db *dbv1alpha1.Database) *dbv1alpha1.InstanceRef {
+	if db.Spec.Instance == nil {
+		return nil
+	}
+	ref := db.Spec.Instance.DeepCopy()
+	if ref.Namespace == "" {
+		ref.Namespace = db.Namespace
+	}
+	return ref
+}
+
+// JobNamespace returns the namespace that the driver jobs for resources in
+// namespace, of a database on the instance ref, run in and find their
+// provider in. ref is nil for a database not on an instance. Jobs for a
+// database on an instance in another namespace run in the instance's, with
+// its provider, so that the app team cannot change the image that is given
+// the master credentials
+func JobNamespace(ref *dbv1alpha1.InstanceRef, namespace string) string {
+	if ref == nil || ref.Namespace == "" {
+		return namespace
+	}
+	return ref.Namespace
+}
+
+// DriverJobKey returns the namespace and name of the driver job named name
+// for a resource in namespace, as placed by PlaceDriverJob. The name is
+// shortened if it is too long for a job
+func DriverJobKey(ref *dbv1alpha1.InstanceRef, namespace, name string) types.NamespacedName {
+	jobNamespace := JobNamespace(ref, namespace)
+	if jobNamespace == namespace {
+		return types.NamespacedName{Namespace: namespace, Name: ShortName(name)}
+	}
+	// The instance's namespace is shared by the resources of many
+	return types.NamespacedName{Namespace: jobNamespace, Name: ShortName(namespace + "-" + name)}
+}
+
+// PlaceDriverJob sets the owner of a driver job, launched for the owner
+// resource of kind, and moves it to the namespace of the instance ref if
+// that is another. There it is labelled with the owner, and owned by the
+// instance, though not as its controller, so that it is garbage collected
+// with the instance if not before
+func PlaceDriverJob(c client.Client, scheme *runtime.Scheme, job *batchv1.Job, owner metav1.Object, kind string, ref *dbv1alpha1.InstanceRef) error {
+	key := DriverJobKey(ref, owner.GetNamespace(), job.Name)
+	job.Name = key.Name
+	if key.Namespace == owner.GetNamespace() {
+		return controllerutil.SetControllerReference(owner, job, scheme)
+	}
+	instance := &dbv1alpha1.DatabaseInstance{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Namespace: key.Namespace, Name: ref.Name}, instance); err != nil {
+		return err
+	}
+	job.Namespace = key.Namespace
+	job.Labels[InstanceLabel] = instance.Name
+	job.Labels[OwnerKindLabel] = kind
+	job.Labels[OwnerNamespaceLabel] = owner.GetNamespace()
+	job.Labels[OwnerNameLabel] = owner.GetName()
+	job.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: dbv1alpha1.SchemeGroupVersion.String(),
+		Kind:       "DatabaseInstance",
+		Name:       instance.Name,
+		UID:        instance.UID,
+	}}
+	return nil
+}
+
+// EnqueueJobOwner returns a handler that requeues the resource of kind
+// that a driver job in the namespace of an instance was launched for
+func EnqueueJobOwner(kind string) *handler.EnqueueRequestsFromMapFunc {
+	return &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(o handler.MapObject) []reconcile.Request {
+			labels := o.Meta.GetLabels()
+			if labels[OwnerKindLabel] != kind {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{
+				Namespace: labels[OwnerNamespaceLabel],
+				Name:      labels[OwnerNameLabel],
+			}}}
+		}),
+	}
+}
@@ -0,0 +1,52 @@
+package util
+
+import (
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMergeInstance(t *testing.T) {
+	keep := int32(3)
+	instance := &dbv1alpha1.DatabaseInstance{
+		Spec: dbv1alpha1.DatabaseInstanceSpec{
+			Provider:    "postgresql",
+			Connect:     map[string]string{"host": "db.example.com"},
+			Credentials: dbv1alpha1.Credentials{Username: dbv1alpha1.Credential{Value: "master"}},
+			BackupTo:    dbv1alpha1.BackupTo{S3: dbv1alpha1.S3Backup{Bucket: "instance-bucket"}},
+			Retention:   &dbv1alpha1.RetentionPolicy{KeepLast: &keep},
+		},
+	}
+	db := &dbv1alpha1.Database{}
+	db.Spec.BackupTo.S3.Bucket = "database-bucket"
+	MergeInstance(db, instance)
+	if db.Spec.Provider != "postgresql" || db.Spec.Connect["host"] != "db.example.com" || db.Spec.Credentials.Username.Value != "master" {
+		t.Errorf("Instance details not taken: %v", db.Spec)
+	}
+	if db.Spec.BackupTo.S3.Bucket != "database-bucket" {
+		t.Errorf("Backup destination of the database replaced by %s", db.Spec.BackupTo.S3.Bucket)
+	}
+	if db.Spec.Retention != instance.Spec.Retention {
+		t.Errorf("Retention of the instance not taken: %v", db.Spec.Retention)
+	}
+}
+
+func TestOnInstance(t *testing.T) {
+	db := func(namespace, name string, ref *dbv1alpha1.InstanceRef) dbv1alpha1.Database {
+		return dbv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       dbv1alpha1.DatabaseSpec{Instance: ref},
+		}
+	}
+	databases := []dbv1alpha1.Database{
+		db("dbas", "local", &dbv1alpha1.InstanceRef{Name: "main"}),
+		db("app", "remote", &dbv1alpha1.InstanceRef{Name: "main", Namespace: "dbas"}),
+		db("app", "other", &dbv1alpha1.InstanceRef{Name: "main"}),
+		db("dbas", "none", nil),
+	}
+	found := OnInstance(databases, "dbas", "main")
+	if len(found) != 2 || found[0].Name != "local" || found[1].Name != "remote" {
+		t.Errorf("Expected local and remote databases, got %v", found)
+	}
+}
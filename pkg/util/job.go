@@ -0,0 +1,137 @@
+package util
+
+import (
+	"context"
+	"fmt"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Operation is the operation a driver job is launched to perform. It is
+// passed to the driver in the DB_OPERATOR_OPERATION environment variable
+type Operation string
+
+const (
+	CreateOperation  Operation = "create"
+	DropOperation    Operation = "drop"
+	BackupOperation  Operation = "backup"
+	RotateOperation  Operation = "rotate"
+	RestoreOperation Operation = "restore"
+	PruneOperation   Operation = "prune"
+	VerifyOperation  Operation = "verify"
+	CloneOperation   Operation = "clone"
+	ProbeOperation   Operation = "probe"
+	CheckOperation   Operation = "check"
+)
+
+// GenerationAnnotation records the generation of the resource a job was
+// launched for, so that the job can be replaced once the resource changes
+const GenerationAnnotation = "db.isotoma.com/generation"
+
+// FailureRecordedAnnotation marks a failed job whose failure has been
+// recorded, so that it is only recorded once
+const FailureRecordedAnnotation = "db.isotoma.com/failure-recorded"
+
+// FindProvider returns the Provider in the namespace whose Spec.Name
+// matches the provider name requested by a database, or nil if there is
+// none
+func FindProvider(c client.Client, namespace, name string) (*dbv1alpha1.Provider, error) {
+	providers := &dbv1alpha1.ProviderList{}
+	if err := c.List(context.TODO(), &client.ListOptions{Namespace: namespace}, providers); err != nil {
+		return nil, err
+	}
+	for i := range providers.Items {
+		if providers.Items[i].Spec.Name == name {
+			return &providers.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// GetProvider returns the Provider in the namespace whose Spec.Name matches
+// the provider name requested by a database, or an error if there is none
+func GetProvider(c client.Client, namespace, name string) (*dbv1alpha1.Provider, error) {
+	provider, err := FindProvider(c, namespace, name)
+	if err == nil && provider == nil {
+		err = fmt.Errorf("No provider %q found in namespace %s", name, namespace)
+	}
+	return provider, err
+}
+
+// DriverJob returns a Job that runs the provider's driver image to perform
+// the operation on the named database. Any extra environment, such as the
+// name of a backup, is passed to the driver too.
+// The caller is responsible for setting the owner of the job
+func DriverJob(provider *dbv1alpha1.Provider, name, namespace string, op Operation, database string, extra ...corev1.EnvVar) *batchv1.Job {
+	env := append([]corev1.EnvVar{
+		{Name: "DB_OPERATOR_NAMESPACE", Value: namespace},
+		{Name: "DB_OPERATOR_DATABASE", Value: database},
+		{Name: "DB_OPERATOR_OPERATION", Value: string(op)},
+	}, extra...)
+	labels := map[string]string{
+		"app":       database,
+		"operation": string(op),
+		"provider":  provider.Spec.Name,
+	}
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: provider.Spec.ServiceAccountName,
+					RestartPolicy:      corev1.RestartPolicyOnFailure,
+					Containers: []corev1.Container{
+						{
+							Name:    "driver",
+							Image:   provider.Spec.Image,
+							Command: provider.Spec.Command,
+							Args:    provider.Spec.Args,
+							Env:     env,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// JobSucceeded returns true if the job has completed successfully
+func JobSucceeded(job *batchv1.Job) bool {
+	return jobCondition(job, batchv1.JobComplete)
+}
+
+// JobFailed returns true if the job has exhausted its retries
+func JobFailed(job *batchv1.Job) bool {
+	return jobCondition(job, batchv1.JobFailed)
+}
+
+// JobFinishedAt returns when the job succeeded or failed, or nil if it is
+// still running
+func JobFinishedAt(job *batchv1.Job) *metav1.Time {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
+			return &c.LastTransitionTime
+		}
+	}
+	return nil
+}
+
+func jobCondition(job *batchv1.Job, t batchv1.JobConditionType) bool {
+	for _, c := range job.Status.Conditions {
+		if c.Type == t && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// MaxNameLength is the longest name that can be given to a resource that
+// is also used as a label value, such as a job
+const MaxNameLength = 63
+
+// ShortName returns name if it is short enough for MaxNameLength, and
+// otherwise truncates it and appends a hash of the whole, so that
+// different long names stay distinct
+func ShortName(name string) string {
+	if len(name) <= MaxNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := "-" + hex.EncodeToString(sum[:])[:8]
+	return name[:MaxNameLength-len(suffix)] + suffix
+}
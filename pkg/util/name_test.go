@@ -0,0 +1,20 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShortName(t *testing.T) {
+	if name := ShortName("testdb-create"); name != "testdb-create" {
+		t.Errorf("Short name changed to %s", name)
+	}
+	long := strings.Repeat("a", 70)
+	name := ShortName(long + "-create")
+	if len(name) != MaxNameLength || !strings.HasPrefix(name, strings.Repeat("a", 50)) {
+		t.Errorf("Long name not truncated: %s", name)
+	}
+	if ShortName(long+"-delete") == name {
+		t.Errorf("Long names differing after the truncation are the same")
+	}
+}
@@ -0,0 +1,23 @@
+package util
+
+import (
+	"strings"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AllowsNamespace returns true if the object is in the namespace, or its
+// allow annotation lists the namespace
+func AllowsNamespace(obj metav1.Object, namespace string) bool {
+	if obj.GetNamespace() == namespace {
+		return true
+	}
+	for _, ns := range strings.Split(obj.GetAnnotations()[dbv1alpha1.AllowNamespacesAnnotation], ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "*" || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package util
+
+import (
+	"testing"
+
+	dbv1alpha1 "github.com/isotoma/db-operator/pkg/apis/db/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAllowsNamespace(t *testing.T) {
+	cases := []struct {
+		allow     string
+		namespace string
+		allowed   bool
+	}{
+		{"", "source", true},
+		{"", "other", false},
+		{"preview, staging", "staging", true},
+		{"preview,staging", "other", false},
+		{"*", "other", true},
+	}
+	for _, c := range cases {
+		meta := &metav1.ObjectMeta{
+			Namespace:   "source",
+			Annotations: map[string]string{dbv1alpha1.AllowNamespacesAnnotation: c.allow},
+		}
+		if AllowsNamespace(meta, c.namespace) != c.allowed {
+			t.Errorf("Expected %v for %q in %q", c.allowed, c.namespace, c.allow)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"net/url"
+	"path/filepath"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// BackupVolumePath is where driver jobs mount the claims that backups are
+// stored on. Each claim is mounted in a directory named after it
+const BackupVolumePath = "/var/backups/db-operator"
+
+// ClaimPath returns where the named claim is mounted in driver jobs
+func ClaimPath(claim string) string {
+	return filepath.Join(BackupVolumePath, claim)
+}
+
+// BackupClaim returns the claim holding the backup at a pvc:// location, or
+// an empty string if the backup is stored elsewhere
+func BackupClaim(location string) string {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "pvc" {
+		return ""
+	}
+	return u.Host
+}
+
+// MountClaim mounts the named claim into the driver container of the job
+func MountClaim(job *batchv1.Job, claim string) {
+	spec := &job.Spec.Template.Spec
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: "backups",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+		},
+	})
+	container := &spec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      "backups",
+		MountPath: ClaimPath(claim),
+	})
+}
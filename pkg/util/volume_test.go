@@ -0,0 +1,31 @@
+package util
+
+import (
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestBackupClaim(t *testing.T) {
+	if claim := BackupClaim("pvc://backups/testdb/testbackup"); claim != "backups" {
+		t.Errorf("Expected claim backups, got %q", claim)
+	}
+	if claim := BackupClaim("s3://bucket/testdb/testbackup"); claim != "" {
+		t.Errorf("Expected no claim for S3 location, got %q", claim)
+	}
+}
+
+func TestMountClaim(t *testing.T) {
+	job := &batchv1.Job{}
+	job.Spec.Template.Spec.Containers = []corev1.Container{{Name: "driver"}}
+	MountClaim(job, "backups")
+	volumes := job.Spec.Template.Spec.Volumes
+	if len(volumes) != 1 || volumes[0].PersistentVolumeClaim.ClaimName != "backups" {
+		t.Errorf("Claim not added to volumes: %v", volumes)
+	}
+	mounts := job.Spec.Template.Spec.Containers[0].VolumeMounts
+	if len(mounts) != 1 || mounts[0].MountPath != "/var/backups/db-operator/backups" {
+		t.Errorf("Claim not mounted: %v", mounts)
+	}
+}